@@ -0,0 +1,112 @@
+package amqp
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	amqp091 "github.com/rabbitmq/amqp091-go"
+)
+
+// errMalformedEvent is returned when a message can not be decoded as a
+// CloudEvent, such that it is rejected rather than requeued.
+var errMalformedEvent = errors.New("malformed CloudEvent")
+
+// handleFn is the single handler signature to which each of the supported
+// function types is adapted.
+type handleFn func(context.Context, *amqp091.Delivery) error
+
+// newHandleFn adapts f, which must implement either Handler or
+// CloudEventHandler, to a handleFn.
+func newHandleFn(f any) (handleFn, error) {
+	switch h := f.(type) {
+	case Handler:
+		return h.Handle, nil
+	case CloudEventHandler:
+		return func(ctx context.Context, d *amqp091.Delivery) error {
+			e, err := toEvent(d)
+			if err != nil {
+				return err
+			}
+			return h.Handle(ctx, e)
+		}, nil
+	default:
+		return nil, ErrNoHandler
+	}
+}
+
+// toEvent decodes a CloudEvent from a message in either structured content
+// mode (a content type of application/cloudevents+json), or binary content
+// mode, in which attributes are application properties prefixed
+// "cloudEvents:" (or "cloudEvents_") and the message body is the event's
+// data.
+func toEvent(d *amqp091.Delivery) (e cloudevents.Event, err error) {
+	if strings.HasPrefix(d.ContentType, cloudevents.ApplicationCloudEventsJSON) {
+		err = json.Unmarshal(d.Body, &e)
+	} else {
+		e, err = toBinaryEvent(d)
+	}
+	if err == nil {
+		err = e.Validate()
+	}
+	if err != nil {
+		return e, fmt.Errorf("%w: %v", errMalformedEvent, err)
+	}
+	return
+}
+
+func toBinaryEvent(d *amqp091.Delivery) (e cloudevents.Event, err error) {
+	e = cloudevents.NewEvent()
+	attrs := map[string]any{}
+	for k, v := range d.Headers {
+		if attr, ok := cutPrefix(k); ok {
+			attrs[attr] = v
+		}
+	}
+	if v, ok := attrs["specversion"]; ok {
+		e.SetSpecVersion(fmt.Sprint(v)) // before any other attributes
+	}
+	for attr, value := range attrs {
+		v := fmt.Sprint(value)
+		switch attr {
+		case "specversion":
+		case "id":
+			e.SetID(v)
+		case "source":
+			e.SetSource(v)
+		case "type":
+			e.SetType(v)
+		case "subject":
+			e.SetSubject(v)
+		case "dataschema":
+			e.SetDataSchema(v)
+		case "time":
+			t, ok := value.(time.Time) // a timestamp, or else an RFC 3339 string
+			if !ok {
+				if t, err = time.Parse(time.RFC3339Nano, v); err != nil {
+					return e, fmt.Errorf("invalid time %q: %w", v, err)
+				}
+			}
+			e.SetTime(t)
+		default:
+			e.SetExtension(attr, v)
+		}
+	}
+	err = e.SetData(d.ContentType, d.Body)
+	return
+}
+
+// cutPrefix returns the name of the CloudEvents attribute of an application
+// property, and whether it is one.
+func cutPrefix(property string) (string, bool) {
+	for _, prefix := range []string{"cloudEvents:", "cloudEvents_"} {
+		if attr, ok := strings.CutPrefix(property, prefix); ok {
+			return strings.ToLower(attr), true
+		}
+	}
+	return "", false
+}
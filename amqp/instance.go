@@ -0,0 +1,64 @@
+package amqp
+
+import (
+	"context"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	amqp091 "github.com/rabbitmq/amqp091-go"
+)
+
+// Handler is a function instance which can handle an AMQP message.
+//
+// Messages are acknowledged when Handle returns without error, and
+// negatively acknowledged otherwise, such that they are requeued unless
+// AMQP_REQUEUE is false (see Service.Start).  The delivery's own
+// acknowledgement methods should therefore not be used.
+type Handler interface {
+	// Handle a message.
+	Handle(context.Context, *amqp091.Delivery) error
+}
+
+type HandleFunc func(context.Context, *amqp091.Delivery) error
+
+// CloudEventHandler is a function instance which can handle a CloudEvent
+// received as an AMQP message in either binary or structured content mode.
+//
+// A function must implement either Handler or CloudEventHandler.
+type CloudEventHandler interface {
+	// Handle an event.
+	Handle(context.Context, cloudevents.Event) error
+}
+
+// Starter is an instance which has defined the Start hook
+type Starter interface {
+	// Start instance event hook.
+	Start(context.Context, map[string]string) error
+}
+
+// Stopper is an instance which has defined the  Stop hook
+type Stopper interface {
+	// Stop instance event hook.
+	Stop(context.Context) error
+}
+
+// ReadinessReporter is an instance which reports its readiness.
+type ReadinessReporter interface {
+	// Ready to be invoked or not.
+	Ready(context.Context) (bool, error)
+}
+
+// LivenessReporter is an instance which reports it is alive.
+type LivenessReporter interface {
+	// Alive allows the instance to report it's liveness status.
+	Alive(context.Context) (bool, error)
+}
+
+// DefaultHandler is used for simple static function implementations which
+// need only define a single exported function named Handle of type HandleFunc.
+type DefaultHandler struct {
+	Handler HandleFunc
+}
+
+func (f DefaultHandler) Handle(ctx context.Context, d *amqp091.Delivery) error {
+	return f.Handler(ctx, d)
+}
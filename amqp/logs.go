@@ -0,0 +1,59 @@
+package amqp
+
+import (
+	"context"
+
+	"github.com/rs/zerolog"
+
+	"knative.dev/func-go/internal/logs"
+)
+
+func init() {
+	zerolog.TimeFieldFormat = zerolog.TimeFormatUnix
+	SetLogLevel(DefaultLogLevel)
+	SetLogFormat(logFormatFromEnv())
+}
+
+// LoggerFromContext returns a logger for the function to use while handling the
+// message with the given context.  It includes fields which correlate its log
+// lines with those of the runtime, such as the "message_id" of the message.
+// The global logger is returned if the context has no logger, such as outside
+// of a message.
+func LoggerFromContext(ctx context.Context) zerolog.Logger {
+	return logs.FromContext(ctx)
+}
+
+type logLevel zerolog.Level
+
+const (
+	LogDebug    = logLevel(zerolog.DebugLevel)
+	LogInfo     = logLevel(zerolog.InfoLevel)
+	LogWarn     = logLevel(zerolog.WarnLevel)
+	LogDisabled = logLevel(zerolog.Disabled)
+)
+
+// SetLogLevel to LogDebug, LogInfo, LogWarn, or LogDisabled
+// Errors are always returned as values.
+func SetLogLevel(l logLevel) {
+	zerolog.SetGlobalLevel(zerolog.Level(l))
+}
+
+type logFormat int
+
+const (
+	LogJSON    = logFormat(logs.JSON)    // structured output suitable for log pipelines
+	LogConsole = logFormat(logs.Console) // human-friendly output for local development
+)
+
+// SetLogFormat to LogJSON (the default) or LogConsole.
+// Can also be set using the environment variable FUNC_LOG_FORMAT with a value
+// of either "json" or "console".
+func SetLogFormat(f logFormat) {
+	logs.SetFormat(logs.Format(f))
+}
+
+// logFormatFromEnv returns the log format requested by FUNC_LOG_FORMAT,
+// defaulting to DefaultLogFormat.
+func logFormatFromEnv() logFormat {
+	return logFormat(logs.FormatFromEnv(logs.Format(DefaultLogFormat)))
+}
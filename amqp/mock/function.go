@@ -0,0 +1,34 @@
+package mock
+
+import (
+	"context"
+
+	amqp091 "github.com/rabbitmq/amqp091-go"
+)
+
+type Function struct {
+	OnStart  func(context.Context, map[string]string) error
+	OnStop   func(context.Context) error
+	OnHandle func(context.Context, *amqp091.Delivery) error
+}
+
+func (f *Function) Start(ctx context.Context, cfg map[string]string) error {
+	if f.OnStart != nil {
+		return f.OnStart(ctx, cfg)
+	}
+	return nil
+}
+
+func (f *Function) Stop(ctx context.Context) error {
+	if f.OnStop != nil {
+		return f.OnStop(ctx)
+	}
+	return nil
+}
+
+func (f *Function) Handle(ctx context.Context, d *amqp091.Delivery) error {
+	if f.OnHandle != nil {
+		return f.OnHandle(ctx, d)
+	}
+	return nil
+}
@@ -0,0 +1,4 @@
+package amqp
+
+// Option configures a Service.
+type Option func(*Service)
@@ -0,0 +1,389 @@
+package amqp
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	amqp091 "github.com/rabbitmq/amqp091-go"
+	"knative.dev/func-go/amqp/mock"
+)
+
+// fakeAcknowledger reports how a delivery was acknowledged.
+type fakeAcknowledger struct {
+	acked chan string // "ack", "nack", "requeue" or "reject"
+}
+
+func (a *fakeAcknowledger) Ack(uint64, bool) error { a.acked <- "ack"; return nil }
+func (a *fakeAcknowledger) Nack(_ uint64, _ bool, requeue bool) error {
+	if requeue {
+		a.acked <- "requeue"
+	} else {
+		a.acked <- "nack"
+	}
+	return nil
+}
+func (a *fakeAcknowledger) Reject(uint64, bool) error { a.acked <- "reject"; return nil }
+
+// newDelivery returns a delivery with the given content type, headers and
+// body, and its acknowledger.
+func newDelivery(contentType string, headers amqp091.Table, body string) (*amqp091.Delivery, *fakeAcknowledger) {
+	a := &fakeAcknowledger{acked: make(chan string, 1)}
+	return &amqp091.Delivery{
+		Acknowledger: a,
+		ContentType:  contentType,
+		Headers:      headers,
+		Body:         []byte(body),
+	}, a
+}
+
+// acknowledgement returns how the delivery was acknowledged.
+func (a *fakeAcknowledger) acknowledgement(t *testing.T) string {
+	t.Helper()
+	select {
+	case ack := <-a.acked:
+		return ack
+	case <-time.After(time.Second):
+		t.Fatal("message was not acknowledged")
+		return ""
+	}
+}
+
+// fakeSubscriber delivers messages sent on msgs in place of a server, and
+// reports the subscription closed once msgs is closed.
+type fakeSubscriber struct {
+	msgs         chan *amqp091.Delivery
+	cfg          amqpConfig
+	disconnected chan struct{}
+}
+
+func (f *fakeSubscriber) subscribe(_ context.Context, cfg amqpConfig, handle func(*amqp091.Delivery), closed func(error)) (func(), func(), error) {
+	f.cfg = cfg
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case d, ok := <-f.msgs:
+				if !ok {
+					closed(errors.New("connection closed"))
+					return
+				}
+				go handle(d)
+			case <-done:
+				return
+			}
+		}
+	}()
+	return func() { close(done) }, func() { close(f.disconnected) }, nil
+}
+
+// startService starts a Service for the given function using a fake
+// subscriber, returning once it has subscribed.  The service is stopped when
+// the test completes.
+func startService(t *testing.T, f any, options ...Option) (*Service, *fakeSubscriber) {
+	t.Helper()
+	t.Setenv("LISTEN_ADDRESS", "127.0.0.1:") // use an OS-chosen port
+	t.Setenv("AMQP_QUEUE", "example-queue")
+
+	var (
+		ctx, cancel = context.WithCancel(context.Background())
+		errCh       = make(chan error, 1)
+		sub         = &fakeSubscriber{msgs: make(chan *amqp091.Delivery), disconnected: make(chan struct{})}
+	)
+	service := New(f, append(options, func(s *Service) { s.subscribe = sub.subscribe })...)
+	go func() {
+		errCh <- service.Start(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		select {
+		case <-errCh:
+		case <-time.After(time.Second):
+			t.Error("service failed to stop")
+		}
+	})
+
+	select {
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("function failed to subscribe")
+	case err := <-errCh:
+		t.Fatal(err)
+	case <-service.started:
+	}
+	return service, sub
+}
+
+// TestStart_Invoked ensures that the Start method of a function is invoked
+// before subscribing, and that the subscription is configured from the
+// environment.
+func TestStart_Invoked(t *testing.T) {
+	t.Setenv("AMQP_PREFETCH", "5")
+	var started bool
+	f := &mock.Function{OnStart: func(context.Context, map[string]string) error {
+		started = true
+		return nil
+	}}
+	_, sub := startService(t, f)
+	if !started {
+		t.Fatal("function was subscribed before being started")
+	}
+	if sub.cfg.queue != "example-queue" || sub.cfg.url != DefaultURL || sub.cfg.prefetch != 5 || !sub.cfg.requeue {
+		t.Fatalf("unexpected config %+v", sub.cfg)
+	}
+}
+
+// TestStart_Errors ensures that a function which implements no handler, or
+// which is started without a queue or with an invalid prefetch count, fails
+// to start.
+func TestStart_Errors(t *testing.T) {
+	t.Setenv("AMQP_QUEUE", "example-queue")
+	if err := New(struct{}{}).Start(context.Background()); !errors.Is(err, ErrNoHandler) {
+		t.Fatalf("expected ErrNoHandler, got %v", err)
+	}
+	t.Setenv("AMQP_PREFETCH", "0")
+	if err := New(&mock.Function{}).Start(context.Background()); err == nil {
+		t.Fatal("expected an invalid AMQP_PREFETCH to fail")
+	}
+	t.Setenv("AMQP_QUEUE", "")
+	if err := New(&mock.Function{}).Start(context.Background()); !errors.Is(err, ErrNoQueue) {
+		t.Fatalf("expected ErrNoQueue, got %v", err)
+	}
+}
+
+// TestHandle_Ack ensures that messages are acknowledged when handled
+// successfully, and negatively acknowledged and requeued otherwise.
+func TestHandle_Ack(t *testing.T) {
+	f := &mock.Function{OnHandle: func(_ context.Context, d *amqp091.Delivery) error {
+		if string(d.Body) == "fail" {
+			return errors.New("handler error")
+		}
+		return nil
+	}}
+	_, sub := startService(t, f)
+
+	ok, a := newDelivery("text/plain", nil, "ok")
+	sub.msgs <- ok
+	if ack := a.acknowledgement(t); ack != "ack" {
+		t.Fatalf("expected ack, got %v", ack)
+	}
+	fail, a := newDelivery("text/plain", nil, "fail")
+	sub.msgs <- fail
+	if ack := a.acknowledgement(t); ack != "requeue" {
+		t.Fatalf("expected requeue, got %v", ack)
+	}
+}
+
+// TestHandle_NoRequeue ensures that messages whose handling fails are not
+// requeued when requeuing is disabled.
+func TestHandle_NoRequeue(t *testing.T) {
+	t.Setenv("AMQP_REQUEUE", "false")
+	f := &mock.Function{OnHandle: func(context.Context, *amqp091.Delivery) error {
+		return errors.New("handler error")
+	}}
+	_, sub := startService(t, f)
+
+	d, a := newDelivery("text/plain", nil, "fail")
+	sub.msgs <- d
+	if ack := a.acknowledgement(t); ack != "nack" {
+		t.Fatalf("expected nack, got %v", ack)
+	}
+}
+
+// ceFunction is a function which handles CloudEvents.
+type ceFunction struct {
+	events chan cloudevents.Event
+}
+
+func (f *ceFunction) Handle(_ context.Context, e cloudevents.Event) error {
+	f.events <- e
+	return nil
+}
+
+// TestHandle_CloudEvent ensures that CloudEvents are decoded from messages in
+// binary and structured content modes, and that malformed events are
+// rejected.
+func TestHandle_CloudEvent(t *testing.T) {
+	f := &ceFunction{events: make(chan cloudevents.Event, 1)}
+	_, sub := startService(t, f)
+
+	eventTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	binary, a := newDelivery("application/json", amqp091.Table{
+		"cloudEvents:specversion":  "1.0",
+		"cloudEvents:id":           "1",
+		"cloudEvents:source":       "example/uri",
+		"cloudEvents:type":         "example.type",
+		"cloudEvents:time":         eventTime,
+		"cloudEvents_partitionkey": "key",
+	}, `{"message":"hello"}`)
+	sub.msgs <- binary
+	e := <-f.events
+	if e.ID() != "1" || e.Source() != "example/uri" || e.Type() != "example.type" || !e.Time().Equal(eventTime) {
+		t.Fatalf("unexpected event %v", e)
+	}
+	if e.Extensions()["partitionkey"] != "key" {
+		t.Fatalf("expected extension to be preserved, got %v", e.Extensions())
+	}
+	if string(e.Data()) != `{"message":"hello"}` || e.DataContentType() != "application/json" {
+		t.Fatalf("unexpected event data %q (%v)", e.Data(), e.DataContentType())
+	}
+	if ack := a.acknowledgement(t); ack != "ack" {
+		t.Fatalf("expected ack, got %v", ack)
+	}
+
+	structured, a := newDelivery("application/cloudevents+json", nil,
+		`{"specversion":"1.0","id":"2","source":"example/uri","type":"example.type","data":"hello"}`)
+	sub.msgs <- structured
+	if e := <-f.events; e.ID() != "2" {
+		t.Fatalf("unexpected event %v", e)
+	}
+	if ack := a.acknowledgement(t); ack != "ack" {
+		t.Fatalf("expected ack, got %v", ack)
+	}
+
+	malformed, a := newDelivery("text/plain", amqp091.Table{"cloudEvents:id": "3"}, "hello") // no source or type
+	sub.msgs <- malformed
+	if ack := a.acknowledgement(t); ack != "reject" {
+		t.Fatalf("expected reject, got %v", ack)
+	}
+}
+
+// TestStop_Disconnect ensures that the connection is closed only once any
+// message in-flight has been acknowledged, and before the function's Stop
+// method is invoked.
+func TestStop_Disconnect(t *testing.T) {
+	t.Setenv("LISTEN_ADDRESS", "127.0.0.1:") // use an OS-chosen port
+	t.Setenv("AMQP_QUEUE", "example-queue")
+
+	var (
+		ctx, cancel = context.WithCancel(context.Background())
+		errCh       = make(chan error, 1)
+		invoked     = make(chan any)
+		release     = make(chan any)
+		sub         = &fakeSubscriber{msgs: make(chan *amqp091.Delivery), disconnected: make(chan struct{})}
+		d, a        = newDelivery("text/plain", nil, "hello")
+		stopped     = make(chan bool, 1)
+	)
+	f := &mock.Function{
+		OnHandle: func(context.Context, *amqp091.Delivery) error {
+			close(invoked)
+			<-release
+			return nil
+		},
+		OnStop: func(context.Context) error {
+			select {
+			case <-sub.disconnected:
+				stopped <- true
+			default:
+				stopped <- false
+			}
+			return nil
+		},
+	}
+	service := New(f, func(s *Service) { s.subscribe = sub.subscribe })
+	go func() {
+		errCh <- service.Start(ctx)
+	}()
+
+	go func() { sub.msgs <- d }()
+	<-invoked
+	cancel()
+	time.Sleep(10 * time.Millisecond) // allow shutdown to begin
+	select {
+	case <-sub.disconnected:
+		t.Fatal("disconnected while a message was in-flight")
+	default:
+	}
+	close(release)
+
+	if ack := a.acknowledgement(t); ack != "ack" {
+		t.Fatalf("expected ack, got %v", ack)
+	}
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("service failed to stop")
+	}
+	if !<-stopped {
+		t.Fatal("function was stopped before disconnecting")
+	}
+}
+
+// TestStop_Closed ensures that the service stops with an error should its
+// deliveries end unexpectedly, such as when the connection is lost, rather
+// than remaining healthy without consuming.
+func TestStop_Closed(t *testing.T) {
+	t.Setenv("LISTEN_ADDRESS", "127.0.0.1:") // use an OS-chosen port
+	t.Setenv("AMQP_QUEUE", "example-queue")
+
+	var (
+		errCh = make(chan error, 1)
+		sub   = &fakeSubscriber{msgs: make(chan *amqp091.Delivery), disconnected: make(chan struct{})}
+	)
+	service := New(&mock.Function{}, func(s *Service) { s.subscribe = sub.subscribe })
+	go func() {
+		errCh <- service.Start(context.Background())
+	}()
+	select {
+	case <-service.started:
+	case err := <-errCh:
+		t.Fatal(err)
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("function failed to subscribe")
+	}
+
+	close(sub.msgs)
+	select {
+	case err := <-errCh:
+		if err == nil || !strings.Contains(err.Error(), "connection closed") {
+			t.Fatalf("expected the closed connection to be returned, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("service did not stop once deliveries ended")
+	}
+	select {
+	case <-sub.disconnected:
+	default:
+		t.Fatal("service did not disconnect")
+	}
+}
+
+// TestReady ensures that the function reports ready once subscribed.
+func TestReady(t *testing.T) {
+	service, _ := startService(t, &mock.Function{})
+	resp, err := http.Get("http://" + service.Addr().String() + "/health/readiness")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected http status code: %v", resp.StatusCode)
+	}
+}
+
+// TestConfigFromContext ensures that the function's config is available to
+// its handler using ConfigFromContext.
+func TestConfigFromContext(t *testing.T) {
+	t.Setenv("TEST_CONFIG_VALUE", "example")
+	values := make(chan string, 1)
+	f := &mock.Function{OnHandle: func(ctx context.Context, _ *amqp091.Delivery) error {
+		values <- ConfigFromContext(ctx)["TEST_CONFIG_VALUE"]
+		return nil
+	}}
+	_, sub := startService(t, f)
+
+	d, a := newDelivery("text/plain", nil, "hello")
+	sub.msgs <- d
+	if v := <-values; v != "example" {
+		t.Fatalf("unexpected config value: %q", v)
+	}
+	a.acknowledgement(t)
+}
@@ -0,0 +1,123 @@
+package amqp
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"sync/atomic"
+	"time"
+
+	"github.com/google/uuid"
+	amqp091 "github.com/rabbitmq/amqp091-go"
+)
+
+// amqpConfig is the configuration of the subscription, read from the
+// environment (see Service.Start).
+type amqpConfig struct {
+	url      string
+	queue    string
+	prefetch int
+	requeue  bool
+}
+
+// newAmqpConfig reads the configuration of the subscription from the
+// environment.
+func newAmqpConfig() (cfg amqpConfig, err error) {
+	cfg = amqpConfig{
+		url:      os.Getenv("AMQP_URL"),
+		queue:    os.Getenv("AMQP_QUEUE"),
+		prefetch: DefaultPrefetch,
+		requeue:  true,
+	}
+	if cfg.url == "" {
+		cfg.url = DefaultURL
+	}
+	if cfg.queue == "" {
+		return cfg, ErrNoQueue
+	}
+	if v := os.Getenv("AMQP_PREFETCH"); v != "" {
+		if cfg.prefetch, err = strconv.Atoi(v); err != nil || cfg.prefetch < 1 {
+			return cfg, fmt.Errorf("invalid AMQP_PREFETCH %q", v)
+		}
+	}
+	if v := os.Getenv("AMQP_REQUEUE"); v != "" {
+		if cfg.requeue, err = strconv.ParseBool(v); err != nil {
+			return cfg, fmt.Errorf("invalid AMQP_REQUEUE: %w", err)
+		}
+	}
+	return
+}
+
+// closeErrorTimeout is how long to await the reason the connection or
+// channel closed once deliveries have ended unexpectedly.
+const closeErrorTimeout = time.Second
+
+// subscriber consumes from the configured queue, invoking handle for each
+// message delivered until the returned cancel function is invoked.  Messages
+// may be handled concurrently, up to the prefetch count.  Should deliveries
+// end before then, such as when the connection is lost, closed is invoked
+// with the reason.  The returned close function closes the connection once
+// those in-flight are acknowledged.
+type subscriber func(ctx context.Context, cfg amqpConfig, handle func(*amqp091.Delivery), closed func(error)) (cancel, close func(), err error)
+
+// amqpSubscriber consumes using a channel limited to the prefetch count of
+// unacknowledged messages.
+func amqpSubscriber(_ context.Context, cfg amqpConfig, handle func(*amqp091.Delivery), closed func(error)) (cancel, close func(), err error) {
+	conn, err := amqp091.Dial(cfg.url)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			_ = conn.Close()
+		}
+	}()
+	ch, err := conn.Channel()
+	if err != nil {
+		return
+	}
+	if err = ch.Qos(cfg.prefetch, 0, false); err != nil {
+		return
+	}
+	var (
+		connClosed = conn.NotifyClose(make(chan *amqp091.Error, 1))
+		chClosed   = ch.NotifyClose(make(chan *amqp091.Error, 1))
+		canceled   atomic.Bool
+	)
+	tag := "func-" + uuid.NewString()
+	deliveries, err := ch.Consume(cfg.queue, tag, false, false, false, false, nil)
+	if err != nil {
+		return
+	}
+	go func() {
+		for d := range deliveries {
+			go handle(&d)
+		}
+		if !canceled.Load() {
+			closed(closeError(connClosed, chClosed))
+		}
+	}()
+	return func() { canceled.Store(true); _ = ch.Cancel(tag, false) },
+		func() { _ = conn.Close() },
+		nil
+}
+
+// closeError returns the reason the connection or channel was closed, or a
+// generic error should neither report one promptly, such as when the
+// consumer was canceled by the server because the queue was deleted.
+func closeError(connClosed, chClosed <-chan *amqp091.Error) error {
+	select {
+	case err := <-connClosed:
+		if err != nil {
+			return fmt.Errorf("connection closed: %w", err)
+		}
+	case err := <-chClosed:
+		if err != nil {
+			return fmt.Errorf("channel closed: %w", err)
+		}
+	case <-time.After(closeErrorTimeout):
+	}
+	return errors.New("deliveries ended unexpectedly")
+}
@@ -0,0 +1,25 @@
+package amqp
+
+import (
+	"fmt"
+
+	"github.com/rs/zerolog/log"
+
+	"knative.dev/func-go/internal/logs"
+)
+
+// logSummary logs the effective configuration of the service once
+// listening, such that misconfiguration (for example of the environment)
+// is apparent from a single log line.  Credentials in the server URL are
+// redacted.
+func (s *Service) logSummary(cfg amqpConfig) {
+	log.Info().
+		Str("address", s.listener.Addr().String()).
+		Str("url", logs.RedactURL(cfg.url)).
+		Str("queue", cfg.queue).
+		Int("prefetch", cfg.prefetch).
+		Bool("requeue", cfg.requeue).
+		Str("handler", fmt.Sprintf("%T", s.f)).
+		Strs("implements", implemented(s.f)).
+		Msg("function runtime configured")
+}
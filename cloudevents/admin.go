@@ -0,0 +1,98 @@
+package cloudevents
+
+import (
+	"crypto/subtle"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+
+	"github.com/rs/zerolog/log"
+)
+
+// WithAdminEndpoints enables the administrative endpoint /admin/drain, which
+// allows external orchestration (such as a blue/green rollout) to drain the
+// function before it is stopped:
+//
+//	POST /admin/drain    begin draining
+//	DELETE /admin/drain  cease draining
+//
+// While draining the function reports not ready (503) and refuses new
+// requests (503), while those in-flight are allowed to complete.  Unlike a
+// SIGTERM, the process is not stopped.
+//
+// Security: the endpoints require a token set using WithAdminToken, and
+// refuse (403) requests without it.  The address of a request is not
+// trusted, as behind Knative every request arrives from the queue-proxy on
+// a loopback address.
+func WithAdminEndpoints() Option {
+	return func(s *Service) {
+		s.admin = true
+	}
+}
+
+// WithAdminToken sets the token which requests to the administrative
+// endpoints enabled by WithAdminEndpoints must present as a bearer token
+// ("Authorization: Bearer <token>").  Without it they refuse all requests.
+func WithAdminToken(token string) Option {
+	return func(s *Service) {
+		s.adminToken = token
+	}
+}
+
+// drainer tracks whether the function is draining.
+type drainer struct {
+	draining atomic.Bool
+}
+
+// Drain sets whether the function is draining: reporting not ready and
+// refusing new requests while allowing those in-flight to complete.  This
+// is what is invoked by the /admin/drain endpoint (see WithAdminEndpoints).
+func (d *drainer) Drain(draining bool) {
+	d.draining.Store(draining)
+}
+
+// refuseWhileDraining wraps the handler such that new requests are refused
+// while draining.
+func (s *Service) refuseWhileDraining(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.draining.Load() {
+			w.Header().Set("Connection", "close")
+			http.Error(w, "function draining", http.StatusServiceUnavailable)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// handleDrain begins (POST) or ceases (DELETE) draining.
+func (s *Service) handleDrain(w http.ResponseWriter, r *http.Request) {
+	if !s.authorizeAdmin(r) {
+		http.Error(w, "forbidden", http.StatusForbidden)
+		return
+	}
+	switch r.Method {
+	case http.MethodPost:
+		log.Info().Msg("function draining")
+		s.Drain(true)
+		w.WriteHeader(http.StatusAccepted)
+		fmt.Fprintln(w, "draining")
+	case http.MethodDelete:
+		log.Info().Msg("function no longer draining")
+		s.Drain(false)
+		fmt.Fprintln(w, "not draining")
+	default:
+		w.Header().Set("Allow", "POST, DELETE")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+// authorizeAdmin returns true if the request bears the admin token.  No
+// request is authorized if no token is set.
+func (s *Service) authorizeAdmin(r *http.Request) bool {
+	if s.adminToken == "" {
+		return false
+	}
+	got := []byte(r.Header.Get("Authorization"))
+	want := []byte("Bearer " + s.adminToken)
+	return subtle.ConstantTimeCompare(got, want) == 1
+}
@@ -0,0 +1,47 @@
+package cloudevents
+
+import (
+	"context"
+	"time"
+
+	"github.com/cloudevents/sdk-go/v2/event"
+	"github.com/cloudevents/sdk-go/v2/protocol"
+)
+
+// WithAuditLog emits a structured audit record for each event processed
+// containing its id, type, source and subject, the result of handling it
+// (success or error) and the duration of the invocation.  An event is
+// successful if handling it returned no error or an ACK result (see
+// protocol.IsACK).
+//
+// Audit records are logged without a level and with the field
+// "logger":"audit" such that they are separable from debug logs and
+// emitted regardless of log level unless logging is disabled.
+func WithAuditLog() Option {
+	return func(s *Service) {
+		s.receiveMiddleware = append(s.receiveMiddleware, audit)
+	}
+}
+
+// audit wraps a receiveFn, logging an audit record for each invocation.
+func audit(next receiveFn) receiveFn {
+	return func(ctx context.Context, e event.Event) ([]event.Event, error) {
+		start := time.Now()
+		out, err := next(ctx, e)
+
+		record := requestLog(ctx).Log().
+			Str("logger", "audit").
+			Str("id", e.ID()).
+			Str("type", e.Type()).
+			Str("source", e.Source()).
+			Str("subject", e.Subject()).
+			Dur("duration", time.Since(start))
+		if protocol.IsACK(err) {
+			record.Str("result", "success")
+		} else {
+			record.Str("result", "error").Err(err)
+		}
+		record.Msg("event processed")
+		return out, err
+	}
+}
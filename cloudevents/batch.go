@@ -0,0 +1,126 @@
+package cloudevents
+
+import (
+	"context"
+	"encoding/json"
+	"mime"
+	"net/http"
+
+	"github.com/cloudevents/sdk-go/v2/event"
+)
+
+type responseKey struct{}
+
+// response is the response to a request which is written by
+// respondBatch in place of the SDK's (empty) response, if any events are set
+// by the receiver.
+type response struct {
+	structured bool // the request was in structured content mode
+	events     []event.Event
+	noReply    bool // the function returned neither an event nor an error
+	filtered   bool // the event did not match FUNC_EVENT_FILTER
+}
+
+// respond adapts a receiveFn to the signature given to the SDK, which
+// responds with at most one event, and always in binary content mode.
+// Several events, or an event in response to a request in structured
+// content mode, are instead placed in the request's response to be written
+// by respondBatch.
+func respond(fn receiveFn) func(context.Context, event.Event) (*event.Event, error) {
+	return func(ctx context.Context, e event.Event) (*event.Event, error) {
+		out, err := fn(ctx, e)
+		resp, _ := ctx.Value(responseKey{}).(*response)
+		switch {
+		case len(out) == 0:
+			if resp != nil && err == nil {
+				resp.noReply = true
+			}
+			return nil, err
+		case len(out) == 1 && (resp == nil || !resp.structured):
+			return &out[0], err
+		}
+		for _, o := range out {
+			if verr := o.Validate(); verr != nil {
+				return nil, verr
+			}
+		}
+		if resp != nil {
+			resp.events = out
+		}
+		return nil, err
+	}
+}
+
+// respondBatch wraps the SDK's handler such that several response events
+// are written as a batch, and a response event to a request in structured
+// content mode is written in structured content mode, in place of its
+// (empty) response.  The SDK otherwise responds in binary content mode.
+// A successful response without an event has the status noReply, if not 0,
+// rather than the SDK's 200.
+func respondBatch(next http.Handler, noReply int) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mediaType, _, _ := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		bw := &batchResponseWriter{ResponseWriter: w, noReply: noReply}
+		bw.response.structured = mediaType == event.ApplicationCloudEventsJSON
+		ctx := context.WithValue(r.Context(), responseKey{}, &bw.response)
+		next.ServeHTTP(bw, r.WithContext(ctx))
+	})
+}
+
+// batchResponseWriter writes the response events, if any, in place of a
+// successful response.  They are set by the receiver before the SDK
+// responds.
+type batchResponseWriter struct {
+	http.ResponseWriter
+	response   response
+	noReply    int
+	wroteBatch bool
+}
+
+func (w *batchResponseWriter) WriteHeader(code int) {
+	events := w.response.events
+	switch {
+	case code != http.StatusOK:
+	case w.response.filtered:
+		code = http.StatusNoContent
+	case w.response.noReply && w.noReply != 0:
+		code = w.noReply
+	}
+	if len(events) == 0 || code < 200 || code > 299 {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	var (
+		b           []byte
+		err         error
+		contentType = event.ApplicationCloudEventsBatchJSON
+	)
+	if len(events) == 1 && w.response.structured {
+		b, err = json.Marshal(events[0])
+		contentType = event.ApplicationCloudEventsJSON
+	} else {
+		b, err = json.Marshal(events)
+	}
+	w.wroteBatch = true
+	if err != nil {
+		http.Error(w.ResponseWriter, "error encoding response events", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Del("Content-Length")
+	w.ResponseWriter.WriteHeader(code)
+	_, _ = w.ResponseWriter.Write(b)
+}
+
+func (w *batchResponseWriter) Write(b []byte) (int, error) {
+	if w.wroteBatch {
+		return len(b), nil // the SDK's empty body
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap returns the underlying ResponseWriter, for use by
+// http.ResponseController.
+func (w *batchResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,110 @@
+package cloudevents
+
+import (
+	"compress/gzip"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// WithResponseCompression gzip-encodes the body of responses (such as a
+// returned structured CloudEvent) when the request indicates it accepts
+// gzip encoding.
+func WithResponseCompression() Option {
+	return func(s *Service) {
+		s.compress = true
+	}
+}
+
+var gzipWriters = sync.Pool{
+	New: func() any { return gzip.NewWriter(nil) },
+}
+
+// compress wraps the handler such that responses are gzip-encoded when
+// accepted by the client.
+func compress(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !acceptsGzip(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		gw := &gzipResponseWriter{ResponseWriter: w}
+		defer gw.close()
+		next.ServeHTTP(gw, r)
+	})
+}
+
+// acceptsGzip returns true if the request's Accept-Encoding includes gzip.
+func acceptsGzip(r *http.Request) bool {
+	for _, v := range r.Header.Values("Accept-Encoding") {
+		for _, enc := range strings.Split(v, ",") {
+			enc, _, _ = strings.Cut(strings.TrimSpace(enc), ";")
+			if strings.EqualFold(enc, "gzip") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// gzipResponseWriter defers writing the status code until the first write
+// of the body, at which point the response is marked as gzip-encoded.
+// Responses without a body are written unencoded.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	gz          *gzip.Writer
+	status      int
+	wroteHeader bool
+}
+
+func (w *gzipResponseWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+}
+
+func (w *gzipResponseWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		if len(b) == 0 {
+			return 0, nil
+		}
+		w.writeHeader(w.Header().Get("Content-Encoding") == "")
+	}
+	if w.gz == nil {
+		return w.ResponseWriter.Write(b)
+	}
+	return w.gz.Write(b)
+}
+
+// writeHeader writes the deferred status code, first marking the response
+// as gzip-encoded if requested.
+func (w *gzipResponseWriter) writeHeader(encode bool) {
+	w.wroteHeader = true
+	if encode {
+		h := w.Header()
+		h.Del("Content-Length")
+		h.Set("Content-Encoding", "gzip")
+		h.Add("Vary", "Accept-Encoding")
+		w.gz = gzipWriters.Get().(*gzip.Writer)
+		w.gz.Reset(w.ResponseWriter)
+	}
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+}
+
+// close flushes any encoded content, or writes the deferred status code if
+// there was no body.
+func (w *gzipResponseWriter) close() {
+	if !w.wroteHeader {
+		if w.status != 0 {
+			w.writeHeader(false)
+		}
+		return
+	}
+	if w.gz != nil {
+		_ = w.gz.Close()
+		gzipWriters.Put(w.gz)
+	}
+}
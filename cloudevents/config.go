@@ -0,0 +1,37 @@
+package cloudevents
+
+import (
+	"context"
+	"maps"
+	"net/http"
+)
+
+type configKey struct{}
+
+// ConfigFromContext returns the function's config, as passed to its Start
+// hook, from the context of a request.  This allows a function which does
+// not implement Start, such as a static function, to read its config.  It
+// returns nil if the context is not that of a request to the function.
+// The map returned is a copy, which the function may modify.
+func ConfigFromContext(ctx context.Context) map[string]string {
+	cfg, _ := ctx.Value(configKey{}).(map[string]string)
+	return maps.Clone(cfg)
+}
+
+// config returns the config with which the function instance was last
+// started.
+func (s *Service) config() map[string]string {
+	if cfg := s.cfg.Load(); cfg != nil {
+		return *cfg
+	}
+	return nil
+}
+
+// addConfig wraps the handler such that the config is available to each
+// request using ConfigFromContext.
+func (s *Service) addConfig(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := context.WithValue(r.Context(), configKey{}, s.config())
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
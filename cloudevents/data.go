@@ -0,0 +1,45 @@
+package cloudevents
+
+import (
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/cloudevents/sdk-go/v2/event"
+)
+
+// ErrUnsupportedContentType is returned when decoding the data of an event
+// whose datacontenttype is neither JSON nor XML.
+var ErrUnsupportedContentType = errors.New("unsupported data content type")
+
+// DecodeData decodes the data of the event into a value of type T according
+// to its datacontenttype.  JSON (application/json, text/json and +json
+// types) and XML (application/xml, text/xml and +xml types) are supported.
+// Data without a datacontenttype is decoded as JSON, per the CloudEvents
+// specification.  ErrUnsupportedContentType is returned for other types.
+func DecodeData[T any](e event.Event) (T, error) {
+	var v T
+	switch ct := e.DataMediaType(); {
+	case ct == "" || isMediaType(ct, "json"):
+		if err := json.Unmarshal(e.Data(), &v); err != nil {
+			return v, fmt.Errorf("error decoding JSON event data: %w", err)
+		}
+	case isMediaType(ct, "xml"):
+		if err := xml.Unmarshal(e.Data(), &v); err != nil {
+			return v, fmt.Errorf("error decoding XML event data: %w", err)
+		}
+	default:
+		return v, fmt.Errorf("%w %q", ErrUnsupportedContentType, ct)
+	}
+	return v, nil
+}
+
+// isMediaType returns true if the media type ct is application/{subtype},
+// text/{subtype}, or has the structured syntax suffix +{subtype}.
+func isMediaType(ct, subtype string) bool {
+	ct = strings.ToLower(ct)
+	return ct == "application/"+subtype || ct == "text/"+subtype ||
+		strings.HasSuffix(ct, "+"+subtype)
+}
@@ -0,0 +1,45 @@
+package cloudevents
+
+import (
+	"context"
+	"errors"
+	"net/http"
+
+	"github.com/cloudevents/sdk-go/v2/event"
+	cehttp "github.com/cloudevents/sdk-go/v2/protocol/http"
+)
+
+// HandlerError is an error which a handler may return to respond with the
+// given HTTP status code and message, for example to signal that an event
+// was invalid (400) rather than that handling it failed (500).  This
+// matters to an event source such as a Knative Broker, which retries
+// events which fail with a 5xx status but not those rejected with a 4xx.
+//
+// A cloudevents/v2/protocol/http.Result (see NewResult) may be returned to
+// the same effect.  Any other error results in 500 Internal Server Error.
+type HandlerError struct {
+	Code    int
+	Message string
+}
+
+// Error returns the message, or the text of the status code if empty.
+func (e *HandlerError) Error() string {
+	if e.Message == "" {
+		return http.StatusText(e.Code)
+	}
+	return e.Message
+}
+
+// mapHandlerErrors wraps a receiveFn such that a HandlerError returned
+// (possibly wrapped) by the function is mapped to the SDK's result type,
+// which sets the response's status code and body.
+func mapHandlerErrors(next receiveFn) receiveFn {
+	return func(ctx context.Context, e event.Event) ([]event.Event, error) {
+		out, err := next(ctx, e)
+		var herr *HandlerError
+		if errors.As(err, &herr) {
+			err = cehttp.NewResult(herr.Code, "%s", herr.Error())
+		}
+		return out, err
+	}
+}
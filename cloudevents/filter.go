@@ -0,0 +1,65 @@
+package cloudevents
+
+import (
+	"context"
+	"fmt"
+
+	cesql "github.com/cloudevents/sdk-go/sql/v2"
+	cesqlparser "github.com/cloudevents/sdk-go/sql/v2/parser"
+	"github.com/cloudevents/sdk-go/v2/event"
+)
+
+// newEventFilter returns receive middleware which acknowledges events not
+// matching the CloudEvents SQL expression, such as
+// "type = 'com.example.order' AND subject LIKE 'eu-%'", without invoking
+// the function.  The response to such an event is a 204.  An event for which
+// the expression can not be evaluated, such as one which lacks an attribute
+// it refers to, does not match.  It returns nil if expr is empty.
+//
+// The expression is read from the environment variable FUNC_EVENT_FILTER
+// (see New), such that filtering is off by default.
+func newEventFilter(expr string) (receiveMiddleware, error) {
+	if expr == "" {
+		return nil, nil
+	}
+	parsed, err := parseFilter(expr)
+	if err != nil {
+		return nil, fmt.Errorf("invalid FUNC_EVENT_FILTER %q: %w", expr, err)
+	}
+	return func(next receiveFn) receiveFn {
+		return func(ctx context.Context, e event.Event) ([]event.Event, error) {
+			if !matches(ctx, parsed, e) {
+				if resp, ok := ctx.Value(responseKey{}).(*response); ok {
+					resp.filtered = true
+				}
+				return nil, nil
+			}
+			return next(ctx, e)
+		}
+	}, nil
+}
+
+// parseFilter parses the expression, returning an error rather than the
+// panic with which the parser fails on some invalid expressions.
+func parseFilter(expr string) (parsed cesql.Expression, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			parsed, err = nil, fmt.Errorf("%v", r)
+		}
+	}()
+	return cesqlparser.Parse(expr)
+}
+
+// matches returns true if the expression evaluates to true for the event.
+func matches(ctx context.Context, expr cesql.Expression, e event.Event) bool {
+	v, err := expr.Evaluate(e)
+	if err != nil {
+		requestLog(ctx).Debug().Err(err).Str("id", e.ID()).Msg("error evaluating event filter")
+		return false
+	}
+	match, _ := v.(bool)
+	if !match {
+		requestLog(ctx).Debug().Str("id", e.ID()).Msg("event filtered")
+	}
+	return match
+}
@@ -0,0 +1,73 @@
+package cloudevents
+
+import (
+	"context"
+	"net/http"
+)
+
+type responseHeaderKey struct{}
+
+// ResponseHeader returns the header of the HTTP response to the event with
+// the given context, which the function's handler may modify to set headers
+// (for example Cache-Control or a correlation ID) on the response alongside
+// any event it returns:
+//
+//	func Handle(ctx context.Context, e event.Event) (*event.Event, error) {
+//		fn.ResponseHeader(ctx).Set("Cache-Control", "no-store")
+//		...
+//	}
+//
+// Headers which describe the response event, such as Content-Type and its
+// Ce- attributes, take precedence over those set by the function.  Headers
+// are set on the response even when the event is delivered to a sink.  If
+// the context is not that of a request, modifications are discarded.
+func ResponseHeader(ctx context.Context) http.Header {
+	if h, ok := ctx.Value(responseHeaderKey{}).(http.Header); ok {
+		return h
+	}
+	return http.Header{}
+}
+
+// addResponseHeader wraps the handler such that headers set by the function
+// using ResponseHeader are written with the response.
+func addResponseHeader(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hw := &headerResponseWriter{ResponseWriter: w, header: http.Header{}}
+		ctx := context.WithValue(r.Context(), responseHeaderKey{}, hw.header)
+		next.ServeHTTP(hw, r.WithContext(ctx))
+	})
+}
+
+// headerResponseWriter adds the headers set by the function to those of the
+// response as it is written.
+type headerResponseWriter struct {
+	http.ResponseWriter
+	header      http.Header
+	wroteHeader bool
+}
+
+func (w *headerResponseWriter) WriteHeader(code int) {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		h := w.ResponseWriter.Header()
+		for k, vv := range w.header {
+			if _, ok := h[k]; !ok {
+				h[k] = vv
+			}
+		}
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *headerResponseWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Unwrap returns the underlying ResponseWriter, for use by
+// http.ResponseController.
+func (w *headerResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,44 @@
+// Package httptest provides for testing functions served by the CloudEvents
+// middleware, analogous to net/http/httptest.
+package httptest
+
+import (
+	"context"
+	"fmt"
+
+	ce "knative.dev/func-go/cloudevents"
+)
+
+// NewServer starts a service for the function instance f listening on an
+// OS-chosen port of the loopback interface, returning it along with its
+// base URL (for example "http://127.0.0.1:40111") and a function which stops
+// it.  It blocks until the service is listening and the function's Start
+// hook has returned (see ce.WithSynchronousStart), such that events may be
+// sent immediately.  It panics if the service fails to start.
+//
+// Options are applied before those of NewServer, such that an address set
+// using ce.WithListenAddress is replaced.
+func NewServer(f any, options ...ce.Option) (*ce.Service, string, func()) {
+	var (
+		ctx, cancel = context.WithCancel(context.Background())
+		errCh       = make(chan error, 1)
+	)
+	options = append(options,
+		ce.WithListenAddress("127.0.0.1:0"),
+		ce.WithSynchronousStart())
+	service := ce.New(f, options...)
+	go func() {
+		errCh <- service.Start(ctx)
+	}()
+
+	select {
+	case <-service.Listening():
+		return service, "http://" + service.Addr().String(), func() {
+			cancel()
+			<-errCh
+		}
+	case err := <-errCh:
+		cancel()
+		panic(fmt.Sprintf("httptest: failed to start service: %v", err))
+	}
+}
@@ -0,0 +1,71 @@
+package httptest
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	"github.com/cloudevents/sdk-go/v2/event"
+
+	"knative.dev/func-go/cloudevents/mock"
+)
+
+// TestNewServer ensures that the service is listening, and the function
+// started, once NewServer returns, and that the function is stopped by the
+// cleanup function returned.
+func TestNewServer(t *testing.T) {
+	var started, stopped bool
+	f := &mock.Function{
+		OnStart: func(context.Context, map[string]string) error {
+			started = true
+			return nil
+		},
+		OnStop: func(context.Context) error {
+			stopped = true
+			return nil
+		},
+		OnHandle: func(_ context.Context, e event.Event) (*event.Event, error) {
+			return &e, nil // echo
+		},
+	}
+	_, url, cleanup := NewServer(f)
+	if !started {
+		t.Fatal("function not started")
+	}
+
+	c, err := cloudevents.NewClientHTTP()
+	if err != nil {
+		t.Fatal(err)
+	}
+	e := cloudevents.NewEvent()
+	e.SetID("1")
+	e.SetSource("example/uri")
+	e.SetType("example.type")
+	reply, result := c.Request(cloudevents.ContextWithTarget(context.Background(), url), e)
+	if !cloudevents.IsACK(result) {
+		t.Fatalf("failed to send: %v", result)
+	}
+	if reply == nil || reply.Type() != "example.type" {
+		t.Fatalf("unexpected reply %v", reply)
+	}
+
+	cleanup()
+	if !stopped {
+		t.Fatal("function not stopped")
+	}
+}
+
+// TestNewServer_StartError ensures that NewServer panics if the function
+// fails to start.
+func TestNewServer_StartError(t *testing.T) {
+	f := &mock.Function{OnStart: func(context.Context, map[string]string) error {
+		return errors.New("start failed")
+	}}
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected a panic")
+		}
+	}()
+	NewServer(f)
+}
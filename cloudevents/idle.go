@@ -0,0 +1,76 @@
+package cloudevents
+
+import (
+	"context"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// WithIdleShutdown gracefully shuts down the service once it has not
+// handled a request for the given duration, such that a platform which
+// scales to zero can reclaim the instance.  Requests to the health (and
+// admin) endpoints do not count as activity, and the service is never idle
+// while a request is in-flight.
+func WithIdleShutdown(d time.Duration) Option {
+	return func(s *Service) {
+		s.idleTimeout = d
+	}
+}
+
+// idleTracker tracks requests in-flight and the time of the last activity.
+type idleTracker struct {
+	inflight   atomic.Int64
+	lastActive atomic.Int64 // unix nanoseconds
+}
+
+// touch records activity as of now.
+func (t *idleTracker) touch() {
+	t.lastActive.Store(time.Now().UnixNano())
+}
+
+// trackIdle wraps the handler such that its requests are tracked as
+// activity.
+func (s *Service) trackIdle(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s.idleTracker.inflight.Add(1)
+		s.idleTracker.touch()
+		defer func() {
+			s.idleTracker.touch() // before no longer in-flight
+			s.idleTracker.inflight.Add(-1)
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// watchIdle requests shutdown, via s.stop, once the service has been idle
+// for its idle timeout, or returns when the context is canceled.
+func (s *Service) watchIdle(ctx context.Context) {
+	s.idleTracker.touch()
+	timer := time.NewTimer(s.idleTimeout)
+	defer timer.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timer.C:
+		}
+		inflight := s.idleTracker.inflight.Load() // before lastActive
+		idle := time.Since(time.Unix(0, s.idleTracker.lastActive.Load()))
+		if inflight == 0 && idle >= s.idleTimeout {
+			log.Info().Dur("idle", idle).Msg("function idle. Shutting down")
+			select {
+			case s.stop <- nil:
+			case <-ctx.Done():
+			}
+			return
+		}
+		next := s.idleTimeout - idle
+		if next <= 0 {
+			next = s.idleTimeout
+		}
+		timer.Reset(next)
+	}
+}
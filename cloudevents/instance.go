@@ -2,32 +2,62 @@ package cloudevents
 
 import (
 	"context"
+	"errors"
+	"fmt"
 
 	"github.com/cloudevents/sdk-go/v2/event"
 )
 
 // Handler is a CloudEvent function Handler, which is invoked when it
-// receives a cloud event.  It must implement one of the following methods:
+// receives a cloud event.  It must implement Handle with one of the
+// SupportedSignatures.
 //
-//	Handle()
-//	Handle() error
-//	Handle(context.Context)
-//	Handle(context.Context) error
-//	Handle(event.Event)
-//	Handle(event.Event) error
-//	Handle(context.Context, event.Event)
-//	Handle(context.Context, event.Event) error
-//	Handle(event.Event) *event.Event
-//	Handle(event.Event) (*event.Event, error)
-//	Handle(context.Context, event.Event) *event.Event
-//	Handle(context.Context, event.Event) (*event.Event, error)
+// A function which returns several events responds with them as a batch:
+// a JSON array of structured events with the content type
+// application/cloudevents-batch+json.  If K_SINK is set, each is instead
+// delivered to the sink in turn.  A single event is responded with in the
+// content mode of the request: structured (application/cloudevents+json) if
+// the request was structured, and otherwise binary.
 //
 // It can optionaly implement any of Start, Stop, Ready, and Alive.
 type Handler any
 
+// SupportedSignatures lists the signatures of Handle which a Handler may
+// implement, as a method of an instance or as the Handler of a
+// DefaultHandler.
+const SupportedSignatures = `	Handle()
+	Handle() error
+	Handle(context.Context)
+	Handle(context.Context) error
+	Handle(event.Event)
+	Handle(event.Event) error
+	Handle(context.Context, event.Event)
+	Handle(context.Context, event.Event) error
+	Handle(event.Event) *event.Event
+	Handle(event.Event) (*event.Event, error)
+	Handle(context.Context, event.Event) *event.Event
+	Handle(context.Context, event.Event) (*event.Event, error)
+	Handle(event.Event) ([]event.Event, error)
+	Handle(context.Context, event.Event) ([]event.Event, error)
+	Handle(event.Event) ([]*event.Event, error)
+	Handle(context.Context, event.Event) ([]*event.Event, error)`
+
+// ErrUnsupportedHandler is returned by Start when the function does not
+// implement Handle with one of the SupportedSignatures.
+var ErrUnsupportedHandler = errors.New("function does not implement Handle with a supported signature")
+
+// unsupportedHandler returns an ErrUnsupportedHandler describing the given
+// problem and listing the supported signatures.
+func unsupportedHandler(format string, args ...any) error {
+	return fmt.Errorf("%w: %v; supported signatures are:\n%v",
+		ErrUnsupportedHandler, fmt.Sprintf(format, args...), SupportedSignatures)
+}
+
 // Starter is a function which defines a method to be called on function start.
 type Starter interface {
-	// Start instance event hook.
+	// Start instance event hook.  Its context is canceled should the
+	// service stop while it is in progress, in which case Stop is invoked
+	// only once it has returned.
 	Start(context.Context, map[string]string) error
 }
 
@@ -54,6 +84,10 @@ type LivenessReporter interface {
 // DefaultHandler is used for simple static function implementations which
 // need only define a single exported function named Handle which must be
 // of a signature understood by the CloudEvents SDK.
+//
+// Handler may instead be an instance implementing Handler, in which case
+// any lifecycle hooks it implements (Start, Stop, Ready and Alive) are
+// invoked as they would be were it not wrapped.
 type DefaultHandler struct {
 	Handler any
 }
@@ -161,34 +195,54 @@ type handlerCtxEvtEvt interface {
 type handlerCtxEvtEvtErr interface {
 	Handle(context.Context, event.Event) (*event.Event, error)
 }
+type handlerEvtEvtsErr interface {
+	Handle(event.Event) ([]event.Event, error)
+}
+type handlerCtxEvtEvtsErr interface {
+	Handle(context.Context, event.Event) ([]event.Event, error)
+}
+type handlerEvtEvtPtrsErr interface {
+	Handle(event.Event) ([]*event.Event, error)
+}
+type handlerCtxEvtEvtPtrsErr interface {
+	Handle(context.Context, event.Event) ([]*event.Event, error)
+}
 
-func getReceiverFn(f any) any {
+func getReceiverFn(f any) (any, error) {
 	switch h := f.(type) {
 	case handler:
-		return h.Handle
+		return h.Handle, nil
 	case handlerErr:
-		return h.Handle
+		return h.Handle, nil
 	case handlerCtx:
-		return h.Handle
+		return h.Handle, nil
 	case handlerCtxErr:
-		return h.Handle
+		return h.Handle, nil
 	case handlerEvt:
-		return h.Handle
+		return h.Handle, nil
 	case handlerEvtErr:
-		return h.Handle
+		return h.Handle, nil
 	case handlerCtxEvt:
-		return h.Handle
+		return h.Handle, nil
 	case handlerCtxEvtErr:
-		return h.Handle
+		return h.Handle, nil
 	case handlerEvtEvt:
-		return h.Handle
+		return h.Handle, nil
 	case handlerEvtEvtErr:
-		return h.Handle
+		return h.Handle, nil
 	case handlerCtxEvtEvt:
-		return h.Handle
+		return h.Handle, nil
 	case handlerCtxEvtEvtErr:
-		return h.Handle
+		return h.Handle, nil
+	case handlerEvtEvtsErr:
+		return h.Handle, nil
+	case handlerCtxEvtEvtsErr:
+		return h.Handle, nil
+	case handlerEvtEvtPtrsErr:
+		return h.Handle, nil
+	case handlerCtxEvtEvtPtrsErr:
+		return h.Handle, nil
 	default:
-		panic("Please see the supported function signatures list.")
+		return nil, unsupportedHandler("%T has no Handle method of a supported signature", f)
 	}
 }
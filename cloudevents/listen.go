@@ -0,0 +1,38 @@
+package cloudevents
+
+import (
+	"net"
+	"os"
+	"strings"
+)
+
+// unixScheme prefixes a listen address which is the path of a Unix domain
+// socket, such as "unix:///var/run/function.sock".
+const unixScheme = "unix://"
+
+// listen on the given address, which is either a TCP address such as
+// "127.0.0.1:8080" or the path of a Unix domain socket prefixed with
+// "unix://".  The socket file is removed when the listener is closed.
+func listen(addr string) (net.Listener, error) {
+	path, ok := strings.CutPrefix(addr, unixScheme)
+	if !ok {
+		return net.Listen("tcp", addr)
+	}
+	removeStaleSocket(path)
+	return net.Listen("unix", path)
+}
+
+// removeStaleSocket removes the socket file at path if nothing is listening
+// on it, such as that left by a previous process which did not exit
+// cleanly, which would otherwise prevent listening.
+func removeStaleSocket(path string) {
+	fi, err := os.Stat(path)
+	if err != nil || fi.Mode()&os.ModeSocket == 0 {
+		return
+	}
+	if conn, err := net.Dial("unix", path); err == nil {
+		conn.Close() // in use
+		return
+	}
+	_ = os.Remove(path)
+}
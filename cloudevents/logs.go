@@ -1,10 +1,26 @@
 package cloudevents
 
-import "github.com/rs/zerolog"
+import (
+	"context"
+
+	"github.com/rs/zerolog"
+
+	"knative.dev/func-go/internal/logs"
+)
 
 func init() {
 	zerolog.TimeFieldFormat = zerolog.TimeFormatUnix
 	SetLogLevel(DefaultLogLevel)
+	SetLogFormat(logFormatFromEnv())
+}
+
+// LoggerFromContext returns a logger for the function to use while handling the
+// request with the given context.  It includes fields which correlate its log
+// lines with those of the runtime, such as "request_id" if assigned (see
+// WithRequestID).  The global logger is returned if the context has no logger,
+// such as outside of a request.
+func LoggerFromContext(ctx context.Context) zerolog.Logger {
+	return logs.FromContext(ctx)
 }
 
 type logLevel zerolog.Level
@@ -21,3 +37,23 @@ const (
 func SetLogLevel(l logLevel) {
 	zerolog.SetGlobalLevel(zerolog.Level(l))
 }
+
+type logFormat int
+
+const (
+	LogJSON    = logFormat(logs.JSON)    // structured output suitable for log pipelines
+	LogConsole = logFormat(logs.Console) // human-friendly output for local development
+)
+
+// SetLogFormat to LogJSON (the default) or LogConsole.
+// Can also be set using the environment variable FUNC_LOG_FORMAT with a value
+// of either "json" or "console".
+func SetLogFormat(f logFormat) {
+	logs.SetFormat(logs.Format(f))
+}
+
+// logFormatFromEnv returns the log format requested by FUNC_LOG_FORMAT,
+// defaulting to DefaultLogFormat.
+func logFormatFromEnv() logFormat {
+	return logFormat(logs.FormatFromEnv(logs.Format(DefaultLogFormat)))
+}
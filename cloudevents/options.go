@@ -0,0 +1,129 @@
+package cloudevents
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"time"
+)
+
+// Option configures a Service.
+type Option func(*Service)
+
+// WithMaxEventSize limits the size in bytes of an incoming CloudEvent's
+// request body.  Requests exceeding the limit are rejected with a 413
+// before being decoded.  By default the size is not limited, though large
+// events may require a longer read timeout (see WithReadTimeout).
+func WithMaxEventSize(n int64) Option {
+	return func(s *Service) {
+		s.maxEventSize = n
+	}
+}
+
+// WithReadTimeout sets the maximum duration of reading a request, including
+// its body, which defaults to 30 seconds.  Large events, such as structured
+// events with multi-megabyte data, may require a longer timeout from slower
+// senders.  A timeout of zero disables it.
+func WithReadTimeout(d time.Duration) Option {
+	return func(s *Service) {
+		s.ReadTimeout = d
+	}
+}
+
+// WithWriteTimeout sets the maximum duration of writing a response, which
+// defaults to 30 seconds, and which includes reading the request's body
+// and invoking the function.  A timeout of zero disables it.
+func WithWriteTimeout(d time.Duration) Option {
+	return func(s *Service) {
+		s.WriteTimeout = d
+	}
+}
+
+// WithReadyAfterStart reports the function as not ready (503) until its
+// Start hook has returned successfully.  Events received before then wait
+// for Start to complete rather than reaching an uninitialized function.
+func WithReadyAfterStart() Option {
+	return func(s *Service) {
+		s.readyAfterStart = true
+	}
+}
+
+// WithListenAddress listens on the given address, such as "127.0.0.1:0"
+// for an OS-chosen port, rather than that of the environment variable
+// LISTEN_ADDRESS (or the default).  As with LISTEN_ADDRESS, the path of a
+// Unix domain socket may be given prefixed with "unix://".
+func WithListenAddress(addr string) Option {
+	return func(s *Service) {
+		s.listenAddress = addr
+	}
+}
+
+// WithSynchronousStart invokes the function's Start hook before the service
+// begins listening, such that an error initializing the function is returned
+// from Start without the service ever accepting traffic.  By default the
+// Start hook is invoked asynchronously once the service is listening.
+func WithSynchronousStart() Option {
+	return func(s *Service) {
+		s.synchronousStart = true
+	}
+}
+
+// WithEventPath receives events on the given path rather than the default
+// of "/", for example when functions share an ingress which routes by path.
+// Requests to other paths (except the health and admin endpoints) are
+// rejected with a 404.
+func WithEventPath(path string) Option {
+	return func(s *Service) {
+		s.eventPath = path
+	}
+}
+
+// WithNoReplyStatus responds with the given status code, such as
+// http.StatusNoContent, when the function handles an event successfully
+// without returning an event in reply.  By default such a response is a 200
+// with an empty body.  A function which returns an event is always
+// responded to with a 200 and the event.
+func WithNoReplyStatus(code int) Option {
+	return func(s *Service) {
+		s.noReply = code
+	}
+}
+
+// limitEventSize wraps the handler such that requests whose body exceeds
+// max bytes are rejected with http.StatusRequestEntityTooLarge.
+// Requests which declare their length are rejected up front; those which do
+// not (chunked) are buffered up to the limit.
+func limitEventSize(h http.Handler, max int64) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength > max {
+			http.Error(w, "event exceeds maximum size", http.StatusRequestEntityTooLarge)
+			return
+		}
+		if r.ContentLength < 0 {
+			body, err := io.ReadAll(io.LimitReader(r.Body, max+1))
+			if err != nil {
+				http.Error(w, "error reading event", http.StatusBadRequest)
+				return
+			}
+			if int64(len(body)) > max {
+				http.Error(w, "event exceeds maximum size", http.StatusRequestEntityTooLarge)
+				return
+			}
+			r.Body = io.NopCloser(bytes.NewReader(body))
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// WithServerOptions configures the underlying http.Server using the given
+// functions, for settings which have no option of their own (for example
+// ConnState, TLSConfig or ErrorLog).  They are applied by New after all
+// other options, but before the runtime sets the server's Handler, which
+// can not be replaced.  Setting BaseContext replaces that of the runtime,
+// such that values on the context passed to Start are no longer available
+// to requests.
+func WithServerOptions(oo ...func(*http.Server)) Option {
+	return func(s *Service) {
+		s.serverOptions = append(s.serverOptions, oo...)
+	}
+}
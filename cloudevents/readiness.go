@@ -0,0 +1,61 @@
+package cloudevents
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/rs/zerolog/log"
+)
+
+// WithReadinessChangeCallback invokes cb whenever the result of the
+// readiness endpoint changes, with whether the function is now ready.  The
+// function is considered not ready until first reported ready, such that cb
+// is first invoked with true.  Each transition is also logged, along with
+// the reason the function is not ready.
+//
+// Transitions are evaluated as probes are answered, but cb is invoked
+// asynchronously so as not to delay them.  Should readiness change again
+// before cb is invoked, the intermediate change is not reported.
+func WithReadinessChangeCallback(cb func(ready bool)) Option {
+	return func(s *Service) {
+		s.readinessTracker.onChange = cb
+	}
+}
+
+// readinessTracker tracks the last result of the readiness endpoint.
+type readinessTracker struct {
+	ready    atomic.Bool
+	onChange func(bool)
+
+	mu       sync.Mutex // serializes onChange
+	notified bool       // readiness last passed to onChange
+}
+
+// observeReadiness records the result of a readiness check, logging and
+// notifying of a change in readiness.
+func (s *Service) observeReadiness(ready bool, reason string) {
+	t := &s.readinessTracker
+	if t.ready.Swap(ready) == ready {
+		return
+	}
+	if ready {
+		log.Info().Msg("function ready")
+	} else {
+		log.Info().Str("reason", reason).Msg("function not ready")
+	}
+	if t.onChange != nil {
+		go t.notify()
+	}
+}
+
+// notify onChange of the current readiness if not already notified.
+func (t *readinessTracker) notify() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	ready := t.ready.Load()
+	if ready == t.notified {
+		return
+	}
+	t.notified = ready
+	t.onChange(ready)
+}
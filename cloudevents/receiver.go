@@ -0,0 +1,121 @@
+package cloudevents
+
+import (
+	"context"
+	"reflect"
+
+	"github.com/cloudevents/sdk-go/v2/event"
+)
+
+// receiveFn is the single handler signature to which each of the supported
+// function signatures is adapted before being given to the CloudEvents SDK.
+// This allows the runtime to observe (and decorate) every invocation
+// regardless of which signature the function chose to implement.  It
+// returns the response events, of which there may be none, one, or several
+// (see respondBatch).
+type receiveFn func(context.Context, event.Event) ([]event.Event, error)
+
+// receiveMiddleware wraps a receiveFn, returning a receiveFn which can
+// perform work before and/or after invoking it.
+type receiveMiddleware func(receiveFn) receiveFn
+
+var (
+	contextType       = reflect.TypeOf((*context.Context)(nil)).Elem()
+	eventType         = reflect.TypeOf(event.Event{})
+	eventPtrType      = reflect.TypeOf(&event.Event{})
+	eventSliceType    = reflect.TypeOf([]event.Event{})
+	eventPtrSliceType = reflect.TypeOf([]*event.Event{})
+	errorType         = reflect.TypeOf((*error)(nil)).Elem()
+)
+
+// newReceiveFn adapts h, which must be a function of one of the
+// SupportedSignatures, to a receiveFn.
+//
+// This is the reflection-based approach described in the implementation
+// notes of instance.go, which works here because the SDK is given a function
+// of a known signature (receiveFn) rather than the reflected function itself.
+func newReceiveFn(h any) (receiveFn, error) {
+	switch fn := h.(type) {
+	case func(context.Context, event.Event) (*event.Event, error):
+		return func(ctx context.Context, e event.Event) ([]event.Event, error) {
+			out, err := fn(ctx, e)
+			return events(out), err
+		}, nil
+	case func(context.Context, event.Event) ([]event.Event, error):
+		return fn, nil
+	}
+
+	if h == nil {
+		return nil, unsupportedHandler("handler is nil")
+	}
+	v := reflect.ValueOf(h)
+	t := v.Type()
+	if t.Kind() != reflect.Func {
+		return nil, unsupportedHandler("handler must be a function, got %v", t)
+	}
+
+	// Inputs: [context.Context], [event.Event] in that order.
+	var hasCtx, hasEvent bool
+	for i := 0; i < t.NumIn(); i++ {
+		switch {
+		case i == 0 && t.In(i) == contextType:
+			hasCtx = true
+		case !hasEvent && t.In(i) == eventType:
+			hasEvent = true
+		default:
+			return nil, unsupportedHandler("handler has signature %v", t)
+		}
+	}
+
+	// Outputs: [*event.Event, []event.Event or []*event.Event], [error] in
+	// that order.
+	var hasEventOut, hasErrOut bool
+	for i := 0; i < t.NumOut(); i++ {
+		switch out := t.Out(i); {
+		case i == 0 && (out == eventPtrType || out == eventSliceType || out == eventPtrSliceType):
+			hasEventOut = true
+		case !hasErrOut && t.Out(i).Implements(errorType):
+			hasErrOut = true
+		default:
+			return nil, unsupportedHandler("handler has signature %v", t)
+		}
+	}
+	if t.NumIn() > 2 || t.NumOut() > 2 {
+		return nil, unsupportedHandler("handler has signature %v", t)
+	}
+
+	return func(ctx context.Context, e event.Event) (out []event.Event, err error) {
+		var args []reflect.Value
+		if hasCtx {
+			args = append(args, reflect.ValueOf(ctx))
+		}
+		if hasEvent {
+			args = append(args, reflect.ValueOf(e))
+		}
+		results := v.Call(args)
+		if hasEventOut {
+			switch v := results[0].Interface().(type) {
+			case *event.Event:
+				out = events(v)
+			case []event.Event:
+				out = v
+			case []*event.Event:
+				out = events(v...)
+			}
+		}
+		if hasErrOut {
+			err, _ = results[len(results)-1].Interface().(error)
+		}
+		return
+	}, nil
+}
+
+// events returns the given response events, omitting any which are nil.
+func events(ee ...*event.Event) (out []event.Event) {
+	for _, e := range ee {
+		if e != nil {
+			out = append(out, *e)
+		}
+	}
+	return
+}
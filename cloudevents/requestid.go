@@ -0,0 +1,81 @@
+package cloudevents
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// RequestIDHeader is the header from which a request's ID is read, and in
+// which it is echoed in the response.
+const RequestIDHeader = "X-Request-ID"
+
+// maxRequestIDLength is the longest incoming request ID which is accepted.
+// Longer IDs are replaced, such that a client can not bloat the logs.
+const maxRequestIDLength = 128
+
+// WithRequestID assigns each request an ID, which is that of its
+// X-Request-ID header or a generated UUID if absent.  The ID is echoed in
+// the response's X-Request-ID header and included as the field
+// "request_id" in runtime log lines for the request, such as audit records
+// (see WithAuditLog).
+//
+// The ID is available to the function using RequestID on the context with
+// which it is invoked, and a logger which includes it using LoggerFromContext.
+func WithRequestID() Option {
+	return func(s *Service) {
+		s.requestID = true
+	}
+}
+
+type requestIDKey struct{}
+
+// RequestID returns the ID assigned to the request with the given context,
+// or an empty string if none was assigned (see WithRequestID).
+func RequestID(ctx context.Context) string {
+	id, _ := ctx.Value(requestIDKey{}).(string)
+	return id
+}
+
+// assignRequestID wraps the handler such that each request is assigned an
+// ID and a logger which includes it.
+func assignRequestID(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(RequestIDHeader)
+		if !validRequestID(id) {
+			id = uuid.NewString()
+		}
+		w.Header().Set(RequestIDHeader, id)
+
+		ctx := context.WithValue(r.Context(), requestIDKey{}, id)
+		ctx = log.With().Str("request_id", id).Logger().WithContext(ctx)
+		next.ServeHTTP(w, r.WithContext(ctx))
+	})
+}
+
+// validRequestID returns true if id is non-empty, not overly long and
+// consists only of printable ASCII.
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] < 0x20 || id[i] > 0x7e {
+			return false
+		}
+	}
+	return true
+}
+
+// requestLog returns the logger for runtime log lines about the request with
+// the given context: that which includes its ID if assigned, or the global
+// logger otherwise.
+func requestLog(ctx context.Context) *zerolog.Logger {
+	if RequestID(ctx) != "" {
+		return zerolog.Ctx(ctx)
+	}
+	return &log.Logger
+}
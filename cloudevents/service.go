@@ -4,24 +4,26 @@
 package cloudevents
 
 import (
-	"bufio"
 	"context"
-	"fmt"
+	"errors"
 	"net"
 	"net/http"
 	"os"
-	"os/signal"
-	"runtime"
-	"strings"
-	"syscall"
+	"reflect"
+	"sync/atomic"
 	"time"
 
 	cloudevents "github.com/cloudevents/sdk-go/v2"
 	"github.com/rs/zerolog/log"
+
+	"knative.dev/func-go/internal/config"
+	"knative.dev/func-go/internal/shutdown"
+	"knative.dev/func-go/internal/signals"
 )
 
 const (
 	DefaultLogLevel       = LogDebug
+	DefaultLogFormat      = LogJSON
 	DefaultListenAddress  = "127.0.0.1:8080"
 	ServerShutdownTimeout = 30 * time.Second
 	InstanceStopTimeout   = 30 * time.Second
@@ -36,18 +38,66 @@ func Start(f any) error {
 }
 
 // Service exposes a Function Instance as a an HTTP service.
+//
+// Fields of the embedded http.Server may be set before Start, either
+// directly or using WithServerOptions, with the exception of Handler which
+// is set by New.
 type Service struct {
 	http.Server
-	listener net.Listener
-	f        any
-	stop     chan error
+	listener      net.Listener
+	listenAddress string // by WithListenAddress
+	f             any
+	stop          chan error
+	maxEventSize  int64
+	compress      bool
+	eventPath     string
+	noReply       int // by WithNoReplyStatus
+	sink          sink
+	handlerErr    error // returned by Start
+
+	signalHandlers map[os.Signal]func()          // by WithSignalHandler
+	shutdownHooks  []func(context.Context) error // by WithShutdownHook
+
+	receiveMiddleware []receiveMiddleware
+	serverOptions     []func(*http.Server)
+	healthChecks      map[string]HealthCheck
+	jsonHealth        bool
+	readyResponse     healthResponse
+	aliveResponse     healthResponse
+	requestID         bool
+
+	listening        chan struct{}
+	started          chan struct{}
+	startCancel      context.CancelFunc // of an asynchronous Start hook
+	startDone        chan struct{}      // closed when it returns
+	readyAfterStart  bool
+	synchronousStart bool
+
+	cfg atomic.Pointer[map[string]string] // with which the instance was last started
+
+	restarter
+	configWatchInterval time.Duration
+
+	drainer
+	admin      bool
+	adminToken string
+
+	idleTracker
+	idleTimeout time.Duration
+
+	readinessTracker
 }
 
 // New Service which service the given instance.
-func New(f any) *Service {
+func New(f any, options ...Option) *Service {
 	svc := &Service{
-		f:    f,
-		stop: make(chan error),
+		f:             instance(f),
+		eventPath:     "/",
+		stop:          make(chan error),
+		listening:     make(chan struct{}),
+		started:       make(chan struct{}),
+		readyResponse: healthResponse{code: http.StatusOK, body: "READY"},
+		aliveResponse: healthResponse{code: http.StatusOK, body: "ALIVE"},
 		Server: http.Server{
 			ReadTimeout:       30 * time.Second,
 			WriteTimeout:      30 * time.Second,
@@ -56,39 +106,149 @@ func New(f any) *Service {
 			ReadHeaderTimeout: 2 * time.Second,
 		},
 	}
+	for _, o := range options {
+		o(svc)
+	}
+	for _, o := range svc.serverOptions {
+		o(&svc.Server)
+	}
+
+	// Response events are delivered to the sink (K_SINK), if set, by the
+	// innermost middleware, such that delivery is part of the invocation.
+	// An invalid sink is returned by Start.
+	hasSink, err := svc.sink.init()
+	if err != nil {
+		log.Error().Err(err).Msg("sink invalid")
+		svc.handlerErr = err
+	} else if hasSink {
+		svc.receiveMiddleware = append(svc.receiveMiddleware, svc.sink.deliver)
+	}
+
+	// Events not matching FUNC_EVENT_FILTER, if set, are acknowledged by the
+	// outermost middleware, such that the function is not invoked.  An
+	// invalid filter is returned by Start.
+	filter, err := newEventFilter(os.Getenv("FUNC_EVENT_FILTER"))
+	if err != nil {
+		log.Error().Err(err).Msg("event filter invalid")
+		svc.handlerErr = errors.Join(svc.handlerErr, err)
+	} else if filter != nil {
+		svc.receiveMiddleware = append([]receiveMiddleware{filter}, svc.receiveMiddleware...)
+	}
+
+	// The function's handler is validated here, such that an unsupported
+	// signature is reported before any traffic, and returned by Start.
+	h, err := newCloudeventHandler(f, svc.eventPath, svc.noReply, svc.receiveMiddleware...) // See implementation note
+	if err != nil {
+		log.Error().Err(err).Msg("function handler unsupported")
+		svc.handlerErr = errors.Join(svc.handlerErr, err)
+		h = http.NotFoundHandler()
+	}
+	h = addResponseHeader(h)
+	if svc.maxEventSize > 0 {
+		h = limitEventSize(h, svc.maxEventSize)
+	}
+	if svc.compress {
+		h = compress(h)
+	}
+	// Within awaitRestart, such that an event held during a restart is given
+	// the config of the restarted instance.
+	h = svc.addConfig(h)
+	if svc.configWatchInterval > 0 {
+		h = svc.awaitRestart(h)
+	}
+	if svc.readyAfterStart {
+		h = svc.awaitStart(h)
+	}
+	h = svc.refuseWhileDraining(h)
+	if svc.idleTimeout > 0 {
+		h = svc.trackIdle(h)
+	}
+	if svc.requestID {
+		// Outermost, such that all responses bear the ID.
+		h = assignRequestID(h)
+	}
+
 	mux := http.NewServeMux()
 	mux.HandleFunc("/health/readiness", svc.Ready)
 	mux.HandleFunc("/health/liveness", svc.Alive)
-	mux.Handle("/", newCloudeventHandler(f)) // See implementation note
+	if svc.admin {
+		mux.HandleFunc("/admin/drain", svc.handleDrain)
+		if svc.adminToken == "" {
+			log.Warn().Msg("admin endpoints require WithAdminToken; refusing all requests to them")
+		}
+	}
+	mux.Handle(svc.eventPath, h)
 	svc.Handler = mux
 	return svc
 }
 
-// Start serving
+// Start serving on the address LISTEN_ADDRESS, which may also be a Unix
+// domain socket such as unix:///tmp/function.sock.
 func (s *Service) Start(ctx context.Context) (err error) {
+	if s.handlerErr != nil {
+		return s.handlerErr
+	}
+
 	// Get the listen address
-	// TODO: Currently this is an env var for legacy reasons. Logic should
-	// be moved into the generated mainfiles, and this setting be an optional
-	// functional option WithListenAddress(os.Getenv("LISTEN_ADDRESS"))
-	addr := listenAddress()
+	// That set using WithListenAddress, or else that of the environment.
+	// TODO: The environment is read here for legacy reasons.  Logic should
+	// be moved into the generated mainfiles, passing the setting using
+	// WithListenAddress(os.Getenv("LISTEN_ADDRESS"))
+	addr := s.listenAddress
+	if addr == "" {
+		addr = listenAddress()
+	}
 	log.Debug().Str("address", addr).Msg("function starting")
 
+	// Synchronous Start
+	// Optionally starts the function instance before listening, such that
+	// initialization errors are returned without ever accepting traffic.
+	if s.synchronousStart {
+		if err = s.startInstance(ctx); err != nil {
+			return
+		}
+	}
+
 	// Listen
-	if s.listener, err = net.Listen("tcp", addr); err != nil {
+	// Should listening fail once the instance has been started
+	// synchronously, it is stopped, such that resources acquired by its
+	// Start hook are released.
+	if s.listener, err = listen(addr); err != nil {
+		if s.synchronousStart {
+			err = s.stopInstance(err, nil)
+		}
 		return
 	}
+	close(s.listening)
+	log.Debug().Str("address", s.listener.Addr().String()).Msg("function listening")
+	s.logSummary()
+
+	// Base Context
+	// Requests are handled with a context derived from the one passed to
+	// Start (and thus also to the function's Start hook), such that values
+	// placed on it are available to the function's handler.  Cancellation
+	// is not propagated, as in-flight requests should be allowed to complete
+	// during a graceful shutdown.  A BaseContext set using WithServerOptions
+	// is used instead if provided.
+	if s.BaseContext == nil {
+		baseCtx := context.WithoutCancel(ctx)
+		s.BaseContext = func(net.Listener) context.Context { return baseCtx }
+	}
 
 	// Start
 	// Starts the function instance in a separate routine, sending any
 	// runtime errors on s.stop.
-	if err = s.startInstance(ctx); err != nil {
-		return
+	if !s.synchronousStart {
+		if err = s.startInstance(ctx); err != nil {
+			return
+		}
 	}
 
 	// Wait for signals
 	// Interrupts and Kill signals
 	// sending a message on the s.stop channel if either are received.
-	s.handleSignals()
+	unregister := signals.Handle(s.stop, s.signalHandlers)
+	defer unregister()
 
 	go func() {
 		if err := s.Serve(s.listener); err != http.ErrServerClosed {
@@ -97,6 +257,33 @@ func (s *Service) Start(ctx context.Context) (err error) {
 		}
 	}()
 
+	// Watch config
+	// Optionally restarts the function instance when its config changes.
+	// The watch is ended (and any restart in progress completed) before
+	// shutting down.
+	stopWatching := func() {}
+	if s.configWatchInterval > 0 {
+		watchCtx, cancel := context.WithCancel(ctx)
+		watchDone := make(chan struct{})
+		go func() {
+			s.watchConfig(watchCtx)
+			close(watchDone)
+		}()
+		stopWatching = func() {
+			cancel()
+			<-watchDone
+		}
+	}
+
+	// Watch for idleness
+	// Optionally shuts down once no requests have been handled for the
+	// idle timeout.
+	if s.idleTimeout > 0 {
+		idleCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		go s.watchIdle(idleCtx)
+	}
+
 	log.Debug().Msg("waiting for stop signals or errors")
 	// Wait for either a context cancellation or a signal on the stop channel.
 	select {
@@ -107,6 +294,7 @@ func (s *Service) Start(ctx context.Context) (err error) {
 	case <-ctx.Done():
 		log.Debug().Msg("function canceled")
 	}
+	stopWatching()
 	return s.shutdown(err)
 }
 
@@ -137,8 +325,17 @@ func listenAddress() string {
 	return DefaultListenAddress
 }
 
+// Listening returns a channel which is closed once the service is listening,
+// after which Addr reports the address upon which it listens.  This is the
+// OS-chosen port when listening on port 0 (for example LISTEN_ADDRESS
+// "127.0.0.1:0").  The channel is never closed if the service fails to
+// listen.
+func (s *Service) Listening() <-chan struct{} {
+	return s.listening
+}
+
 // Addr returns the address upon which the service is listening if started;
-// nil otherwise.
+// nil otherwise.  See Listening.
 func (s *Service) Addr() net.Addr {
 	if s.listener == nil {
 		return nil
@@ -153,44 +350,105 @@ func (s *Service) Addr() net.Addr {
 // TODO: test when f is not a pointer
 // TODO: test when f.Handle does not have a pointer receiver
 // TODO: test when f is an interface type
-func newCloudeventHandler(f any) http.Handler {
-	var h any
-	if dh, ok := f.(DefaultHandler); ok {
+//
+// The function's handler is adapted to a receiveFn and wrapped by the given
+// receive middleware, outermost-first, before being given to the SDK.  A
+// successful response without an event has the status noReply, if not 0.
+func newCloudeventHandler(f any, path string, noReply int, mm ...receiveMiddleware) (http.Handler, error) {
+	var (
+		h   any
+		err error
+	)
+	if dh, ok := f.(DefaultHandler); ok && (dh.Handler == nil || reflect.TypeOf(dh.Handler).Kind() == reflect.Func) {
 		// Static Functions use a struct to curry the reference
 		h = dh.Handler
+	} else if ok {
+		// An instance wrapped in a DefaultHandler
+		h, err = getReceiverFn(dh.Handler)
 	} else {
 		// Instanced Functions implement one of the defined interfaces.
-		h = getReceiverFn(f)
+		h, err = getReceiverFn(f)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	fn, err := newReceiveFn(h)
+	if err != nil {
+		return nil, err
+	}
+	fn = mapHandlerErrors(fn)
+	for i := len(mm) - 1; i >= 0; i-- {
+		fn = mm[i](fn)
 	}
 
-	protocol, err := cloudevents.NewHTTP()
+	protocol, err := cloudevents.NewHTTP(cloudevents.WithPath(path))
 	panicOn(err)
 	ctx := context.Background() // ctx is not used by NewHTTPReceiveHandler
-	cloudeventReceiver, err := cloudevents.NewHTTPReceiveHandler(ctx, protocol, h)
+	cloudeventReceiver, err := cloudevents.NewHTTPReceiveHandler(ctx, protocol, respond(fn))
 	panicOn(err)
-	return cloudeventReceiver
+	return respondBatch(cloudeventReceiver, noReply), nil
+}
+
+// instance returns the function instance upon which lifecycle hooks (Start,
+// Stop, Ready and Alive) are invoked.  This is the Handler of a
+// DefaultHandler, such that a handler which also implements any of the
+// hooks has them invoked even when wrapped, or f itself otherwise.
+func instance(f any) any {
+	if dh, ok := f.(DefaultHandler); ok {
+		return dh.Handler
+	}
+	return f
 }
 
 // Ready handles readiness checks.
 func (s *Service) Ready(w http.ResponseWriter, r *http.Request) {
+	if s.readyAfterStart && !s.isStarted() {
+		message := "function not yet started"
+		log.Debug().Msg(message)
+		s.observeReadiness(false, message)
+		s.writeHealth(w, http.StatusServiceUnavailable, message+"\n", nil)
+		return
+	}
+	if s.restarting.Load() {
+		message := "function restarting"
+		log.Debug().Msg(message)
+		s.observeReadiness(false, message)
+		s.writeHealth(w, http.StatusServiceUnavailable, message+"\n", nil)
+		return
+	}
+	if s.draining.Load() {
+		message := "function draining"
+		log.Debug().Msg(message)
+		s.observeReadiness(false, message)
+		s.writeHealth(w, http.StatusServiceUnavailable, message+"\n", nil)
+		return
+	}
 	if i, ok := s.f.(ReadinessReporter); ok {
 		ready, err := i.Ready(r.Context())
 		if err != nil {
 			message := "error checking readiness"
 			log.Debug().Err(err).Msg(message)
-			w.WriteHeader(http.StatusInternalServerError)
-			fmt.Fprint(w, "error checking readiness: ", err.Error())
+			s.observeReadiness(false, message)
+			s.writeHealth(w, http.StatusInternalServerError, "error checking readiness: "+err.Error(), nil)
 			return
 		}
 		if !ready {
 			message := "function not yet ready"
 			log.Debug().Msg(message)
-			w.WriteHeader(http.StatusServiceUnavailable)
-			fmt.Fprintln(w, message)
+			s.observeReadiness(false, message)
+			s.writeHealth(w, http.StatusServiceUnavailable, message+"\n", nil)
 			return
 		}
 	}
-	fmt.Fprintf(w, "READY")
+	results, ok := s.runHealthChecks(r.Context())
+	if !ok {
+		s.observeReadiness(false, "health check failed")
+		writeHealthChecks(w, results)
+		return
+	}
+	s.observeReadiness(true, "")
+	s.writeHealth(w, s.readyResponse.code, s.readyResponse.body, results)
 }
 
 // Alive handles liveness checks.
@@ -200,96 +458,104 @@ func (s *Service) Alive(w http.ResponseWriter, r *http.Request) {
 		if err != nil {
 			message := "error checking liveness"
 			log.Err(err).Msg(message)
-			w.WriteHeader(http.StatusInternalServerError)
-			fmt.Fprint(w, "error checking liveness: ", err.Error())
+			s.writeHealth(w, http.StatusInternalServerError, "error checking liveness: "+err.Error(), nil)
 			return
 		}
 		if !alive {
 			message := "function not alive"
 			log.Debug().Msg(message)
-			w.WriteHeader(http.StatusServiceUnavailable)
-			_, _ = w.Write([]byte(message))
+			s.writeHealth(w, http.StatusServiceUnavailable, message, nil)
 			return
 		}
 	}
-	fmt.Fprintf(w, "ALIVE")
+	s.writeHealth(w, s.aliveResponse.code, s.aliveResponse.body, nil)
 }
 
 func (s *Service) startInstance(ctx context.Context) error {
+	cfg, err := config.New()
+	if err != nil {
+		return err
+	}
+	s.cfg.Store(&cfg)
 	if i, ok := s.f.(Starter); ok {
-		cfg, err := newCfg()
-		if err != nil {
-			return err
+		if s.synchronousStart {
+			if err := i.Start(ctx, cfg); err != nil {
+				return err
+			}
+			close(s.started)
+			return nil
 		}
+		// Canceled should the service stop while Start is in progress.
+		startCtx, cancel := context.WithCancel(ctx)
+		s.startCancel, s.startDone = cancel, make(chan struct{})
 		go func() {
-			if err := i.Start(ctx, cfg); err != nil {
-				s.stop <- err
+			defer close(s.startDone)
+			if err := i.Start(startCtx, cfg); err != nil {
+				select {
+				case s.stop <- err:
+				case <-startCtx.Done(): // already stopping
+				}
+				return
 			}
+			close(s.started)
 		}()
 	} else {
 		log.Debug().Msg("function does not implement Start. Skipping")
+		close(s.started)
 	}
 	return nil
 }
 
-func (s *Service) handleSignals() {
-	sigs := make(chan os.Signal, 2)
-	signal.Notify(sigs)
-	go func() {
-		for {
-			sig := <-sigs
-			if sig == syscall.SIGINT || sig == syscall.SIGTERM {
-				log.Debug().Any("signal", sig).Msg("signal received")
-				s.stop <- nil
-			} else if runtime.GOOS == "linux" && sig == syscall.Signal(0x17) {
-				// Ignore SIGURG; signal 23 (0x17)
-				// See https://go.googlesource.com/proposal/+/master/design/24543-non-cooperative-preemption.md
-			}
-		}
-	}()
-}
-
-// readCfg returns a map representation of ./cfg
-// Empty map is returned if ./cfg does not exist.
-// Error is returned for invalid entries.
-// keys and values are space-trimmed.
-// Quotes are removed from values.
-func readCfg() (map[string]string, error) {
-	cfg := map[string]string{}
-
-	f, err := os.Open("cfg")
-	if err != nil {
-		log.Debug().Msg("no static config")
-		return cfg, nil
-	}
-	defer f.Close()
-
-	scanner := bufio.NewScanner(f)
-	i := 0
-	for scanner.Scan() {
-		i++
-		line := scanner.Text()
-		parts := strings.SplitN(line, "=", 2)
-		if len(parts) != 2 {
-			return cfg, fmt.Errorf("config line %v invalid: %v", i, line)
-		}
-		cfg[strings.TrimSpace(parts[0])] = strings.Trim(strings.TrimSpace(parts[1]), "\"")
+// cancelStart cancels the context passed to the function's Start hook if it
+// is still in progress, such as when a signal is received during a slow
+// initialization.
+func (s *Service) cancelStart() {
+	if s.startDone == nil {
+		return
+	}
+	select {
+	case <-s.startDone:
+	default:
+		log.Debug().Msg("canceling function start")
+		s.startCancel()
 	}
-	return cfg, scanner.Err()
 }
 
-// newCfg creates a final map of config values built from the static
-// values in `cfg` and all environment variables.
-func newCfg() (cfg map[string]string, err error) {
-	if cfg, err = readCfg(); err != nil {
+// waitStart waits up to InstanceStopTimeout for the function's Start hook to
+// return, such that Stop is never invoked while Start is in progress.
+func (s *Service) waitStart() {
+	if s.startDone == nil {
 		return
 	}
+	select {
+	case <-s.startDone:
+	case <-time.After(InstanceStopTimeout):
+		log.Warn().Msg("timed out waiting for function start to return")
+	}
+}
 
-	for _, e := range os.Environ() {
-		pair := strings.SplitN(e, "=", 2)
-		cfg[pair[0]] = pair[1]
+// isStarted returns true if the function instance has successfully started.
+func (s *Service) isStarted() bool {
+	select {
+	case <-s.started:
+		return true
+	default:
+		return false
 	}
-	return
+}
+
+// awaitStart wraps the handler such that requests received before the
+// function instance has started wait for it to do so, failing with a 503
+// should the request be canceled first.
+func (s *Service) awaitStart(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-s.started:
+			next.ServeHTTP(w, r)
+		case <-r.Context().Done():
+			http.Error(w, "function not yet started", http.StatusServiceUnavailable)
+		}
+	})
 }
 
 // shutdown is invoked when the stop channel receives a message and attempts to
@@ -299,38 +565,46 @@ func newCfg() (cfg map[string]string, err error) {
 // cancellation or sigint/sigkill.
 func (s *Service) shutdown(sourceErr error) (err error) {
 	log.Debug().Msg("function stopping")
-	var runtimeErr, instanceErr error
+	var runtimeErr error
+
+	// Cancel a Start hook still in progress, which is waited upon before
+	// the instance is stopped.
+	s.cancelStart()
 
 	// Start a graceful shutdown of the HTTP server
 	ctx, cancel := context.WithTimeout(context.Background(), ServerShutdownTimeout)
 	defer cancel()
 	runtimeErr = s.Shutdown(ctx)
+	_ = s.listener.Close() // removes a Unix socket even if not yet served
+
+	s.waitStart()
+
+	return s.stopInstance(sourceErr, runtimeErr)
+}
 
-	//  Start a graceful shutdown of the Function instance
+// stopInstance invokes the function's Stop hook followed by the shutdown
+// hooks, returning their errors along with those given.  It is invoked by
+// shutdown, and by Start should it fail once the instance is started.
+func (s *Service) stopInstance(sourceErr, runtimeErr error) error {
+	var instanceErr error
 	if i, ok := s.f.(Stopper); ok {
-		ctx, cancel = context.WithTimeout(context.Background(), InstanceStopTimeout)
+		ctx, cancel := context.WithTimeout(context.Background(), InstanceStopTimeout)
 		defer cancel()
 		instanceErr = i.Stop(ctx)
 	}
 
-	return collapseErrors("shutdown error", sourceErr, instanceErr, runtimeErr)
-}
+	hookErr := shutdown.RunHooks(s.shutdownHooks, InstanceStopTimeout)
 
-// collapseErrors returns the first non-nil error which it is passed,
-// printing the rest to log with the given prefix.
-func collapseErrors(msg string, ee ...error) (err error) {
-	for _, e := range ee {
-		if e != nil {
-			if err == nil {
-				err = e
-			} else {
-				log.Error().Err(e).Msg(msg)
-			}
-		}
-	}
-	return
+	return shutdown.Errors(instanceErr, sourceErr, runtimeErr, hookErr)
 }
 
+// ShutdownError is returned by Start when more than one error occurs in
+// stopping the service.  Errors are ordered by precedence: that of the
+// function's Stop hook, followed by that which caused the service to stop,
+// followed by any of the runtime itself.  errors.Is and errors.As consider
+// each of them.
+type ShutdownError = shutdown.Error
+
 // CE-specific helpers
 func panicOn(err error) {
 	if err != nil {
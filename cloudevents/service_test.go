@@ -1,17 +1,32 @@
 package cloudevents
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
+	"io"
 	"log"
+	"net"
 	"net/http"
+	"net/http/httptest"
 	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
 	cloudevents "github.com/cloudevents/sdk-go/v2"
 	"github.com/cloudevents/sdk-go/v2/event"
+	"github.com/cloudevents/sdk-go/v2/protocol"
+	cehttp "github.com/cloudevents/sdk-go/v2/protocol/http"
+	"github.com/rs/zerolog"
+	zlog "github.com/rs/zerolog/log"
 	"knative.dev/func-go/cloudevents/mock"
+	"knative.dev/func-go/internal/logs"
 )
 
 // TestStart_Invoked ensures that the Start method of a function is invoked
@@ -394,3 +409,1415 @@ func TestAlive_Invoked(t *testing.T) {
 		t.Fatalf("unexpected http status code: %v", resp.StatusCode)
 	}
 }
+
+// TestHandle_StartContext ensures that values on the context passed to Start
+// are available to both the function's Start hook and its handler.
+func TestHandle_StartContext(t *testing.T) {
+	t.Setenv("LISTEN_ADDRESS", "127.0.0.1:") // use an OS-chosen port
+
+	type ctxKey struct{}
+
+	var (
+		ctx, cancel = context.WithCancel(context.WithValue(context.Background(), ctxKey{}, "example"))
+		errCh       = make(chan error)
+		startCh     = make(chan any)
+		handleCh    = make(chan any, 1)
+		timeoutCh   = time.After(500 * time.Millisecond)
+		onStart     = func(ctx context.Context, _ map[string]string) error {
+			if v := ctx.Value(ctxKey{}); v != "example" {
+				t.Errorf("Start did not receive context value.  got %v", v)
+			}
+			startCh <- true
+			return nil
+		}
+		onHandle = func(ctx context.Context, _ event.Event) (*event.Event, error) {
+			handleCh <- ctx.Value(ctxKey{})
+			return nil, nil
+		}
+	)
+	defer cancel()
+
+	f := &mock.Function{OnStart: onStart, OnHandle: onHandle}
+	service := New(f)
+
+	go func() {
+		if err := service.Start(ctx); err != nil {
+			errCh <- err
+		}
+	}()
+
+	select {
+	case <-timeoutCh:
+		t.Fatal("function failed to start")
+	case err := <-errCh:
+		t.Fatal(err)
+	case <-startCh:
+	}
+
+	c, err := cloudevents.NewClientHTTP()
+	if err != nil {
+		t.Fatal(err)
+	}
+	event := cloudevents.NewEvent()
+	event.SetSource("example/uri")
+	event.SetType("example.type")
+
+	sendCtx := cloudevents.ContextWithTarget(context.Background(), "http://"+service.Addr().String())
+	if result := c.Send(sendCtx, event); cloudevents.IsUndelivered(result) {
+		t.Fatalf("failed to send, %v", result)
+	}
+
+	if v := <-handleCh; v != "example" {
+		t.Fatalf("handler did not receive context value.  got %v", v)
+	}
+}
+
+// startService starts a Service for the given function on an OS-chosen port,
+// returning once the function's Start hook has been invoked.  The service is
+// stopped when the test completes.
+func startService(t *testing.T, f *mock.Function, options ...Option) *Service {
+	t.Helper()
+	return startInstance(t, f, f, options...)
+}
+
+// startInstance is startService for a function instance other than the mock,
+// such as one embedding it, whose Start hook is that of the given mock.
+func startInstance(t *testing.T, f *mock.Function, instance any, options ...Option) *Service {
+	t.Helper()
+	options = append([]Option{WithListenAddress("127.0.0.1:0")}, options...) // an OS-chosen port
+
+	var (
+		ctx, cancel = context.WithCancel(context.Background())
+		startCh     = make(chan any, 1)
+		errCh       = make(chan error, 1)
+		onStart     = f.OnStart
+	)
+	f.OnStart = func(ctx context.Context, cfg map[string]string) error {
+		select {
+		case startCh <- true:
+		default:
+		}
+		if onStart != nil {
+			return onStart(ctx, cfg)
+		}
+		return nil
+	}
+
+	service := New(instance, options...)
+	go func() {
+		errCh <- service.Start(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		select {
+		case <-errCh:
+		case <-time.After(time.Second):
+			t.Error("service failed to stop")
+		}
+	})
+
+	select {
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("function failed to start")
+	case err := <-errCh:
+		t.Fatal(err)
+	case <-startCh:
+	}
+	return service
+}
+
+// postEvent sends a binary-mode CloudEvent with the given data to the
+// service at the given path, returning the response.
+func postEvent(t *testing.T, s *Service, path string, data []byte) *http.Response {
+	t.Helper()
+	req, err := http.NewRequest(http.MethodPost, "http://"+s.Addr().String()+path, bytes.NewReader(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Ce-Specversion", "1.0")
+	req.Header.Set("Ce-Id", "1")
+	req.Header.Set("Ce-Source", "example/uri")
+	req.Header.Set("Ce-Type", "example.type")
+	req.Header.Set("Content-Type", "application/octet-stream")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { resp.Body.Close() })
+	return resp
+}
+
+// TestMaxEventSize ensures that events up to the configured maximum size are
+// accepted, and larger events are rejected with a 413.
+func TestMaxEventSize(t *testing.T) {
+	const max = 1024
+
+	var invoked int
+	f := &mock.Function{OnHandle: func(context.Context, event.Event) (*event.Event, error) {
+		invoked++
+		return nil, nil
+	}}
+	service := startService(t, f, WithMaxEventSize(max))
+
+	resp := postEvent(t, service, "/", bytes.Repeat([]byte("a"), max))
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("event at limit: unexpected http status code: %v", resp.StatusCode)
+	}
+
+	resp = postEvent(t, service, "/", bytes.Repeat([]byte("a"), max+1))
+	if resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Fatalf("event over limit: unexpected http status code: %v", resp.StatusCode)
+	}
+
+	if invoked != 1 {
+		t.Fatalf("expected handler to be invoked once, got %v", invoked)
+	}
+}
+
+// syncBuffer is a bytes.Buffer safe for concurrent use, for capturing logs
+// written by the service.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) Bytes() []byte {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return append([]byte(nil), b.buf.Bytes()...)
+}
+
+func (b *syncBuffer) Reset() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.buf.Reset()
+}
+
+// TestLogFormat ensures that logs are written as JSON by default, and for
+// the console when selected using SetLogFormat or FUNC_LOG_FORMAT.
+func TestLogFormat(t *testing.T) {
+	var buf bytes.Buffer
+	output, logger := logs.Output, zlog.Logger
+	logs.Output = &buf
+	t.Cleanup(func() { logs.Output, zlog.Logger = output, logger })
+
+	isJSON := func() bool {
+		t.Helper()
+		buf.Reset()
+		zlog.Info().Msg("example")
+		if !strings.Contains(buf.String(), "example") {
+			t.Fatalf("expected the message to be logged, got %q", buf.String())
+		}
+		return json.Valid(buf.Bytes())
+	}
+
+	if DefaultLogFormat != LogJSON {
+		t.Fatal("expected JSON logs by default")
+	}
+	SetLogFormat(LogJSON)
+	if !isJSON() {
+		t.Fatalf("expected JSON logs, got %q", buf.String())
+	}
+	SetLogFormat(LogConsole)
+	if isJSON() {
+		t.Fatalf("expected console logs, got %q", buf.String())
+	}
+
+	for value, expected := range map[string]bool{"json": true, "": true, "other": true, "console": false, "CONSOLE": false} {
+		t.Setenv("FUNC_LOG_FORMAT", value)
+		SetLogFormat(logFormatFromEnv())
+		if isJSON() != expected {
+			t.Fatalf("FUNC_LOG_FORMAT=%q: expected JSON logs %v, got %q", value, expected, buf.String())
+		}
+	}
+}
+
+// TestAuditLog ensures that an audit record is emitted for each event
+// processed with the expected fields, for both successful and failed events.
+func TestAuditLog(t *testing.T) {
+	var buf syncBuffer
+	logger := zlog.Logger
+	zlog.Logger = zerolog.New(&buf)
+	t.Cleanup(func() { zlog.Logger = logger })
+
+	f := &mock.Function{OnHandle: func(_ context.Context, e event.Event) (*event.Event, error) {
+		switch e.Type() {
+		case "example.failure":
+			return nil, errors.New("example failure")
+		case "example.ack":
+			return nil, protocol.ResultACK
+		case "example.nack":
+			return nil, protocol.ResultNACK
+		}
+		return nil, nil
+	}}
+	service := startService(t, f, WithAuditLog())
+
+	records := func() (rr []map[string]any) {
+		for _, line := range bytes.Split(bytes.TrimSpace(buf.Bytes()), []byte("\n")) {
+			r := map[string]any{}
+			if err := json.Unmarshal(line, &r); err != nil {
+				t.Fatal(err)
+			}
+			if r["logger"] == "audit" {
+				rr = append(rr, r)
+			}
+		}
+		buf.Reset()
+		return
+	}
+
+	for _, tc := range []struct {
+		typ    string
+		result string
+	}{
+		{typ: "example.success", result: "success"},
+		{typ: "example.failure", result: "error"},
+		{typ: "example.ack", result: "success"},
+		{typ: "example.nack", result: "error"},
+	} {
+		e := cloudevents.NewEvent()
+		e.SetID("example-id")
+		e.SetSource("example/uri")
+		e.SetType(tc.typ)
+		e.SetSubject("example-subject")
+
+		req, err := cehttp.NewHTTPRequestFromEvent(context.Background(), "http://"+service.Addr().String(), e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		rr := records()
+		if len(rr) != 1 {
+			t.Fatalf("%v: expected one audit record, got %v", tc.typ, len(rr))
+		}
+		r := rr[0]
+		for k, v := range map[string]string{
+			"id":      "example-id",
+			"type":    tc.typ,
+			"source":  "example/uri",
+			"subject": "example-subject",
+			"result":  tc.result,
+		} {
+			if r[k] != v {
+				t.Errorf("%v: expected audit field %q to be %q, got %v", tc.typ, k, v, r[k])
+			}
+		}
+		if _, ok := r["duration"]; !ok {
+			t.Errorf("%v: audit record missing duration", tc.typ)
+		}
+	}
+}
+
+// TestReadyAfterStart ensures that when enabled, readiness is reported as
+// 503 until the function's Start hook returns.
+func TestReadyAfterStart(t *testing.T) {
+	release := make(chan any)
+	f := &mock.Function{OnStart: func(context.Context, map[string]string) error {
+		<-release
+		return nil
+	}}
+	service := startService(t, f, WithReadyAfterStart())
+
+	ready := func() int {
+		resp, err := http.Get("http://" + service.Addr().String() + "/health/readiness")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return resp.StatusCode
+	}
+
+	if code := ready(); code != http.StatusServiceUnavailable {
+		t.Fatalf("expected %v before Start returned, got %v", http.StatusServiceUnavailable, code)
+	}
+
+	close(release)
+	deadline := time.Now().Add(500 * time.Millisecond)
+	for {
+		code := ready()
+		if code == http.StatusOK {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %v after Start returned, got %v", http.StatusOK, code)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestResponseCompression ensures that a returned event is gzip-encoded when
+// the client accepts gzip, and not otherwise.
+func TestResponseCompression(t *testing.T) {
+	data := bytes.Repeat([]byte("example "), 1024)
+
+	f := &mock.Function{OnHandle: func(_ context.Context, e event.Event) (*event.Event, error) {
+		r := e.Clone()
+		_ = r.SetData("text/plain", data)
+		return &r, nil
+	}}
+	service := startService(t, f, WithResponseCompression())
+
+	send := func(acceptEncoding string) *http.Response {
+		e := cloudevents.NewEvent()
+		e.SetID("1")
+		e.SetSource("example/uri")
+		e.SetType("example.type")
+		req, err := cehttp.NewHTTPRequestFromEvent(context.Background(), "http://"+service.Addr().String(), e)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { resp.Body.Close() })
+		return resp
+	}
+
+	resp := send("gzip")
+	if resp.Header.Get("Content-Encoding") != "gzip" {
+		t.Fatalf("expected gzip content encoding, got %q", resp.Header.Get("Content-Encoding"))
+	}
+	zr, err := gzip.NewReader(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := io.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(body, data) {
+		t.Fatalf("unexpected decompressed body of length %v", len(body))
+	}
+
+	resp = send("identity")
+	if resp.Header.Get("Content-Encoding") != "" {
+		t.Fatalf("unexpected content encoding %q", resp.Header.Get("Content-Encoding"))
+	}
+	body, err = io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(body, data) {
+		t.Fatalf("unexpected body of length %v", len(body))
+	}
+}
+
+// TestStart_Synchronous ensures that when enabled, an error from the
+// function's Start hook is returned from Start before the service listens.
+// By default the Start hook runs after the service is listening.
+func TestStart_Synchronous(t *testing.T) {
+	t.Setenv("LISTEN_ADDRESS", "127.0.0.1:") // use an OS-chosen port
+
+	for _, tc := range []struct {
+		name      string
+		options   []Option
+		listening bool
+	}{
+		{name: "synchronous", options: []Option{WithSynchronousStart()}, listening: false},
+		{name: "asynchronous", listening: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			startErr := errors.New("example start error")
+			f := &mock.Function{OnStart: func(context.Context, map[string]string) error {
+				return startErr
+			}}
+			service := New(f, tc.options...)
+
+			errCh := make(chan error)
+			go func() {
+				errCh <- service.Start(context.Background())
+			}()
+
+			select {
+			case <-time.After(500 * time.Millisecond):
+				t.Fatal("Start did not return the start error")
+			case err := <-errCh:
+				if !errors.Is(err, startErr) {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+			if listening := service.Addr() != nil; listening != tc.listening {
+				t.Fatalf("expected listening %v, got %v", tc.listening, listening)
+			}
+		})
+	}
+}
+
+// withConfigWatchInterval enables restart on config change, checking for
+// changes at the given interval.
+func withConfigWatchInterval(d time.Duration) Option {
+	return func(s *Service) {
+		s.configWatchInterval = d
+	}
+}
+
+// TestRestartOnConfigChange ensures that when enabled, a change to the
+// function's config results in its Stop hook followed by its Start hook
+// being invoked with the new config, while the listener remains bound.
+func TestRestartOnConfigChange(t *testing.T) {
+	wd, _ := os.Getwd() // may not exist if removed by another test
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.Chdir(wd) })
+	if err := os.WriteFile("cfg", []byte("EXAMPLE=v1"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	events := make(chan string, 10)
+	f := &mock.Function{
+		OnStart: func(_ context.Context, cfg map[string]string) error {
+			events <- "start " + cfg["EXAMPLE"]
+			return nil
+		},
+		OnStop: func(context.Context) error {
+			events <- "stop"
+			return nil
+		},
+	}
+	service := startService(t, f, withConfigWatchInterval(10*time.Millisecond))
+	addr := service.Addr().String()
+
+	next := func() string {
+		select {
+		case e := <-events:
+			return e
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for lifecycle event")
+			return ""
+		}
+	}
+	if e := next(); e != "start v1" {
+		t.Fatalf("unexpected lifecycle event %q", e)
+	}
+
+	if err := os.WriteFile("cfg", []byte("EXAMPLE=v2"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if e := next(); e != "stop" {
+		t.Fatalf("expected stop, got %q", e)
+	}
+	if e := next(); e != "start v2" {
+		t.Fatalf("expected start with new config, got %q", e)
+	}
+
+	if service.Addr().String() != addr {
+		t.Fatalf("listener changed from %v to %v", addr, service.Addr())
+	}
+	resp, err := http.Get("http://" + addr + "/health/liveness")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected http status code after restart: %v", resp.StatusCode)
+	}
+}
+
+// TestDecodeData ensures that event data is decoded according to its
+// datacontenttype, and that an unsupported type results in an error.
+func TestDecodeData(t *testing.T) {
+	type payload struct {
+		Message string `json:"message" xml:"message"`
+	}
+	newEvent := func(ct, data string) event.Event {
+		e := event.New()
+		if err := e.SetData(ct, []byte(data)); err != nil {
+			t.Fatal(err)
+		}
+		return e
+	}
+
+	tests := []struct {
+		name string
+		ct   string
+		data string
+	}{
+		{"json", "application/json", `{"message":"hello"}`},
+		{"json with parameters", "application/json; charset=utf-8", `{"message":"hello"}`},
+		{"json suffix", "application/cloudevents+json", `{"message":"hello"}`},
+		{"xml", "application/xml", `<payload><message>hello</message></payload>`},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			p, err := DecodeData[payload](newEvent(test.ct, test.data))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if p.Message != "hello" {
+				t.Fatalf("unexpected decoded data %+v", p)
+			}
+		})
+	}
+
+	t.Run("unsupported", func(t *testing.T) {
+		_, err := DecodeData[payload](newEvent("text/plain", "hello"))
+		if !errors.Is(err, ErrUnsupportedContentType) {
+			t.Fatalf("expected ErrUnsupportedContentType, got %v", err)
+		}
+	})
+}
+
+// TestListening ensures that the service can listen on an OS-chosen port
+// (port 0), reporting the bound address via Addr once Listening.
+func TestListening(t *testing.T) {
+	t.Setenv("LISTEN_ADDRESS", "127.0.0.1:0")
+
+	var (
+		ctx, cancel = context.WithCancel(context.Background())
+		errCh       = make(chan error, 1)
+		invoked     = make(chan any, 1)
+	)
+	f := &mock.Function{OnHandle: func(context.Context, event.Event) (*event.Event, error) {
+		invoked <- true
+		return nil, nil
+	}}
+	service := New(f)
+	go func() {
+		errCh <- service.Start(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		select {
+		case <-errCh:
+		case <-time.After(time.Second):
+			t.Error("service failed to stop")
+		}
+	})
+
+	select {
+	case <-service.Listening():
+	case err := <-errCh:
+		t.Fatalf("service failed to start: %v", err)
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("service failed to listen")
+	}
+	addr := service.Addr().(*net.TCPAddr)
+	if addr.Port == 0 {
+		t.Fatal("expected the OS-chosen port to be reported")
+	}
+
+	resp := postEvent(t, service, "/", []byte("hello"))
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected http status code: %v", resp.StatusCode)
+	}
+	select {
+	case <-invoked:
+	default:
+		t.Fatal("function was not invoked")
+	}
+}
+
+// TestHandle_InvalidEvent ensures that an invalid event (missing the
+// required source attribute) is rejected with a 400 and the validation
+// error, without invoking the function.
+func TestHandle_InvalidEvent(t *testing.T) {
+	var invoked atomic.Bool
+	f := &mock.Function{OnHandle: func(context.Context, event.Event) (*event.Event, error) {
+		invoked.Store(true)
+		return nil, nil
+	}}
+	service := startService(t, f)
+
+	req, err := http.NewRequest(http.MethodPost, "http://"+service.Addr().String()+"/", bytes.NewReader([]byte("hello")))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Ce-Specversion", "1.0")
+	req.Header.Set("Ce-Id", "1")
+	req.Header.Set("Ce-Type", "example.type")
+	req.Header.Set("Content-Type", "application/octet-stream")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("unexpected http status code: %v", resp.StatusCode)
+	}
+	if !strings.Contains(string(body), "source") {
+		t.Fatalf("expected the validation error in the response, got %q", body)
+	}
+	if invoked.Load() {
+		t.Fatal("function was invoked with an invalid event")
+	}
+}
+
+// TestStart_DefaultHandlerInstance ensures that the lifecycle hooks of an
+// instance wrapped in a DefaultHandler are invoked, and that it handles
+// events.
+func TestStart_DefaultHandlerInstance(t *testing.T) {
+	t.Setenv("LISTEN_ADDRESS", "127.0.0.1:") // use an OS-chosen port
+
+	var (
+		ctx, cancel = context.WithCancel(context.Background())
+		startCh     = make(chan any, 1)
+		stopCh      = make(chan any, 1)
+		errCh       = make(chan error, 1)
+		invoked     = make(chan any, 1)
+	)
+	f := &mock.Function{
+		OnStart: func(context.Context, map[string]string) error {
+			startCh <- true
+			return nil
+		},
+		OnStop: func(context.Context) error {
+			stopCh <- true
+			return nil
+		},
+		OnHandle: func(context.Context, event.Event) (*event.Event, error) {
+			invoked <- true
+			return nil, nil
+		},
+	}
+	service := New(DefaultHandler{Handler: f})
+	go func() {
+		errCh <- service.Start(ctx)
+	}()
+
+	select {
+	case <-startCh:
+	case err := <-errCh:
+		t.Fatal(err)
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("Start of the wrapped instance was not invoked")
+	}
+
+	resp := postEvent(t, service, "/", []byte("hello"))
+	_, _ = io.Copy(io.Discard, resp.Body)
+	select {
+	case <-invoked:
+	default:
+		t.Fatal("the wrapped instance was not invoked")
+	}
+
+	cancel()
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("service failed to stop")
+	}
+	select {
+	case <-stopCh:
+	default:
+		t.Fatal("Stop of the wrapped instance was not invoked")
+	}
+}
+
+// TestHealthCheck ensures that a failing health check results in a 503 from
+// the readiness endpoint with a JSON body listing the status of each check.
+func TestHealthCheck(t *testing.T) {
+	f := &mock.Function{}
+	service := startService(t, f,
+		WithHealthCheck("cache", func(context.Context) error { return nil }),
+		WithHealthCheck("db", func(context.Context) error { return errors.New("connection refused") }))
+
+	resp, err := http.Get("http://" + service.Addr().String() + "/health/readiness")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("unexpected http status code: %v", resp.StatusCode)
+	}
+	var payload struct {
+		Checks map[string]checkResult `json:"checks"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.Checks["cache"].Status != "ok" {
+		t.Errorf("unexpected cache check %+v", payload.Checks["cache"])
+	}
+	if c := payload.Checks["db"]; c.Status != "error" || c.Error != "connection refused" {
+		t.Errorf("unexpected db check %+v", c)
+	}
+}
+
+// TestJSONHealth ensures that when enabled, the health endpoints respond
+// with JSON.
+func TestJSONHealth(t *testing.T) {
+	service := startService(t, &mock.Function{}, WithJSONHealth())
+
+	for _, path := range []string{"/health/readiness", "/health/liveness"} {
+		resp, err := http.Get("http://" + service.Addr().String() + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		var status healthStatus
+		if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+			t.Fatal(err)
+		}
+		if resp.StatusCode != http.StatusOK || status.Status != "ok" {
+			t.Fatalf("unexpected %v response %v %+v", path, resp.StatusCode, status)
+		}
+	}
+}
+
+// TestAdminDrain ensures that while draining via the admin endpoint the
+// function reports not ready and refuses new events.
+func TestAdminDrain(t *testing.T) {
+	service := startService(t, &mock.Function{}, WithAdminEndpoints(), WithAdminToken("secret"))
+	url := "http://" + service.Addr().String()
+
+	resp, err := http.Post(url+"/admin/drain", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusForbidden {
+		t.Fatalf("expected forbidden without the token, got %v", resp.StatusCode)
+	}
+
+	req, err := http.NewRequest(http.MethodPost, url+"/admin/drain", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer secret")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusAccepted {
+		t.Fatalf("unexpected drain status code: %v", resp.StatusCode)
+	}
+
+	resp, err = http.Get(url + "/health/readiness")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, _ = io.Copy(io.Discard, resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("expected not ready while draining, got %v", resp.StatusCode)
+	}
+
+	resp = postEvent(t, service, "/", []byte("hello"))
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("expected events refused while draining, got %v", resp.StatusCode)
+	}
+}
+
+// TestIdleShutdown ensures that the service shuts down once it has not
+// handled an event for the idle duration.
+func TestIdleShutdown(t *testing.T) {
+	t.Setenv("LISTEN_ADDRESS", "127.0.0.1:") // use an OS-chosen port
+
+	errCh := make(chan error, 1)
+	service := New(&mock.Function{}, WithIdleShutdown(50*time.Millisecond))
+	go func() {
+		errCh <- service.Start(context.Background())
+	}()
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("service failed to shut down once idle")
+	}
+}
+
+// TestHandlerError ensures that a HandlerError (or SDK Result) returned by
+// a handler sets the response status code and body, and that other errors
+// result in a 500.
+func TestHandlerError(t *testing.T) {
+	f := &mock.Function{OnHandle: func(_ context.Context, e event.Event) (*event.Event, error) {
+		switch string(e.Data()) {
+		case "invalid":
+			return nil, fmt.Errorf("decoding: %w", &HandlerError{Code: http.StatusBadRequest, Message: "invalid input"})
+		case "result":
+			return nil, cehttp.NewResult(http.StatusConflict, "conflict")
+		default:
+			return nil, errors.New("internal fault")
+		}
+	}}
+	service := startService(t, f)
+
+	tests := []struct {
+		data string
+		code int
+		body string
+	}{
+		{"invalid", http.StatusBadRequest, "invalid input"},
+		{"result", http.StatusConflict, "conflict"},
+		{"other", http.StatusInternalServerError, ""},
+	}
+	for _, test := range tests {
+		resp := postEvent(t, service, "/", []byte(test.data))
+		body, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != test.code {
+			t.Fatalf("%v: unexpected http status code: %v", test.data, resp.StatusCode)
+		}
+		if string(body) != test.body {
+			t.Fatalf("%v: unexpected body %q", test.data, body)
+		}
+	}
+}
+
+// TestRequestID ensures that requests are assigned an ID which is available
+// to the function, echoed in the response and included in audit records.
+func TestRequestID(t *testing.T) {
+	var buf syncBuffer
+	logger := zlog.Logger
+	zlog.Logger = zerolog.New(&buf)
+	t.Cleanup(func() { zlog.Logger = logger })
+
+	ids := make(chan string, 1)
+	f := &mock.Function{OnHandle: func(ctx context.Context, _ event.Event) (*event.Event, error) {
+		ids <- RequestID(ctx)
+		return nil, nil
+	}}
+	service := startService(t, f, WithRequestID(), WithAuditLog())
+
+	resp := postEvent(t, service, "/", []byte("hello"))
+	_, _ = io.Copy(io.Discard, resp.Body)
+	id := resp.Header.Get(RequestIDHeader)
+	if id == "" {
+		t.Fatal("expected a generated request ID in the response")
+	}
+	if got := <-ids; got != id {
+		t.Fatalf("function saw request ID %q, response has %q", got, id)
+	}
+	if !strings.Contains(string(buf.Bytes()), `"request_id":"`+id+`"`) {
+		t.Fatalf("expected the request ID in the audit record, got %s", buf.Bytes())
+	}
+}
+
+// TestHealthResponse ensures that the success responses of the health
+// endpoints can be customized.
+func TestHealthResponse(t *testing.T) {
+	service := startService(t, &mock.Function{},
+		WithReadinessResponse(http.StatusAccepted, "ok"),
+		WithLivenessResponse(http.StatusOK, "up"))
+
+	for path, want := range map[string]struct {
+		code int
+		body string
+	}{
+		"/health/readiness": {http.StatusAccepted, "ok"},
+		"/health/liveness":  {http.StatusOK, "up"},
+	} {
+		resp, err := http.Get("http://" + service.Addr().String() + path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		if resp.StatusCode != want.code || string(body) != want.body {
+			t.Fatalf("unexpected %v response %v %q", path, resp.StatusCode, body)
+		}
+	}
+}
+
+// TestEventPath ensures that events are received on the path set using
+// WithEventPath, and not on the default path.
+func TestEventPath(t *testing.T) {
+	var invoked atomic.Int64
+	f := &mock.Function{OnHandle: func(context.Context, event.Event) (*event.Event, error) {
+		invoked.Add(1)
+		return nil, nil
+	}}
+	service := startService(t, f, WithEventPath("/events"))
+
+	resp := postEvent(t, service, "/events", []byte("hello"))
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode != http.StatusOK || invoked.Load() != 1 {
+		t.Fatalf("expected the event to be received on the custom path, got %v", resp.StatusCode)
+	}
+	resp = postEvent(t, service, "/", []byte("hello"))
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode != http.StatusNotFound || invoked.Load() != 1 {
+		t.Fatalf("expected events on the default path to be rejected, got %v", resp.StatusCode)
+	}
+}
+
+// TestConfigFromContext ensures that the function's config is available to
+// its handler using ConfigFromContext.
+func TestConfigFromContext(t *testing.T) {
+	t.Setenv("TEST_CONFIG_VALUE", "example")
+	values := make(chan string, 1)
+	f := &mock.Function{OnHandle: func(ctx context.Context, _ event.Event) (*event.Event, error) {
+		values <- ConfigFromContext(ctx)["TEST_CONFIG_VALUE"]
+		return nil, nil
+	}}
+	service := startService(t, f)
+
+	resp := postEvent(t, service, "/", []byte("hello"))
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if v := <-values; v != "example" {
+		t.Fatalf("unexpected config value: %q", v)
+	}
+}
+
+// roundTripFunc is an http.RoundTripper implemented by a function.
+type roundTripFunc func(*http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) {
+	return f(r)
+}
+
+// TestSink ensures that when K_SINK is set, response events are delivered to
+// the sink using the client set with WithSinkClient, and that a failure to
+// deliver fails the invocation.
+func TestSink(t *testing.T) {
+	var (
+		received = make(chan string, 1)
+		fail     atomic.Bool
+		sent     atomic.Int64
+	)
+	sinkServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		if fail.Load() {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		received <- r.Header.Get("Ce-Id")
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	t.Cleanup(sinkServer.Close)
+	t.Setenv("K_SINK", sinkServer.URL)
+
+	client := &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		sent.Add(1)
+		return http.DefaultTransport.RoundTrip(r)
+	})}
+	f := &mock.Function{OnHandle: func(context.Context, event.Event) (*event.Event, error) {
+		e := cloudevents.NewEvent()
+		e.SetID("response-id")
+		e.SetSource("example/uri")
+		e.SetType("example.response")
+		return &e, nil
+	}}
+	service := startService(t, f, WithSinkClient(client))
+
+	resp := postEvent(t, service, "/", []byte("hello"))
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Ce-Id") != "" {
+		t.Fatalf("expected an empty response, got %v (ce-id %q)", resp.StatusCode, resp.Header.Get("Ce-Id"))
+	}
+	select {
+	case id := <-received:
+		if id != "response-id" {
+			t.Fatalf("unexpected event delivered to sink %q", id)
+		}
+	default:
+		t.Fatal("response event not delivered to sink")
+	}
+	if sent.Load() != 1 {
+		t.Fatal("sink client not used")
+	}
+
+	fail.Store(true)
+	resp = postEvent(t, service, "/", []byte("hello"))
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode != http.StatusInternalServerError {
+		t.Fatalf("expected a failed delivery to fail the invocation, got %v", resp.StatusCode)
+	}
+}
+
+// TestSinkRetries ensures that delivery to a sink which fails transiently is
+// retried, that an event which can not be delivered is dropped when
+// enabled, and that an invalid sink or retry count fails Start.
+func TestSinkRetries(t *testing.T) {
+	var (
+		attempts  atomic.Int64
+		failUntil atomic.Int64
+	)
+	sinkServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		if attempts.Add(1) <= failUntil.Load() {
+			w.Header().Set("Retry-After", "0")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	t.Cleanup(sinkServer.Close)
+	t.Setenv("K_SINK", sinkServer.URL)
+
+	f := &mock.Function{OnHandle: func(context.Context, event.Event) (*event.Event, error) {
+		e := cloudevents.NewEvent()
+		e.SetID("response-id")
+		e.SetSource("example/uri")
+		e.SetType("example.response")
+		return &e, nil
+	}}
+	service := startService(t, f, WithSinkRetries(2, time.Millisecond), WithSinkDropOnFailure())
+
+	failUntil.Store(2) // succeeds on the final retry
+	resp := postEvent(t, service, "/", []byte("hello"))
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode != http.StatusOK || attempts.Load() != 3 {
+		t.Fatalf("expected delivery after retries, got %v after %v attempts", resp.StatusCode, attempts.Load())
+	}
+
+	attempts.Store(0)
+	failUntil.Store(10) // retries exhausted
+	resp = postEvent(t, service, "/", []byte("hello"))
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode != http.StatusOK || attempts.Load() != 3 {
+		t.Fatalf("expected the event to be dropped after retries, got %v after %v attempts", resp.StatusCode, attempts.Load())
+	}
+
+	// Invalid configuration of the environment fails Start, not New.
+	for _, env := range [][2]string{{"K_SINK", "not a url"}, {"FUNC_SINK_RETRIES", "many"}, {"FUNC_SINK_RETRIES", "-1"}} {
+		t.Setenv(env[0], env[1])
+		if err := New(&mock.Function{}).Start(context.Background()); err == nil {
+			t.Fatalf("expected %v=%q to fail", env[0], env[1])
+		}
+		t.Setenv("K_SINK", sinkServer.URL)
+		t.Setenv("FUNC_SINK_RETRIES", "")
+	}
+
+	if d := parseRetryAfter("2"); d != 2*time.Second {
+		t.Fatalf("unexpected Retry-After delay %v", d)
+	}
+	if d := parseRetryAfter(time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)); d != maxRetryAfter {
+		t.Fatalf("expected Retry-After to be limited, got %v", d)
+	}
+}
+
+// TestStart_UnsupportedHandler ensures that a function without a supported
+// Handle signature fails to start with a descriptive error, rather than
+// panicking.
+func TestStart_UnsupportedHandler(t *testing.T) {
+	t.Setenv("LISTEN_ADDRESS", "127.0.0.1:") // use an OS-chosen port
+
+	for name, f := range map[string]any{
+		"instance":    struct{}{},
+		"static":      DefaultHandler{Handler: func(string) {}},
+		"nil":         DefaultHandler{},
+		"not a func":  DefaultHandler{Handler: struct{}{}},
+		"wrong param": DefaultHandler{Handler: func(context.Context, string) error { return nil }},
+	} {
+		err := New(f).Start(context.Background())
+		if !errors.Is(err, ErrUnsupportedHandler) {
+			t.Fatalf("%v: expected ErrUnsupportedHandler, got %v", name, err)
+		}
+		if !strings.Contains(err.Error(), "Handle(context.Context, event.Event) (*event.Event, error)") {
+			t.Fatalf("%v: expected the supported signatures in the error, got %v", name, err)
+		}
+	}
+}
+
+// TestLargeEvent ensures that a multi-megabyte structured event is received
+// in full when the size and timeout limits are configured to allow it.
+func TestLargeEvent(t *testing.T) {
+	const size = 4 << 20
+
+	var received atomic.Int64
+	f := &mock.Function{OnHandle: func(_ context.Context, e event.Event) (*event.Event, error) {
+		var data string
+		if err := e.DataAs(&data); err != nil {
+			return nil, err
+		}
+		received.Store(int64(len(data)))
+		return nil, nil
+	}}
+	service := startService(t, f, WithMaxEventSize(8<<20),
+		WithReadTimeout(time.Minute), WithWriteTimeout(time.Minute))
+
+	body := fmt.Sprintf(`{"specversion":"1.0","id":"1","source":"example/uri","type":"example.type","datacontenttype":"application/json","data":%q}`,
+		strings.Repeat("a", size))
+	resp, err := http.Post("http://"+service.Addr().String()+"/", "application/cloudevents+json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected http status code: %v", resp.StatusCode)
+	}
+	if got := received.Load(); got != size {
+		t.Fatalf("expected %v bytes of data, got %v", size, got)
+	}
+}
+
+// TestResponseHeader ensures that headers set by the function using
+// ResponseHeader are written with the response, without overriding those
+// describing the response event.
+func TestResponseHeader(t *testing.T) {
+	f := &mock.Function{OnHandle: func(ctx context.Context, e event.Event) (*event.Event, error) {
+		ResponseHeader(ctx).Set("Cache-Control", "no-store")
+		ResponseHeader(ctx).Set("Ce-Type", "overridden")
+		out := event.New()
+		out.SetID("2")
+		out.SetSource("example/response")
+		out.SetType("example.response")
+		return &out, nil
+	}}
+	service := startService(t, f)
+
+	resp := postEvent(t, service, "/", []byte("data"))
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected http status code: %v", resp.StatusCode)
+	}
+	if got := resp.Header.Get("Cache-Control"); got != "no-store" {
+		t.Fatalf("expected header set by the function, got %q", got)
+	}
+	if got := resp.Header.Get("Ce-Type"); got != "example.response" {
+		t.Fatalf("expected the response event's type, got %q", got)
+	}
+
+	// Outside of a request
+	ResponseHeader(context.Background()).Set("Cache-Control", "no-store")
+}
+
+// batchFunction is a function instance which responds with several events.
+type batchFunction struct {
+	*mock.Function
+	events []event.Event
+}
+
+func (f batchFunction) Handle(context.Context, event.Event) ([]event.Event, error) {
+	return f.events, nil
+}
+
+// batchPtrFunction is a function instance which responds with several
+// events, returned as pointers.
+type batchPtrFunction struct {
+	*mock.Function
+	events []*event.Event
+}
+
+func (f batchPtrFunction) Handle(context.Context, event.Event) ([]*event.Event, error) {
+	return f.events, nil
+}
+
+// TestBatchResponse ensures that several events returned by the function,
+// including as pointers, are responded with as a batch, or are each
+// delivered to the sink if K_SINK is set.
+func TestBatchResponse(t *testing.T) {
+	var events []event.Event
+	for _, id := range []string{"2", "3"} {
+		e := event.New()
+		e.SetID(id)
+		e.SetSource("example/response")
+		e.SetType("example.response")
+		events = append(events, e)
+	}
+	expectBatch := func(service *Service) {
+		t.Helper()
+		resp := postEvent(t, service, "/", []byte("data"))
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("unexpected http status code: %v", resp.StatusCode)
+		}
+		if ct := resp.Header.Get("Content-Type"); ct != event.ApplicationCloudEventsBatchJSON {
+			t.Fatalf("unexpected content type %q", ct)
+		}
+		var batch []event.Event
+		if err := json.NewDecoder(resp.Body).Decode(&batch); err != nil {
+			t.Fatal(err)
+		}
+		if len(batch) != 2 || batch[0].ID() != "2" || batch[1].ID() != "3" {
+			t.Fatalf("unexpected batch %v", batch)
+		}
+	}
+	f := &mock.Function{}
+	expectBatch(startInstance(t, f, batchFunction{Function: f, events: events}))
+	f = &mock.Function{}
+	expectBatch(startInstance(t, f, batchPtrFunction{Function: f, events: []*event.Event{&events[0], nil, &events[1]}}))
+
+	// Delivered to the sink
+	received := make(chan string, 2)
+	sinkServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(io.Discard, r.Body)
+		received <- r.Header.Get("Ce-Id")
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	t.Cleanup(sinkServer.Close)
+	t.Setenv("K_SINK", sinkServer.URL)
+	f = &mock.Function{}
+	service := startInstance(t, f, batchFunction{Function: f, events: events})
+
+	resp := postEvent(t, service, "/", []byte("data"))
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") == event.ApplicationCloudEventsBatchJSON {
+		t.Fatalf("expected an empty response, got %v (%v)", resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+	for _, want := range []string{"2", "3"} {
+		select {
+		case id := <-received:
+			if id != want {
+				t.Fatalf("expected event %v delivered to sink, got %v", want, id)
+			}
+		default:
+			t.Fatal("response events not delivered to sink")
+		}
+	}
+}
+
+// TestResponseContentMode ensures that a response event is written in the
+// content mode of the request: binary in response to binary, and structured
+// in response to structured.
+func TestResponseContentMode(t *testing.T) {
+	f := &mock.Function{OnHandle: func(_ context.Context, e event.Event) (*event.Event, error) {
+		r := event.New()
+		r.SetID("2")
+		r.SetSource("example/response")
+		r.SetType("example.response")
+		if err := r.SetData("text/plain", "response to "+string(e.Data())); err != nil {
+			return nil, err
+		}
+		return &r, nil
+	}}
+	service := startService(t, f)
+
+	// Binary
+	resp := postEvent(t, service, "/", []byte("binary"))
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected http status code: %v", resp.StatusCode)
+	}
+	if resp.Header.Get("Ce-Id") != "2" || resp.Header.Get("Ce-Type") != "example.response" {
+		t.Fatalf("expected a binary response event, got headers %v", resp.Header)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "text/plain" || string(body) != "response to binary" {
+		t.Fatalf("unexpected response data %q (%v)", body, ct)
+	}
+
+	// Structured
+	req, err := http.NewRequest(http.MethodPost, "http://"+service.Addr().String(), strings.NewReader(
+		`{"specversion":"1.0","id":"1","source":"example/uri","type":"example.type","datacontenttype":"text/plain","data":"structured"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Content-Type", "application/cloudevents+json; charset=utf-8")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected http status code: %v", resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != event.ApplicationCloudEventsJSON {
+		t.Fatalf("expected a structured response event, got content type %q", ct)
+	}
+	if resp.Header.Get("Ce-Id") != "" {
+		t.Fatalf("unexpected binary headers in a structured response: %v", resp.Header)
+	}
+	var e event.Event
+	if err := json.NewDecoder(resp.Body).Decode(&e); err != nil {
+		t.Fatal(err)
+	}
+	if e.ID() != "2" || e.Type() != "example.response" || string(e.Data()) != "response to structured" {
+		t.Fatalf("unexpected response event %v", e)
+	}
+}
+
+// TestEventFilter ensures that events not matching FUNC_EVENT_FILTER are
+// acknowledged with a 204 without invoking the function, that matching
+// events are handled, and that an invalid filter fails to start.
+func TestEventFilter(t *testing.T) {
+	t.Setenv("FUNC_EVENT_FILTER", "type = 'example.type' AND source LIKE 'example/%'")
+	var invoked atomic.Int32
+	f := &mock.Function{OnHandle: func(context.Context, event.Event) (*event.Event, error) {
+		invoked.Add(1)
+		return nil, nil
+	}}
+	service := startService(t, f)
+
+	post := func(typ string) int {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodPost, "http://"+service.Addr().String(), strings.NewReader("data"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Ce-Specversion", "1.0")
+		req.Header.Set("Ce-Id", "1")
+		req.Header.Set("Ce-Source", "example/uri")
+		req.Header.Set("Ce-Type", typ)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return resp.StatusCode
+	}
+	if code := post("example.type"); code != http.StatusOK || invoked.Load() != 1 {
+		t.Fatalf("expected a matching event to be handled, got %v (invoked %v)", code, invoked.Load())
+	}
+	if code := post("other.type"); code != http.StatusNoContent || invoked.Load() != 1 {
+		t.Fatalf("expected a non-matching event to be filtered, got %v (invoked %v)", code, invoked.Load())
+	}
+
+	t.Setenv("FUNC_EVENT_FILTER", "type =")
+	if err := New(&mock.Function{}).Start(context.Background()); err == nil {
+		t.Fatal("expected an invalid FUNC_EVENT_FILTER to fail")
+	}
+}
+
+// TestNoReplyStatus ensures that a function which returns no event is
+// responded to with a 200 by default, or with the status configured using
+// WithNoReplyStatus, and that one which returns an event is responded to with
+// a 200 and the event.
+func TestNoReplyStatus(t *testing.T) {
+	f := &mock.Function{OnHandle: func(_ context.Context, e event.Event) (*event.Event, error) {
+		if string(e.Data()) != "reply" {
+			return nil, nil
+		}
+		r := event.New()
+		r.SetID("2")
+		r.SetSource("example/response")
+		r.SetType("example.response")
+		return &r, nil
+	}}
+
+	tests := []struct {
+		name    string
+		options []Option
+		data    string
+		code    int
+	}{
+		{"default no reply", nil, "ack", http.StatusOK},
+		{"default reply", nil, "reply", http.StatusOK},
+		{"no reply", []Option{WithNoReplyStatus(http.StatusNoContent)}, "ack", http.StatusNoContent},
+		{"reply", []Option{WithNoReplyStatus(http.StatusNoContent)}, "reply", http.StatusOK},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			service := startService(t, f, test.options...)
+			resp := postEvent(t, service, "/", []byte(test.data))
+			_, _ = io.Copy(io.Discard, resp.Body)
+			if resp.StatusCode != test.code {
+				t.Fatalf("expected http status code %v, got %v", test.code, resp.StatusCode)
+			}
+			if reply := resp.Header.Get("Ce-Id") == "2"; reply != (test.data == "reply") {
+				t.Fatalf("unexpected response event headers %v", resp.Header)
+			}
+		})
+	}
+}
@@ -0,0 +1,15 @@
+package cloudevents
+
+import "context"
+
+// WithShutdownHook registers fn to be invoked when the service shuts down,
+// after the server has closed and the function's Stop hook, whether or not the
+// function implements Stopper.  This allows an embedder to clean up
+// resources it owns, such as flushing a metrics client.  Hooks are invoked
+// in the order registered, each with up to InstanceStopTimeout to complete,
+// and their errors are returned from Start along with any others.
+func WithShutdownHook(fn func(context.Context) error) Option {
+	return func(s *Service) {
+		s.shutdownHooks = append(s.shutdownHooks, fn)
+	}
+}
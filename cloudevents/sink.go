@@ -0,0 +1,200 @@
+package cloudevents
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"time"
+
+	"github.com/cloudevents/sdk-go/v2/event"
+	cehttp "github.com/cloudevents/sdk-go/v2/protocol/http"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// DefaultSinkTimeout is the timeout of the default client used to
+	// deliver response events to the sink.
+	DefaultSinkTimeout = 30 * time.Second
+
+	// DefaultSinkRetries is the number of times delivery of a response event
+	// to the sink is retried should it fail transiently.
+	DefaultSinkRetries = 3
+
+	// DefaultSinkBackoff is the delay before the first retry, which doubles
+	// with each subsequent retry.
+	DefaultSinkBackoff = 100 * time.Millisecond
+
+	// maxRetryAfter limits how long a sink's Retry-After can delay a retry.
+	maxRetryAfter = 30 * time.Second
+)
+
+// WithSinkClient sets the HTTP client used to deliver response events to
+// the sink, for example to configure a proxy, TLS (such as mTLS to the
+// sink) or timeouts.  By default a client with a timeout of
+// DefaultSinkTimeout is used.  The client's timeout applies to each
+// delivery attempt rather than to all retries (see WithSinkRetries).
+//
+// When the environment variable K_SINK is set, as it is by a Knative
+// SinkBinding, events returned by the function are delivered to the sink
+// rather than in the response, which is then empty.  Should delivery fail,
+// the invocation fails with a 500, such that the sender may retry the
+// event which caused it, unless WithSinkDropOnFailure is used.
+func WithSinkClient(c *http.Client) Option {
+	return func(s *Service) {
+		s.sink.client = c
+	}
+}
+
+// WithSinkRetries sets the number of times delivery of a response event to
+// the sink is retried should it fail transiently (a connection error, or a
+// 408, 429 or 5xx response), and the delay before the first retry, which
+// doubles with each subsequent retry.  A Retry-After in the sink's response
+// is honored instead of the delay.  Zero retries disables retrying.
+//
+// If not set using this option, the number of retries is read from the
+// environment variable FUNC_SINK_RETRIES, or is DefaultSinkRetries, with a
+// delay of DefaultSinkBackoff.
+func WithSinkRetries(n int, backoff time.Duration) Option {
+	return func(s *Service) {
+		s.sink.retries, s.sink.backoff, s.sink.retriesSet = n, backoff, true
+	}
+}
+
+// WithSinkDropOnFailure logs and drops a response event which could not be
+// delivered to the sink once retries are exhausted, rather than failing
+// the invocation.
+func WithSinkDropOnFailure() Option {
+	return func(s *Service) {
+		s.sink.drop = true
+	}
+}
+
+// sink delivers response events to a target.
+type sink struct {
+	target     string
+	client     *http.Client
+	retries    int
+	backoff    time.Duration
+	retriesSet bool
+	drop       bool
+}
+
+// init the sink from the environment, returning whether a target is set.
+func (s *sink) init() (bool, error) {
+	if s.target = os.Getenv("K_SINK"); s.target == "" {
+		return false, nil
+	}
+	if _, err := url.ParseRequestURI(s.target); err != nil {
+		return false, fmt.Errorf("invalid K_SINK: %w", err)
+	}
+	if s.client == nil {
+		s.client = &http.Client{Timeout: DefaultSinkTimeout}
+	}
+	if !s.retriesSet {
+		s.retries, s.backoff = DefaultSinkRetries, DefaultSinkBackoff
+		if v := os.Getenv("FUNC_SINK_RETRIES"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				return false, fmt.Errorf("invalid FUNC_SINK_RETRIES: %w", err)
+			}
+			if n < 0 {
+				return false, fmt.Errorf("invalid FUNC_SINK_RETRIES: %d is negative", n)
+			}
+			s.retries = n
+		}
+	}
+	log.Debug().Str("sink", s.target).Int("retries", s.retries).Msg("delivering response events to sink")
+	return true, nil
+}
+
+// deliver wraps a receiveFn such that any events it returns are delivered,
+// in order, to the sink rather than returned.
+func (s *sink) deliver(next receiveFn) receiveFn {
+	return func(ctx context.Context, e event.Event) ([]event.Event, error) {
+		out, err := next(ctx, e)
+		if err != nil {
+			return out, err
+		}
+		for _, o := range out {
+			if err := s.send(ctx, o); err != nil {
+				if s.drop {
+					log.Error().Err(err).Msg("dropping response event")
+					continue
+				}
+				return nil, err
+			}
+		}
+		return nil, nil
+	}
+}
+
+// send the event to the sink, retrying transient failures.
+func (s *sink) send(ctx context.Context, e event.Event) error {
+	backoff := s.backoff
+	for attempt := 0; ; attempt++ {
+		retryAfter, err := s.post(ctx, e)
+		if err == nil {
+			return nil
+		}
+		if retryAfter < 0 || attempt >= s.retries {
+			return fmt.Errorf("delivering event %v to sink %v: %w", e.ID(), s.target, err)
+		}
+		wait := backoff
+		if retryAfter > 0 {
+			wait = retryAfter
+		}
+		log.Debug().Err(err).Dur("wait", wait).Int("attempt", attempt+1).Msg("retrying delivery to sink")
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return fmt.Errorf("delivering event %v to sink %v: %w", e.ID(), s.target, ctx.Err())
+		}
+		backoff *= 2
+	}
+}
+
+// post the event to the sink once, returning an error if it was not
+// accepted along with how long to wait before retrying: the sink's
+// Retry-After if set, zero to use the backoff, or -1 if not retriable.
+func (s *sink) post(ctx context.Context, e event.Event) (time.Duration, error) {
+	req, err := cehttp.NewHTTPRequestFromEvent(ctx, s.target, e)
+	if err != nil {
+		return -1, err
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return 0, err // connection errors are transient
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode < 300 {
+		return 0, nil
+	}
+	err = fmt.Errorf("sink responded %v", resp.Status)
+	switch code := resp.StatusCode; {
+	case code == http.StatusRequestTimeout, code == http.StatusTooManyRequests, code >= 500:
+		return parseRetryAfter(resp.Header.Get("Retry-After")), err
+	default:
+		return -1, err
+	}
+}
+
+// parseRetryAfter returns the delay of a Retry-After header, which is either
+// a number of seconds or a date, limited to maxRetryAfter, or zero if not
+// set or invalid.
+func parseRetryAfter(v string) time.Duration {
+	var d time.Duration
+	if seconds, err := strconv.Atoi(v); err == nil {
+		d = time.Duration(seconds) * time.Second
+	} else if t, err := http.ParseTime(v); err == nil {
+		d = time.Until(t)
+	}
+	if d < 0 {
+		return 0
+	}
+	return min(d, maxRetryAfter)
+}
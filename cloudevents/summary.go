@@ -0,0 +1,55 @@
+package cloudevents
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/rs/zerolog/log"
+
+	"knative.dev/func-go/internal/logs"
+)
+
+// logSummary logs the effective configuration of the service once
+// listening, such that misconfiguration (for example of the environment)
+// is apparent from a single log line.
+func (s *Service) logSummary() {
+	e := log.Info().
+		Str("address", s.listener.Addr().String()).
+		Str("event_path", s.eventPath).
+		Str("handler", fmt.Sprintf("%T", s.f)).
+		Strs("implements", implemented(s.f)).
+		Dur("read_timeout", s.ReadTimeout).
+		Dur("write_timeout", s.WriteTimeout).
+		Dur("idle_timeout", s.IdleTimeout)
+	if s.maxEventSize > 0 {
+		e = e.Int64("max_event_size", s.maxEventSize)
+	}
+	if filter := os.Getenv("FUNC_EVENT_FILTER"); filter != "" {
+		e = e.Str("event_filter", filter)
+	}
+	if s.noReply != 0 {
+		e = e.Int("no_reply_status", s.noReply)
+	}
+	if s.sink.target != "" {
+		e = e.Str("sink", logs.RedactURL(s.sink.target)).Int("sink_retries", s.sink.retries)
+	}
+	e.Msg("function runtime configured")
+}
+
+// implemented returns the names of the optional interfaces which the
+// function implements.
+func implemented(f any) (ii []string) {
+	if _, ok := f.(Starter); ok {
+		ii = append(ii, "Start")
+	}
+	if _, ok := f.(Stopper); ok {
+		ii = append(ii, "Stop")
+	}
+	if _, ok := f.(ReadinessReporter); ok {
+		ii = append(ii, "Ready")
+	}
+	if _, ok := f.(LivenessReporter); ok {
+		ii = append(ii, "Alive")
+	}
+	return
+}
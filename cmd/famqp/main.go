@@ -0,0 +1,50 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	amqp091 "github.com/rabbitmq/amqp091-go"
+	fn "knative.dev/func-go/amqp"
+)
+
+// Main illustrates how scaffolding works to wrap a user's function.
+//
+// Run against a local RabbitMQ server with a queue, for example:
+//
+//	docker run -d -p 5672:5672 rabbitmq
+//	rabbitmqadmin declare queue name=example
+//	AMQP_QUEUE=example go run ./cmd/famqp
+//	rabbitmqadmin publish routing_key=example payload=hello
+func main() {
+	// Instanced example (in scaffolding, 'New()' will be in module 'f')
+	if err := fn.Start(New()); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+
+	// Static example (in scaffolding 'Handle' will be in module f
+	// if err := fn.Start(fn.DefaultHandler{Handle}); err != nil {
+	// 	fmt.Fprintln(os.Stderr, err.Error())
+	// 	os.Exit(1)
+	// }
+}
+
+// Example Static AMQP Handler implementation.
+func Handle(ctx context.Context, d *amqp091.Delivery) error {
+	fmt.Printf("Static AMQP handler invoked: %v %s\n", d.RoutingKey, d.Body)
+	return nil
+}
+
+// MyFunction is an example instanced AMQP function implementation.
+type MyFunction struct{}
+
+func New() *MyFunction {
+	return &MyFunction{}
+}
+
+func (f *MyFunction) Handle(ctx context.Context, d *amqp091.Delivery) error {
+	fmt.Printf("Instanced AMQP handler invoked: %v %s\n", d.RoutingKey, d.Body)
+	return nil
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	fn "knative.dev/func-go/grpc"
+)
+
+// Main illustrates how scaffolding works to wrap a user's function.
+func main() {
+	// Instanced example (in scaffolding, 'New()' will be in module 'f')
+	if err := fn.Start(New()); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+
+	// Static example (in scaffolding 'Handle' will be in module f
+	// if err := fn.Start(fn.DefaultHandler{Handle}); err != nil {
+	// 	fmt.Fprintln(os.Stderr, err.Error())
+	// 	os.Exit(1)
+	// }
+}
+
+// Example Static gRPC Handler implementation.
+func Handle(ctx context.Context, req []byte) ([]byte, error) {
+	fmt.Println("Static gRPC handler invoked")
+	return req, nil // echo to caller
+}
+
+// MyFunction is an example instanced gRPC function implementation.
+type MyFunction struct{}
+
+func New() *MyFunction {
+	return &MyFunction{}
+}
+
+func (f *MyFunction) Handle(ctx context.Context, req []byte) ([]byte, error) {
+	fmt.Println("Instanced gRPC handler invoked")
+	return req, nil // echo to caller
+}
@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"time"
+
+	fn "knative.dev/func-go/http"
+)
+
+// Main illustrates a function which streams Server-Sent Events.
+func main() {
+	if err := fn.Start(fn.StreamHandler(Handle)); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+}
+
+// Handle streams a progress event each second until complete or the
+// client disconnects.
+func Handle(r *http.Request, send func(fn.Event) error) error {
+	ticker := time.NewTicker(time.Second)
+	defer ticker.Stop()
+	for i := 1; i <= 10; i++ {
+		select {
+		case <-ticker.C:
+			if err := send(fn.Event{Event: "progress", Data: fmt.Sprintf("%v%%", i*10)}); err != nil {
+				return err
+			}
+		case <-r.Context().Done():
+			return nil
+		}
+	}
+	return send(fn.Event{Event: "done"})
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+
+	fn "knative.dev/func-go/http"
+)
+
+// Main illustrates a function which upgrades requests to WebSockets,
+// echoing each frame received.  A WebSocket library would typically be used
+// in place of the minimal framing implemented here.
+func main() {
+	if err := fn.New(&MyFunction{}, fn.WithoutUpgradeTimeouts()).Start(context.Background()); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+}
+
+// websocketGUID is used to compute the Sec-WebSocket-Accept header.
+// See RFC 6455 Section 1.3.
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// WebSocket opcodes.
+const (
+	opClose = 0x8
+	opPing  = 0x9
+	opPong  = 0xA
+)
+
+// MyFunction is an example function which echoes WebSocket frames.
+type MyFunction struct{}
+
+// Handle upgrades the request to a WebSocket which echoes each frame.
+func (f *MyFunction) Handle(w http.ResponseWriter, r *http.Request) {
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") || key == "" {
+		http.Error(w, "expected a WebSocket upgrade", http.StatusBadRequest)
+		return
+	}
+	conn, brw, err := http.NewResponseController(w).Hijack()
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	defer conn.Close()
+
+	sum := sha1.Sum([]byte(key + websocketGUID))
+	fmt.Fprintf(brw, "HTTP/1.1 101 Switching Protocols\r\n"+
+		"Upgrade: websocket\r\n"+
+		"Connection: Upgrade\r\n"+
+		"Sec-WebSocket-Accept: %v\r\n\r\n", base64.StdEncoding.EncodeToString(sum[:]))
+	if err := brw.Flush(); err != nil {
+		return
+	}
+
+	for {
+		op, payload, err := readFrame(brw.Reader)
+		if err != nil {
+			return
+		}
+		switch op {
+		case opClose:
+			_ = writeFrame(brw.Writer, opClose, payload)
+			return
+		case opPing:
+			op = opPong
+		}
+		if err := writeFrame(brw.Writer, op, payload); err != nil {
+			return
+		}
+	}
+}
+
+// readFrame reads a single (client, and thus masked) frame.
+func readFrame(r *bufio.Reader) (op byte, payload []byte, err error) {
+	var h [2]byte
+	if _, err = io.ReadFull(r, h[:]); err != nil {
+		return
+	}
+	op = h[0] & 0x0F
+	n := uint64(h[1] & 0x7F)
+	switch n {
+	case 126:
+		var b [2]byte
+		if _, err = io.ReadFull(r, b[:]); err != nil {
+			return
+		}
+		n = uint64(binary.BigEndian.Uint16(b[:]))
+	case 127:
+		var b [8]byte
+		if _, err = io.ReadFull(r, b[:]); err != nil {
+			return
+		}
+		n = binary.BigEndian.Uint64(b[:])
+	}
+	if n > 1<<20 {
+		return op, nil, fmt.Errorf("frame of %v bytes exceeds the maximum", n)
+	}
+	var mask [4]byte
+	if h[1]&0x80 != 0 {
+		if _, err = io.ReadFull(r, mask[:]); err != nil {
+			return
+		}
+	}
+	payload = make([]byte, n)
+	if _, err = io.ReadFull(r, payload); err != nil {
+		return
+	}
+	for i := range payload {
+		payload[i] ^= mask[i%4]
+	}
+	return
+}
+
+// writeFrame writes a single unfragmented (server, and thus unmasked) frame.
+func writeFrame(w *bufio.Writer, op byte, payload []byte) error {
+	_ = w.WriteByte(0x80 | op) // FIN
+	switch n := len(payload); {
+	case n < 126:
+		_ = w.WriteByte(byte(n))
+	case n <= 0xFFFF:
+		_ = w.WriteByte(126)
+		_ = binary.Write(w, binary.BigEndian, uint16(n))
+	default:
+		_ = w.WriteByte(127)
+		_ = binary.Write(w, binary.BigEndian, uint64(n))
+	}
+	_, _ = w.Write(payload)
+	return w.Flush()
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+	fn "knative.dev/func-go/mqtt"
+)
+
+// Main illustrates how scaffolding works to wrap a user's function.
+//
+// Run against a local broker, for example:
+//
+//	mosquitto &
+//	MQTT_TOPICS=example/topic go run ./cmd/fmqtt
+//	mosquitto_pub -t example/topic -q 1 -m hello
+func main() {
+	// Instanced example (in scaffolding, 'New()' will be in module 'f')
+	if err := fn.Start(New()); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+
+	// Static example (in scaffolding 'Handle' will be in module f
+	// if err := fn.Start(fn.DefaultHandler{Handle}); err != nil {
+	// 	fmt.Fprintln(os.Stderr, err.Error())
+	// 	os.Exit(1)
+	// }
+}
+
+// Example Static MQTT Handler implementation.
+func Handle(ctx context.Context, m paho.Message) error {
+	fmt.Printf("Static MQTT handler invoked: %v %s\n", m.Topic(), m.Payload())
+	return nil
+}
+
+// MyFunction is an example instanced MQTT function implementation.
+type MyFunction struct{}
+
+func New() *MyFunction {
+	return &MyFunction{}
+}
+
+func (f *MyFunction) Handle(ctx context.Context, m paho.Message) error {
+	fmt.Printf("Instanced MQTT handler invoked: %v %s\n", m.Topic(), m.Payload())
+	return nil
+}
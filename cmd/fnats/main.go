@@ -0,0 +1,51 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	natsio "github.com/nats-io/nats.go"
+	fn "knative.dev/func-go/nats"
+)
+
+// Main illustrates how scaffolding works to wrap a user's function.
+//
+// Run against a local JetStream-enabled server with a stream covering the
+// subject, for example:
+//
+//	nats-server -js &
+//	nats stream add EXAMPLE --subjects "example.>" --defaults
+//	NATS_SUBJECTS=example.subject go run ./cmd/fnats
+//	nats pub example.subject hello
+func main() {
+	// Instanced example (in scaffolding, 'New()' will be in module 'f')
+	if err := fn.Start(New()); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+
+	// Static example (in scaffolding 'Handle' will be in module f
+	// if err := fn.Start(fn.DefaultHandler{Handle}); err != nil {
+	// 	fmt.Fprintln(os.Stderr, err.Error())
+	// 	os.Exit(1)
+	// }
+}
+
+// Example Static NATS Handler implementation.
+func Handle(ctx context.Context, msg *natsio.Msg) error {
+	fmt.Printf("Static NATS handler invoked: %v %s\n", msg.Subject, msg.Data)
+	return nil
+}
+
+// MyFunction is an example instanced NATS function implementation.
+type MyFunction struct{}
+
+func New() *MyFunction {
+	return &MyFunction{}
+}
+
+func (f *MyFunction) Handle(ctx context.Context, msg *natsio.Msg) error {
+	fmt.Printf("Instanced NATS handler invoked: %v %s\n", msg.Subject, msg.Data)
+	return nil
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	goredis "github.com/redis/go-redis/v9"
+	fn "knative.dev/func-go/redis"
+)
+
+// Main illustrates how scaffolding works to wrap a user's function.
+//
+// Run against a local server, for example:
+//
+//	redis-server &
+//	REDIS_STREAM=example go run ./cmd/fredis
+//	redis-cli XADD example '*' data hello
+func main() {
+	// Instanced example (in scaffolding, 'New()' will be in module 'f')
+	if err := fn.Start(New()); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+		os.Exit(1)
+	}
+
+	// Static example (in scaffolding 'Handle' will be in module f
+	// if err := fn.Start(fn.DefaultHandler{Handle}); err != nil {
+	// 	fmt.Fprintln(os.Stderr, err.Error())
+	// 	os.Exit(1)
+	// }
+}
+
+// Example Static Redis Streams Handler implementation.
+func Handle(ctx context.Context, m goredis.XMessage) error {
+	fmt.Printf("Static Redis handler invoked: %v %v\n", m.ID, m.Values)
+	return nil
+}
+
+// MyFunction is an example instanced Redis Streams function implementation.
+type MyFunction struct{}
+
+func New() *MyFunction {
+	return &MyFunction{}
+}
+
+func (f *MyFunction) Handle(ctx context.Context, m goredis.XMessage) error {
+	fmt.Printf("Instanced Redis handler invoked: %v %v\n", m.ID, m.Values)
+	return nil
+}
@@ -0,0 +1,49 @@
+package grpc
+
+import (
+	"context"
+	"maps"
+
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc"
+)
+
+type configKey struct{}
+
+// ConfigFromContext returns the function's config, as passed to its Start
+// hook, from the context of a request.  This allows a function which does
+// not implement Start, such as a static function, to read its config.  It
+// returns nil if the context is not that of a request to the function.
+// The map returned is a copy, which the function may modify.
+func ConfigFromContext(ctx context.Context) map[string]string {
+	cfg, _ := ctx.Value(configKey{}).(map[string]string)
+	return maps.Clone(cfg)
+}
+
+// addConfig is a unary interceptor which makes the config available to
+// each request using ConfigFromContext, and a logger which includes the
+// method invoked using LoggerFromContext.
+func (s *Service) addConfig(ctx context.Context, req any, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (any, error) {
+	ctx = context.WithValue(ctx, configKey{}, s.cfg)
+	ctx = log.With().Str("method", info.FullMethod).Logger().WithContext(ctx)
+	return handler(ctx, req)
+}
+
+// addStreamConfig is a stream interceptor which makes the config available
+// to each stream using ConfigFromContext, and a logger which includes the
+// method invoked using LoggerFromContext.
+func (s *Service) addStreamConfig(srv any, ss grpc.ServerStream, info *grpc.StreamServerInfo, handler grpc.StreamHandler) error {
+	ctx := context.WithValue(ss.Context(), configKey{}, s.cfg)
+	ctx = log.With().Str("method", info.FullMethod).Logger().WithContext(ctx)
+	return handler(srv, &configStream{ServerStream: ss, ctx: ctx})
+}
+
+// configStream is a stream whose context bears the config.
+type configStream struct {
+	grpc.ServerStream
+	ctx context.Context
+}
+
+func (s *configStream) Context() context.Context {
+	return s.ctx
+}
@@ -0,0 +1,65 @@
+package grpc
+
+import (
+	"context"
+
+	"google.golang.org/grpc"
+)
+
+// Handler is a function instance which can handle a unary gRPC request
+// whose payload is opaque bytes.
+//
+// Such functions are exposed as the method Handle of the gRPC service
+// function.Function, which accepts and returns a google.protobuf.BytesValue.
+type Handler interface {
+	// Handle a request.
+	Handle(context.Context, []byte) ([]byte, error)
+}
+
+type HandleFunc func(context.Context, []byte) ([]byte, error)
+
+// Registrar is a function instance which implements one or more gRPC
+// services of its own (typically generated from protobuf definitions), which
+// it registers with the service's gRPC server.
+//
+// A function must implement either Handler or Registrar.
+type Registrar interface {
+	// Register the function's gRPC services.
+	Register(grpc.ServiceRegistrar)
+}
+
+// Starter is an instance which has defined the Start hook
+type Starter interface {
+	// Start instance event hook.  Its context is canceled should the
+	// service stop while it is in progress, in which case Stop is invoked
+	// only once it has returned.
+	Start(context.Context, map[string]string) error
+}
+
+// Stopper is an instance which has defined the  Stop hook
+type Stopper interface {
+	// Stop instance event hook.
+	Stop(context.Context) error
+}
+
+// ReadinessReporter is an instance which reports its readiness.
+type ReadinessReporter interface {
+	// Ready to be invoked or not.
+	Ready(context.Context) (bool, error)
+}
+
+// LivenessReporter is an instance which reports it is alive.
+type LivenessReporter interface {
+	// Alive allows the instance to report it's liveness status.
+	Alive(context.Context) (bool, error)
+}
+
+// DefaultHandler is used for simple static function implementations which
+// need only define a single exported function named Handle of type HandleFunc.
+type DefaultHandler struct {
+	Handler HandleFunc
+}
+
+func (f DefaultHandler) Handle(ctx context.Context, req []byte) ([]byte, error) {
+	return f.Handler(ctx, req)
+}
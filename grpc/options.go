@@ -0,0 +1,32 @@
+package grpc
+
+import "google.golang.org/grpc"
+
+// Option configures a Service.
+type Option func(*Service)
+
+// WithServerOptions configures the underlying gRPC server, for example to
+// enable TLS or to add interceptors.
+func WithServerOptions(opts ...grpc.ServerOption) Option {
+	return func(s *Service) {
+		s.serverOptions = append(s.serverOptions, opts...)
+	}
+}
+
+// WithReadyAfterStart reports the function as not ready (NOT_SERVING) until
+// its Start hook has returned successfully.
+func WithReadyAfterStart() Option {
+	return func(s *Service) {
+		s.readyAfterStart = true
+	}
+}
+
+// WithSynchronousStart invokes the function's Start hook before the service
+// begins listening, such that an error initializing the function is returned
+// from Start without the service ever accepting traffic.  By default the
+// Start hook is invoked asynchronously once the service is listening.
+func WithSynchronousStart() Option {
+	return func(s *Service) {
+		s.synchronousStart = true
+	}
+}
@@ -0,0 +1,439 @@
+// Package grpc implements a Functions gRPC middleware for use by
+// scaffolding which exposes a function as a network service which handles
+// gRPC requests.
+package grpc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+
+	"knative.dev/func-go/internal/config"
+	"knative.dev/func-go/internal/shutdown"
+	"knative.dev/func-go/internal/signals"
+)
+
+const (
+	DefaultLogLevel      = LogDebug
+	DefaultLogFormat     = LogJSON
+	DefaultListenAddress = "127.0.0.1:8080"
+)
+
+const (
+	ServerShutdownTimeout = 30 * time.Second
+	InstanceStopTimeout   = 30 * time.Second
+)
+
+// Health check service names.
+// The readiness of the function is reported for both ReadinessService and
+// the empty service name (the overall health of the server).
+const (
+	ReadinessService = "readiness"
+	LivenessService  = "liveness"
+)
+
+// ErrNoHandler is returned when starting a function which implements
+// neither Handler nor Registrar.
+var ErrNoHandler = errors.New("function must implement either Handler or Registrar")
+
+// Start an intance using a new Service
+// Note that this accepts ANY because a function may implement either
+// Handler or Registrar.
+func Start(f any) error {
+	log.Debug().Msg("func runtime creating function instance")
+	return New(f).Start(context.Background())
+}
+
+// Service exposes a Function Instance as a gRPC service.
+type Service struct {
+	*grpc.Server
+	listener net.Listener
+	stop     chan error
+	f        any
+
+	signalHandlers map[os.Signal]func()          // by WithSignalHandler
+	shutdownHooks  []func(context.Context) error // by WithShutdownHook
+
+	serverOptions []grpc.ServerOption
+	cfg           map[string]string // with which the instance was started
+
+	started          chan struct{}
+	startCancel      context.CancelFunc // of an asynchronous Start hook
+	startDone        chan struct{}      // closed when it returns
+	readyAfterStart  bool
+	synchronousStart bool
+}
+
+// New Service which serves the given instance.
+func New(f any, options ...Option) *Service {
+	svc := &Service{
+		f:       f,
+		stop:    make(chan error),
+		started: make(chan struct{}),
+	}
+	for _, o := range options {
+		o(svc)
+	}
+
+	svc.Server = grpc.NewServer(append([]grpc.ServerOption{
+		grpc.ChainUnaryInterceptor(svc.addConfig),
+		grpc.ChainStreamInterceptor(svc.addStreamConfig),
+	}, svc.serverOptions...)...)
+	healthpb.RegisterHealthServer(svc.Server, &healthServer{s: svc})
+	if r, ok := f.(Registrar); ok {
+		r.Register(svc.Server)
+	}
+	if _, ok := f.(Handler); ok {
+		svc.RegisterService(&functionServiceDesc, f)
+	}
+
+	// Print some helpful information about which interfaces the function
+	// is correctly implementing
+	logImplements(f)
+
+	return svc
+}
+
+// log which interfaces the function implements.
+// This could be more verbose for new users:
+func logImplements(f any) {
+	for _, i := range implemented(f) {
+		log.Info().Msg("Function implements " + i)
+	}
+}
+
+// implemented returns the names of the interfaces which the function
+// implements.
+func implemented(f any) (ii []string) {
+	if _, ok := f.(Handler); ok {
+		ii = append(ii, "Handle")
+	}
+	if _, ok := f.(Registrar); ok {
+		ii = append(ii, "Register")
+	}
+	if _, ok := f.(Starter); ok {
+		ii = append(ii, "Start")
+	}
+	if _, ok := f.(Stopper); ok {
+		ii = append(ii, "Stop")
+	}
+	if _, ok := f.(ReadinessReporter); ok {
+		ii = append(ii, "Ready")
+	}
+	if _, ok := f.(LivenessReporter); ok {
+		ii = append(ii, "Alive")
+	}
+	return
+}
+
+// Start
+// Will stop when the context is canceled, a runtime error is encountered,
+// or an os interrupt or kill signal is received.
+// By default it listens on the default address DefaultListenAddress.
+// This can be modified using the environment variable LISTEN_ADDRESS,
+// which may also be a Unix domain socket such as unix:///tmp/function.sock.
+func (s *Service) Start(ctx context.Context) (err error) {
+	_, isHandler := s.f.(Handler)
+	_, isRegistrar := s.f.(Registrar)
+	if !isHandler && !isRegistrar {
+		return ErrNoHandler
+	}
+
+	addr := config.ListenAddress(DefaultListenAddress)
+	log.Debug().Str("address", addr).Msg("function starting")
+
+	// Synchronous Start
+	// Optionally starts the function instance before listening, such that
+	// initialization errors are returned without ever accepting traffic.
+	if s.synchronousStart {
+		if err = s.startInstance(ctx); err != nil {
+			return
+		}
+	}
+
+	// Listen
+	if s.listener, err = listen(addr); err != nil {
+		return
+	}
+	s.logSummary()
+
+	// Start
+	// Starts the function instance in a separate routine, sending any
+	// runtime errors on s.stop.
+	if !s.synchronousStart {
+		if err = s.startInstance(ctx); err != nil {
+			return
+		}
+	}
+
+	// Wait for signals
+	// Interrupts and Kill signals
+	// sending a message on the s.stop channel if either are received.
+	unregister := signals.Handle(s.stop, s.signalHandlers)
+	defer unregister()
+
+	// Listen and serve
+	go func() {
+		if err := s.Serve(s.listener); err != nil {
+			log.Error().Err(err).Msg("grpc server exited with unexpected error")
+			s.stop <- err
+		}
+	}()
+
+	log.Debug().Msg("waiting for stop signals or errors")
+	// Wait for either a context cancellation or a signal on the stop channel.
+	select {
+	case err = <-s.stop:
+		if err != nil {
+			log.Error().Err(err).Msg("function error")
+		}
+	case <-ctx.Done():
+		log.Debug().Msg("function canceled")
+	}
+	return s.shutdown(err)
+}
+
+// Addr returns the address upon which the service is listening if started;
+// nil otherwise.
+func (s *Service) Addr() net.Addr {
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
+// functionServiceDesc describes the generic gRPC service function.Function
+// which exposes a Handler.  Its single unary method Handle accepts and
+// returns a google.protobuf.BytesValue, such that it can be invoked by any
+// gRPC client without generated code specific to the function.
+var functionServiceDesc = grpc.ServiceDesc{
+	ServiceName: "function.Function",
+	HandlerType: (*Handler)(nil),
+	Methods: []grpc.MethodDesc{{
+		MethodName: "Handle",
+		Handler:    handle,
+	}},
+	Streams:  []grpc.StreamDesc{},
+	Metadata: "function.proto",
+}
+
+// handle a unary request to function.Function/Handle.
+func handle(srv any, ctx context.Context, dec func(any) error, interceptor grpc.UnaryServerInterceptor) (any, error) {
+	in := new(wrapperspb.BytesValue)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	h := func(ctx context.Context, req any) (any, error) {
+		out, err := srv.(Handler).Handle(ctx, req.(*wrapperspb.BytesValue).GetValue())
+		if err != nil {
+			return nil, err
+		}
+		return wrapperspb.Bytes(out), nil
+	}
+	if interceptor == nil {
+		return h(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: "/function.Function/Handle",
+	}
+	return interceptor(ctx, in, info, h)
+}
+
+// healthServer implements the gRPC health checking protocol by delegating
+// to the function's ReadinessReporter and LivenessReporter.
+type healthServer struct {
+	healthpb.UnimplementedHealthServer
+	s *Service
+}
+
+// Check the readiness (service "" or ReadinessService) or liveness
+// (service LivenessService) of the function.
+func (h *healthServer) Check(ctx context.Context, req *healthpb.HealthCheckRequest) (*healthpb.HealthCheckResponse, error) {
+	var (
+		ok  bool
+		err error
+	)
+	switch req.GetService() {
+	case "", ReadinessService:
+		ok, err = h.s.Ready(ctx)
+	case LivenessService:
+		ok, err = h.s.Alive(ctx)
+	default:
+		return nil, status.Errorf(codes.NotFound, "unknown service %q", req.GetService())
+	}
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if !ok {
+		return &healthpb.HealthCheckResponse{Status: healthpb.HealthCheckResponse_NOT_SERVING}, nil
+	}
+	return &healthpb.HealthCheckResponse{Status: healthpb.HealthCheckResponse_SERVING}, nil
+}
+
+// Ready reports the readiness of the function.
+func (s *Service) Ready(ctx context.Context) (bool, error) {
+	if s.readyAfterStart && !s.isStarted() {
+		log.Debug().Msg("function not yet started")
+		return false, nil
+	}
+	if i, ok := s.f.(ReadinessReporter); ok {
+		ready, err := i.Ready(ctx)
+		if err != nil {
+			log.Debug().Err(err).Msg("error checking readiness")
+			return false, fmt.Errorf("error checking readiness: %w", err)
+		}
+		if !ready {
+			log.Debug().Msg("function not yet ready")
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// Alive reports the liveness of the function.
+func (s *Service) Alive(ctx context.Context) (bool, error) {
+	if i, ok := s.f.(LivenessReporter); ok {
+		alive, err := i.Alive(ctx)
+		if err != nil {
+			log.Err(err).Msg("error checking liveness")
+			return false, fmt.Errorf("error checking liveness: %w", err)
+		}
+		if !alive {
+			log.Debug().Msg("function not alive")
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+func (s *Service) startInstance(ctx context.Context) (err error) {
+	if s.cfg, err = config.New(); err != nil {
+		return
+	}
+	if i, ok := s.f.(Starter); ok {
+		cfg := s.cfg
+		if s.synchronousStart {
+			if err := i.Start(ctx, cfg); err != nil {
+				return err
+			}
+			close(s.started)
+			return nil
+		}
+		// Canceled should the service stop while Start is in progress.
+		startCtx, cancel := context.WithCancel(ctx)
+		s.startCancel, s.startDone = cancel, make(chan struct{})
+		go func() {
+			defer close(s.startDone)
+			if err := i.Start(startCtx, cfg); err != nil {
+				select {
+				case s.stop <- err:
+				case <-startCtx.Done(): // already stopping
+				}
+				return
+			}
+			close(s.started)
+		}()
+	} else {
+		log.Debug().Msg("function does not implement Start. Skipping")
+		close(s.started)
+	}
+	return nil
+}
+
+// cancelStart cancels the context passed to the function's Start hook if it
+// is still in progress, such as when a signal is received during a slow
+// initialization.
+func (s *Service) cancelStart() {
+	if s.startDone == nil {
+		return
+	}
+	select {
+	case <-s.startDone:
+	default:
+		log.Debug().Msg("canceling function start")
+		s.startCancel()
+	}
+}
+
+// waitStart waits up to InstanceStopTimeout for the function's Start hook to
+// return, such that Stop is never invoked while Start is in progress.
+func (s *Service) waitStart() {
+	if s.startDone == nil {
+		return
+	}
+	select {
+	case <-s.startDone:
+	case <-time.After(InstanceStopTimeout):
+		log.Warn().Msg("timed out waiting for function start to return")
+	}
+}
+
+// isStarted returns true if the function instance has successfully started.
+func (s *Service) isStarted() bool {
+	select {
+	case <-s.started:
+		return true
+	default:
+		return false
+	}
+}
+
+// shutdown is invoked when the stop channel receives a message and attempts to
+// gracefully cease execution.
+// Passed in is the message received on the stop channel, wich is either an
+// error in the case of a runtime error, or nil in the case of a context
+// cancellation or sigint/sigkill.
+func (s *Service) shutdown(sourceErr error) (err error) {
+	log.Debug().Msg("function stopping")
+	var instanceErr error
+
+	// Cancel a Start hook still in progress, which is waited upon before
+	// the instance is stopped.
+	s.cancelStart()
+
+	// Start a graceful shutdown of the gRPC server, forcibly stopping it
+	// should in-flight requests not complete within the timeout.
+	stopped := make(chan struct{})
+	go func() {
+		s.GracefulStop()
+		close(stopped)
+	}()
+	select {
+	case <-stopped:
+	case <-time.After(ServerShutdownTimeout):
+		log.Warn().Msg("timed out waiting for requests to complete")
+		s.Server.Stop()
+	}
+	_ = s.listener.Close() // removes a Unix socket even if not yet served
+
+	s.waitStart()
+
+	//  Start a graceful shutdown of the Function instance
+	if i, ok := s.f.(Stopper); ok {
+		ctx, cancel := context.WithTimeout(context.Background(), InstanceStopTimeout)
+		defer cancel()
+		instanceErr = i.Stop(ctx)
+	}
+
+	hookErr := shutdown.RunHooks(s.shutdownHooks, InstanceStopTimeout)
+
+	return shutdown.Errors(instanceErr, sourceErr, hookErr)
+}
+
+// ShutdownError is returned by Start when more than one error occurs in
+// stopping the service.  Errors are ordered by precedence: that of the
+// function's Stop hook, followed by that which caused the service to stop,
+// followed by any of the runtime itself.  errors.Is and errors.As consider
+// each of them.
+type ShutdownError = shutdown.Error
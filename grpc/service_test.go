@@ -0,0 +1,209 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+	"knative.dev/func-go/grpc/mock"
+)
+
+// startService starts a Service for the given function on an OS-chosen port,
+// returning once the function's Start hook has been invoked.  The service is
+// stopped when the test completes.
+func startService(t *testing.T, f *mock.Function, options ...Option) *Service {
+	t.Helper()
+	t.Setenv("LISTEN_ADDRESS", "127.0.0.1:") // use an OS-chosen port
+
+	var (
+		ctx, cancel = context.WithCancel(context.Background())
+		startCh     = make(chan any, 1)
+		errCh       = make(chan error, 1)
+		onStart     = f.OnStart
+	)
+	f.OnStart = func(ctx context.Context, cfg map[string]string) error {
+		select {
+		case startCh <- true:
+		default:
+		}
+		if onStart != nil {
+			return onStart(ctx, cfg)
+		}
+		return nil
+	}
+
+	service := New(f, options...)
+	go func() {
+		errCh <- service.Start(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		select {
+		case <-errCh:
+		case <-time.After(time.Second):
+			t.Error("service failed to stop")
+		}
+	})
+
+	select {
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("function failed to start")
+	case err := <-errCh:
+		t.Fatal(err)
+	case <-startCh:
+	}
+	return service
+}
+
+// dial the given service, returning a client connection which is closed
+// when the test completes.
+func dial(t *testing.T, s *Service) *grpc.ClientConn {
+	t.Helper()
+	conn, err := grpc.NewClient(s.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	return conn
+}
+
+// TestStart_Invoked ensures that the Start method of a function is invoked
+// if it is implemented by the function instance.
+func TestStart_Invoked(t *testing.T) {
+	_ = startService(t, &mock.Function{})
+}
+
+// TestStart_NoHandler ensures that a function which implements neither
+// Handler nor Registrar fails to start with a descriptive error.
+func TestStart_NoHandler(t *testing.T) {
+	t.Setenv("LISTEN_ADDRESS", "127.0.0.1:") // use an OS-chosen port
+	if err := New(struct{}{}).Start(context.Background()); !errors.Is(err, ErrNoHandler) {
+		t.Fatalf("expected ErrNoHandler, got %v", err)
+	}
+}
+
+// TestStop_Invoked ensures the Stop method of a function is invoked on context
+// cancellation if it is implemented by the function instance.
+func TestStop_Invoked(t *testing.T) {
+	t.Setenv("LISTEN_ADDRESS", "127.0.0.1:") // use an OS-chosen port
+	var (
+		ctx, cancel = context.WithCancel(context.Background())
+		startCh     = make(chan any)
+		stopCh      = make(chan any)
+		errCh       = make(chan error)
+		onStart     = func(_ context.Context, _ map[string]string) error {
+			startCh <- true
+			return nil
+		}
+		onStop = func(_ context.Context) error {
+			stopCh <- true
+			return nil
+		}
+	)
+
+	f := &mock.Function{OnStart: onStart, OnStop: onStop}
+
+	go func() {
+		if err := New(f).Start(ctx); err != nil {
+			errCh <- err
+		}
+	}()
+
+	select {
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("function failed to notify of start")
+	case err := <-errCh:
+		t.Fatal(err)
+	case <-startCh:
+	}
+
+	cancel()
+
+	select {
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("function failed to notify of stop")
+	case err := <-errCh:
+		t.Fatal(err)
+	case <-stopCh:
+	}
+}
+
+// TestHandle_Invoked ensures the Handle method of a function is invoked on
+// a request to function.Function/Handle.
+func TestHandle_Invoked(t *testing.T) {
+	f := &mock.Function{OnHandle: func(_ context.Context, req []byte) ([]byte, error) {
+		return append([]byte("echo: "), req...), nil
+	}}
+	service := startService(t, f)
+
+	out := new(wrapperspb.BytesValue)
+	err := dial(t, service).Invoke(context.Background(), "/function.Function/Handle", wrapperspb.Bytes([]byte("hello")), out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out.GetValue()) != "echo: hello" {
+		t.Fatalf("unexpected response: %q", out.GetValue())
+	}
+}
+
+// TestHealth ensures readiness and liveness are reported using the gRPC
+// health checking protocol.
+func TestHealth(t *testing.T) {
+	service := startService(t, &mock.Function{})
+	client := healthpb.NewHealthClient(dial(t, service))
+
+	for _, name := range []string{"", ReadinessService, LivenessService} {
+		resp, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{Service: name})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.GetStatus() != healthpb.HealthCheckResponse_SERVING {
+			t.Fatalf("service %q: unexpected status %v", name, resp.GetStatus())
+		}
+	}
+}
+
+// TestHealth_ReadyAfterStart ensures that when enabled, the function is
+// reported as not serving until its Start hook returns.
+func TestHealth_ReadyAfterStart(t *testing.T) {
+	release := make(chan any)
+	f := &mock.Function{OnStart: func(context.Context, map[string]string) error {
+		<-release
+		return nil
+	}}
+	service := startService(t, f, WithReadyAfterStart())
+	client := healthpb.NewHealthClient(dial(t, service))
+
+	resp, err := client.Check(context.Background(), &healthpb.HealthCheckRequest{Service: ReadinessService})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.GetStatus() != healthpb.HealthCheckResponse_NOT_SERVING {
+		t.Fatalf("unexpected status before Start returned: %v", resp.GetStatus())
+	}
+	close(release)
+}
+
+// TestConfigFromContext ensures that the function's config is available to
+// its handler using ConfigFromContext.
+func TestConfigFromContext(t *testing.T) {
+	t.Setenv("TEST_CONFIG_VALUE", "example")
+	f := &mock.Function{OnHandle: func(ctx context.Context, _ []byte) ([]byte, error) {
+		return []byte(ConfigFromContext(ctx)["TEST_CONFIG_VALUE"]), nil
+	}}
+	service := startService(t, f)
+
+	out := new(wrapperspb.BytesValue)
+	err := dial(t, service).Invoke(context.Background(), "/function.Function/Handle", wrapperspb.Bytes(nil), out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(out.GetValue()) != "example" {
+		t.Fatalf("unexpected config value: %q", out.GetValue())
+	}
+}
@@ -0,0 +1,20 @@
+package grpc
+
+import "os"
+
+// WithSignalHandler invokes fn whenever the process receives the given
+// signal, such as to reload configuration upon SIGHUP, or to toggle
+// profiling upon SIGUSR1.  Handlers are invoked in turn on a single
+// goroutine, shared by all services in the process, so should not block.
+//
+// By default SIGINT and SIGTERM stop the service, and all other signals are
+// ignored.  Registering a handler for SIGINT or SIGTERM replaces stopping
+// the service upon that signal.
+func WithSignalHandler(sig os.Signal, fn func()) Option {
+	return func(s *Service) {
+		if s.signalHandlers == nil {
+			s.signalHandlers = map[os.Signal]func(){}
+		}
+		s.signalHandlers[sig] = fn
+	}
+}
@@ -0,0 +1,18 @@
+package grpc
+
+import (
+	"fmt"
+
+	"github.com/rs/zerolog/log"
+)
+
+// logSummary logs the effective configuration of the service once
+// listening, such that misconfiguration (for example of the environment)
+// is apparent from a single log line.
+func (s *Service) logSummary() {
+	log.Info().
+		Str("address", s.listener.Addr().String()).
+		Str("handler", fmt.Sprintf("%T", s.f)).
+		Strs("implements", implemented(s.f)).
+		Msg("function runtime configured")
+}
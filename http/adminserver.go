@@ -0,0 +1,67 @@
+package http
+
+import (
+	"net"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// WithAdminAddress serves the health (and admin) endpoints on a separate
+// listener at the given address, such as "127.0.0.1:9090", rather than
+// alongside the function.  The function's listener then serves only the
+// function, such that probes are not reachable via its ingress.
+//
+// If not set using this option, the environment variable
+// FUNC_ADMIN_ADDRESS is used.  If neither is set, the endpoints are served
+// on the function's listener.
+func WithAdminAddress(addr string) Option {
+	return func(s *Service) {
+		s.adminAddress = addr
+	}
+}
+
+// newAdminServer returns the server for the health and admin endpoints when
+// they are to be served on a separate listener, or nil.
+func (s *Service) newAdminServer() *http.Server {
+	if s.adminAddress == "" {
+		s.adminAddress = os.Getenv("FUNC_ADMIN_ADDRESS")
+	}
+	if s.adminAddress == "" {
+		return nil
+	}
+	return &http.Server{
+		ReadTimeout:       30 * time.Second,
+		WriteTimeout:      30 * time.Second,
+		IdleTimeout:       30 * time.Second,
+		MaxHeaderBytes:    1 << 20,
+		ReadHeaderTimeout: 2 * time.Second,
+	}
+}
+
+// serveAdmin listens on the admin address and serves the admin server,
+// sending any unexpected error on s.stop.
+func (s *Service) serveAdmin() (err error) {
+	if s.adminListener, err = listen(s.adminAddress); err != nil {
+		return
+	}
+	log.Debug().Str("address", s.adminListener.Addr().String()).Msg("admin listening")
+	go func() {
+		if err := s.adminServer.Serve(s.adminListener); err != http.ErrServerClosed {
+			log.Error().Err(err).Msg("admin server exited with unexpected error")
+			s.stop <- err
+		}
+	}()
+	return
+}
+
+// AdminAddr returns the address upon which the health and admin endpoints
+// are served when served separately (see WithAdminAddress), or nil.
+func (s *Service) AdminAddr() net.Addr {
+	if s.adminListener == nil {
+		return nil
+	}
+	return s.adminListener.Addr()
+}
@@ -0,0 +1,299 @@
+package http
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/rs/zerolog/log"
+	"golang.org/x/sync/singleflight"
+)
+
+const (
+	// jwksTimeout limits fetching the JWKS of WithJWTValidation.
+	jwksTimeout = 10 * time.Second
+
+	// jwksRefreshInterval is the minimum time between fetches of the JWKS,
+	// which is refetched when a token is signed by an unknown key.
+	jwksRefreshInterval = time.Minute
+)
+
+// WithBearerToken requires requests to the function to bear the given
+// shared secret in their Authorization header ("Bearer <token>"), rejecting
+// others with 401 Unauthorized before the function is invoked.  The health
+// (and admin) endpoints are not affected.
+//
+// If used together with WithJWTValidation, a request bearing either the
+// token or a valid JWT is accepted.
+func WithBearerToken(token string) Option {
+	return func(s *Service) {
+		s.auth.token = token
+	}
+}
+
+// WithJWTValidation requires requests to the function to bear a JWT in their
+// Authorization header ("Bearer <jwt>") which is signed by a key of the
+// JSON Web Key Set (JWKS) at the given URL, is issued by the given issuer
+// for the given audience, and has not expired.  Others are rejected with 401
+// Unauthorized before the function is invoked.  The health (and admin)
+// endpoints are not affected.  The audience, which identifies the function
+// such that tokens the issuer grants for other services are rejected, is
+// required.
+//
+// Tokens signed using RSA, RSA-PSS or ECDSA are accepted.  The JWKS is
+// fetched when first needed, and refetched when a token is signed by a key
+// it does not contain.  The claims of a valid token are available to the
+// function using JWTClaims.
+func WithJWTValidation(jwksURL, issuer, audience string) Option {
+	return func(s *Service) {
+		if audience == "" {
+			s.invalidOption("JWT validation requires an audience")
+			return
+		}
+		s.auth.jwks = &jwks{url: jwksURL, client: &http.Client{Timeout: jwksTimeout}}
+		s.auth.issuer = issuer
+		s.auth.audience = audience
+	}
+}
+
+type jwtClaimsKey struct{}
+
+// JWTClaims returns the claims of the JWT with which the request with the
+// given context was authenticated, or nil if none (see WithJWTValidation).
+func JWTClaims(ctx context.Context) map[string]any {
+	claims, _ := ctx.Value(jwtClaimsKey{}).(jwt.MapClaims)
+	return claims
+}
+
+// authenticator authenticates requests using a shared secret and/or JWTs.
+type authenticator struct {
+	token    string
+	jwks     *jwks
+	issuer   string
+	audience string
+}
+
+// enabled returns true if requests are to be authenticated.
+func (a *authenticator) enabled() bool {
+	return a.token != "" || a.jwks != nil
+}
+
+// authenticate wraps the handler such that requests which are not
+// authenticated are rejected.
+func (s *Service) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if !ok || token == "" {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			http.Error(w, "missing bearer token", http.StatusUnauthorized)
+			return
+		}
+		a := &s.auth
+		if a.token != "" && subtle.ConstantTimeCompare([]byte(token), []byte(a.token)) == 1 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		if a.jwks != nil {
+			claims, err := a.validateJWT(token)
+			if err == nil {
+				ctx := context.WithValue(r.Context(), jwtClaimsKey{}, claims)
+				next.ServeHTTP(w, r.WithContext(ctx))
+				return
+			}
+			requestLog(r).Debug().Err(err).Msg("invalid JWT")
+		}
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		http.Error(w, "invalid bearer token", http.StatusUnauthorized)
+	})
+}
+
+// validateJWT returns the claims of the token if it is valid.
+func (a *authenticator) validateJWT(token string) (jwt.MapClaims, error) {
+	claims := jwt.MapClaims{}
+	_, err := jwt.ParseWithClaims(token, claims,
+		func(t *jwt.Token) (any, error) {
+			kid, _ := t.Header["kid"].(string)
+			return a.jwks.key(kid)
+		},
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512"}),
+		jwt.WithIssuer(a.issuer),
+		jwt.WithAudience(a.audience),
+		jwt.WithExpirationRequired(),
+	)
+	return claims, err
+}
+
+// jwks is a JSON Web Key Set fetched from a URL, whose keys are cached.
+type jwks struct {
+	url    string
+	client *http.Client
+	group  singleflight.Group // of fetches, such that they are shared
+
+	mu      sync.Mutex
+	keys    map[string]any // public keys by ID
+	fetched time.Time
+}
+
+// key returns the public key with the given ID, fetching the set if not
+// yet fetched or if the key is unknown and the set was not fetched
+// recently.  The set is fetched without holding the lock, such that a slow
+// fetch does not delay requests bearing tokens signed by known keys, and
+// concurrent requests share a single fetch.
+func (j *jwks) key(kid string) (any, error) {
+	j.mu.Lock()
+	k, stale := j.lookup(kid), time.Since(j.fetched) >= jwksRefreshInterval
+	j.mu.Unlock()
+	if k != nil {
+		return k, nil
+	}
+	if !stale {
+		return nil, fmt.Errorf("unknown key %q", kid)
+	}
+	if _, err, _ := j.group.Do("", j.refresh); err != nil {
+		return nil, err
+	}
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	if k := j.lookup(kid); k != nil {
+		return k, nil
+	}
+	return nil, fmt.Errorf("unknown key %q", kid)
+}
+
+// refresh the cached keys unless they were fetched recently, such as by a
+// fetch which completed since the caller found them stale.
+func (j *jwks) refresh() (any, error) {
+	j.mu.Lock()
+	stale := time.Since(j.fetched) >= jwksRefreshInterval
+	j.mu.Unlock()
+	if !stale {
+		return nil, nil
+	}
+	keys, err := j.fetch()
+	j.mu.Lock()
+	defer j.mu.Unlock()
+	j.fetched = time.Now() // including failures, such that the URL is not hammered
+	if err != nil {
+		log.Error().Err(err).Str("url", j.url).Msg("error fetching JWKS")
+		return nil, err
+	}
+	j.keys = keys
+	return nil, nil
+}
+
+// lookup the key with the given ID, or the only key of the set if the token
+// does not identify one.
+func (j *jwks) lookup(kid string) any {
+	if kid == "" && len(j.keys) == 1 {
+		for _, k := range j.keys {
+			return k
+		}
+	}
+	return j.keys[kid]
+}
+
+// fetch the key set, returning its RSA and EC public keys by ID.
+func (j *jwks) fetch() (map[string]any, error) {
+	resp, err := j.client.Get(j.url)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching JWKS: %v", resp.Status)
+	}
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return nil, fmt.Errorf("decoding JWKS: %w", err)
+	}
+	keys := make(map[string]any, len(set.Keys))
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		key, err := k.publicKey()
+		if err != nil {
+			log.Warn().Err(err).Str("kid", k.Kid).Msg("ignoring JWKS key")
+			continue
+		}
+		keys[k.Kid] = key
+	}
+	return keys, nil
+}
+
+// jwk is a JSON Web Key (RFC 7517) of type RSA or EC.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+// publicKey returns the key as an *rsa.PublicKey or *ecdsa.PublicKey.
+func (k jwk) publicKey() (any, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := base64URLInt(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("invalid modulus: %w", err)
+		}
+		e, err := base64URLInt(k.E)
+		if err != nil || !e.IsInt64() || e.Int64() > 1<<31-1 {
+			return nil, errors.New("invalid exponent")
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve %q", k.Crv)
+		}
+		x, err := base64URLInt(k.X)
+		if err != nil {
+			return nil, fmt.Errorf("invalid x: %w", err)
+		}
+		y, err := base64URLInt(k.Y)
+		if err != nil {
+			return nil, fmt.Errorf("invalid y: %w", err)
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, nil
+	default:
+		return nil, fmt.Errorf("unsupported key type %q", k.Kty)
+	}
+}
+
+// base64URLInt decodes an unpadded base64url big-endian integer.
+func base64URLInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) == 0 {
+		return nil, errors.New("empty value")
+	}
+	return new(big.Int).SetBytes(b), nil
+}
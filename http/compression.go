@@ -0,0 +1,316 @@
+package http
+
+import (
+	"bufio"
+	"compress/gzip"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+)
+
+// DefaultMaxDecompressedSize is the maximum size in bytes to which a
+// gzip-encoded request body is decoded unless set using
+// WithMaxDecompressedSize.
+const DefaultMaxDecompressedSize = 32 << 20
+
+// WithCompression gzip-encodes the body of responses when the request
+// indicates it accepts gzip encoding, and decodes gzip-encoded request
+// bodies before they reach the function.  Responses whose content type is
+// already compressed (such as images, video and archives), those already
+// encoded by the function, and those to upgrade requests are not encoded.
+//
+// A request body is decoded to at most DefaultMaxDecompressedSize bytes
+// (see WithMaxDecompressedSize), such that a small body can not expand
+// without bound.  Reading beyond that fails with an *http.MaxBytesError,
+// and the request is responded to with a 413 unless the function has
+// already begun its response.
+func WithCompression() Option {
+	return func(s *Service) {
+		s.compress = true
+		if s.maxDecompressedSize == 0 {
+			s.maxDecompressedSize = DefaultMaxDecompressedSize
+		}
+	}
+}
+
+// WithMaxDecompressedSize limits the size in bytes to which a gzip-encoded
+// request body is decoded by WithCompression, in place of
+// DefaultMaxDecompressedSize.  Start fails if n is not positive.
+func WithMaxDecompressedSize(n int64) Option {
+	return func(s *Service) {
+		if n <= 0 {
+			s.invalidOption("invalid maximum decompressed size %v: must be positive", n)
+			return
+		}
+		s.maxDecompressedSize = n
+	}
+}
+
+var gzipWriters = sync.Pool{
+	New: func() any { return gzip.NewWriter(nil) },
+}
+
+// compress returns middleware which decodes gzip-encoded requests to at
+// most max bytes, and gzip-encodes responses when accepted by the client.
+func compress(max int64) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if strings.EqualFold(r.Header.Get("Content-Encoding"), "gzip") {
+				zr, err := gzip.NewReader(r.Body)
+				if err != nil {
+					http.Error(w, "invalid gzip request body", http.StatusBadRequest)
+					return
+				}
+				defer zr.Close()
+				lw := &limitedBodyResponseWriter{ResponseWriter: w}
+				r.Body = struct {
+					io.Reader
+					io.Closer
+				}{&limitedBodyReader{r: http.MaxBytesReader(w, zr, max), w: lw}, r.Body}
+				w = lw
+				r.Header.Del("Content-Encoding")
+				r.Header.Del("Content-Length")
+				r.ContentLength = -1
+			}
+			if !acceptsGzip(r) || isUpgrade(r) {
+				next.ServeHTTP(w, r)
+				return
+			}
+			gw := &gzipResponseWriter{ResponseWriter: w}
+			defer gw.close()
+			next.ServeHTTP(gw, r)
+		})
+	}
+}
+
+// limitedBodyReader is a decoded request body limited by an
+// http.MaxBytesReader, which records on the response writer when the limit
+// is exceeded.
+type limitedBodyReader struct {
+	r io.Reader
+	w *limitedBodyResponseWriter
+}
+
+func (r *limitedBodyReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	var maxErr *http.MaxBytesError
+	if errors.As(err, &maxErr) && r.w.exceeded == nil {
+		r.w.exceeded = maxErr
+	}
+	return n, err
+}
+
+// limitedBodyResponseWriter responds with a 413 in place of the function's
+// response once the decoded request body exceeds its limit, unless the
+// function has already begun its response.  The function's subsequent
+// writes fail with the *http.MaxBytesError.
+type limitedBodyResponseWriter struct {
+	http.ResponseWriter
+	exceeded    *http.MaxBytesError
+	wroteHeader bool
+	rejected    bool
+}
+
+func (w *limitedBodyResponseWriter) WriteHeader(code int) {
+	if w.reject() {
+		return
+	}
+	if code >= 200 {
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *limitedBodyResponseWriter) Write(b []byte) (int, error) {
+	if w.reject() {
+		return 0, w.exceeded
+	}
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush the response, such that wrapping does not prevent streaming.
+func (w *limitedBodyResponseWriter) Flush() {
+	if w.reject() {
+		return
+	}
+	w.wroteHeader = true
+	_ = http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Hijack the connection, such that wrapping does not prevent upgrades,
+// unless the request has already been rejected.
+func (w *limitedBodyResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if w.reject() {
+		return nil, nil, w.exceeded
+	}
+	w.wroteHeader = true
+	return http.NewResponseController(w.ResponseWriter).Hijack()
+}
+
+// Unwrap returns the wrapped ResponseWriter for use by
+// http.ResponseController.
+func (w *limitedBodyResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// reject responds with a 413 if the limit was exceeded before the response
+// began, returning true if the function's response is to be discarded.
+func (w *limitedBodyResponseWriter) reject() bool {
+	if w.rejected {
+		return true
+	}
+	if w.exceeded == nil || w.wroteHeader {
+		return false
+	}
+	w.rejected = true
+	http.Error(w.ResponseWriter, fmt.Sprintf("request body exceeds %v bytes", w.exceeded.Limit),
+		http.StatusRequestEntityTooLarge)
+	return true
+}
+
+// acceptsGzip returns true if the request's Accept-Encoding includes gzip.
+func acceptsGzip(r *http.Request) bool {
+	for _, v := range r.Header.Values("Accept-Encoding") {
+		for _, enc := range strings.Split(v, ",") {
+			enc, _, _ = strings.Cut(strings.TrimSpace(enc), ";")
+			if strings.EqualFold(enc, "gzip") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// isCompressed returns true if content of the given type is typically
+// already compressed, such that encoding it again would be wasted effort.
+func isCompressed(contentType string) bool {
+	mt, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	switch {
+	case mt == "image/svg+xml":
+		return false
+	case strings.HasPrefix(mt, "image/"), strings.HasPrefix(mt, "video/"), strings.HasPrefix(mt, "audio/"):
+		return true
+	}
+	switch mt {
+	case "application/gzip", "application/x-gzip", "application/zip", "application/zstd",
+		"application/x-bzip2", "application/x-xz", "application/x-7z-compressed",
+		"application/vnd.rar", "font/woff", "font/woff2":
+		return true
+	}
+	return false
+}
+
+// gzipResponseWriter defers writing the status code until the first write
+// of the body, at which point the response is marked as gzip-encoded.
+// Responses without a body are written unencoded.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	gz          *gzip.Writer
+	status      int
+	wroteHeader bool
+}
+
+func (w *gzipResponseWriter) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+}
+
+func (w *gzipResponseWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		if len(b) == 0 {
+			return 0, nil
+		}
+		if w.Header().Get("Content-Type") == "" {
+			// Sniffed here, as it would otherwise be of the encoded content.
+			w.Header().Set("Content-Type", http.DetectContentType(b))
+		}
+		w.writeHeader(w.shouldEncode())
+	}
+	if w.gz == nil {
+		return w.ResponseWriter.Write(b)
+	}
+	return w.gz.Write(b)
+}
+
+// Flush any encoded content to the client, such that streaming responses
+// are not held in the encoder.
+func (w *gzipResponseWriter) Flush() {
+	if !w.wroteHeader {
+		w.writeHeader(w.shouldEncode())
+	}
+	if w.gz != nil {
+		_ = w.gz.Flush()
+	}
+	_ = http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Hijack the connection, such that wrapping does not prevent upgrades.  Any
+// response already begun is first completed.
+func (w *gzipResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	if w.gz != nil {
+		_ = w.gz.Close()
+		gzipWriters.Put(w.gz)
+		w.gz = nil
+	}
+	conn, brw, err := http.NewResponseController(w.ResponseWriter).Hijack()
+	if err == nil {
+		w.wroteHeader = true // such that close writes nothing
+	}
+	return conn, brw, err
+}
+
+// Unwrap returns the underlying ResponseWriter, for use by
+// http.ResponseController.
+func (w *gzipResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// shouldEncode returns true if the response is not already encoded, nor of
+// an already compressed content type.
+func (w *gzipResponseWriter) shouldEncode() bool {
+	h := w.Header()
+	return h.Get("Content-Encoding") == "" && !isCompressed(h.Get("Content-Type"))
+}
+
+// writeHeader writes the deferred status code, first marking the response
+// as gzip-encoded if requested.
+func (w *gzipResponseWriter) writeHeader(encode bool) {
+	w.wroteHeader = true
+	if encode {
+		h := w.Header()
+		h.Del("Content-Length")
+		h.Set("Content-Encoding", "gzip")
+		h.Add("Vary", "Accept-Encoding")
+		w.gz = gzipWriters.Get().(*gzip.Writer)
+		w.gz.Reset(w.ResponseWriter)
+	}
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+}
+
+// close flushes any encoded content, or writes the deferred status code if
+// there was no body.
+func (w *gzipResponseWriter) close() {
+	if !w.wroteHeader {
+		if w.status != 0 {
+			w.writeHeader(false)
+		}
+		return
+	}
+	if w.gz != nil {
+		_ = w.gz.Close()
+		gzipWriters.Put(w.gz)
+	}
+}
@@ -0,0 +1,37 @@
+package http
+
+import "net/http"
+
+// WithMaxConcurrentRequests limits the number of requests being handled by
+// the function at once to n, such as when it is backed by a limited
+// resource like a database connection pool.  Requests received while n are
+// in-flight are rejected immediately with a 503 and a Retry-After header
+// rather than waiting.  Health endpoints are not limited.  Start fails if n
+// is not positive.
+//
+// Unlike WithRateLimit, which limits the rate at which requests are
+// accepted, this limits how many are in-flight regardless of rate.
+func WithMaxConcurrentRequests(n int) Option {
+	return func(s *Service) {
+		if n <= 0 {
+			s.invalidOption("invalid maximum concurrent requests %v: must be positive", n)
+			return
+		}
+		s.concurrencyLimit = make(chan struct{}, n)
+	}
+}
+
+// limitConcurrency wraps the handler such that no more than the configured
+// maximum number of requests are in-flight.
+func (s *Service) limitConcurrency(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case s.concurrencyLimit <- struct{}{}:
+			defer func() { <-s.concurrencyLimit }()
+			next.ServeHTTP(w, r)
+		default:
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "too many concurrent requests", http.StatusServiceUnavailable)
+		}
+	})
+}
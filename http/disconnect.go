@@ -0,0 +1,93 @@
+package http
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"net"
+	"net/http"
+)
+
+// detectDisconnects wraps the handler such that a request whose client
+// disconnected before its response was written is logged, distinguishing a
+// truncated response from an error of the function.  A client disconnects
+// if the context of its request was canceled (rather than timed out, see
+// WithRequestTimeout) or a write of the response failed.  Upgrade requests,
+// whose connections may be hijacked, are not inspected.
+func (s *Service) detectDisconnects(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isUpgrade(r) {
+			next.ServeHTTP(w, r)
+			return
+		}
+		dw := &disconnectResponseWriter{ResponseWriter: w}
+		next.ServeHTTP(dw, r)
+		if dw.hijacked || (!clientDisconnected(r) && dw.err == nil) {
+			return
+		}
+		requestLog(r).Warn().
+			AnErr("write_error", dw.err).
+			Bool("response_started", dw.status != 0).
+			Int("status", dw.status).
+			Int64("bytes_written", dw.written).
+			Msg("client disconnected before response completed")
+	})
+}
+
+// clientDisconnected returns true if the context of the request was
+// canceled, which the server does when its client disconnects.
+func clientDisconnected(r *http.Request) bool {
+	return errors.Is(r.Context().Err(), context.Canceled)
+}
+
+// disconnectResponseWriter is a ResponseWriter which records how much of
+// the response was written, and the first error writing it.
+type disconnectResponseWriter struct {
+	http.ResponseWriter
+	status   int
+	written  int64
+	err      error
+	hijacked bool // the connection is then the handler's to manage
+}
+
+func (w *disconnectResponseWriter) WriteHeader(code int) {
+	if w.status == 0 && code >= 200 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *disconnectResponseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.written += int64(n)
+	if err != nil && w.err == nil {
+		w.err = err
+	}
+	return n, err
+}
+
+// Flush the response, such that wrapping does not prevent streaming.
+func (w *disconnectResponseWriter) Flush() {
+	err := http.NewResponseController(w.ResponseWriter).Flush()
+	if err != nil && !errors.Is(err, http.ErrNotSupported) && w.err == nil {
+		w.err = err
+	}
+}
+
+// Hijack the connection, such that wrapping does not prevent upgrades.
+func (w *disconnectResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, brw, err := http.NewResponseController(w.ResponseWriter).Hijack()
+	if err == nil {
+		w.hijacked = true
+	}
+	return conn, brw, err
+}
+
+// Unwrap returns the wrapped ResponseWriter for use by
+// http.ResponseController.
+func (w *disconnectResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,141 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// HealthCheck checks a dependency of the function such as a database or
+// cache, returning an error if it is unhealthy.
+type HealthCheck func(context.Context) error
+
+// WithHealthCheck registers a named check of a dependency of the function.
+// All registered checks are run by the readiness endpoint, which responds
+// 503 with a JSON body listing the status of each check should any fail.
+// Checks are run in addition to the function's own Ready hook, if any.
+func WithHealthCheck(name string, check HealthCheck) Option {
+	return func(s *Service) {
+		if s.healthChecks == nil {
+			s.healthChecks = map[string]HealthCheck{}
+		}
+		s.healthChecks[name] = check
+	}
+}
+
+// checkResult is the status of a single health check.
+type checkResult struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// runHealthChecks runs each of the service's health checks concurrently,
+// returning their results by name and whether all passed.
+func (s *Service) runHealthChecks(ctx context.Context) (map[string]checkResult, bool) {
+	var (
+		mu      sync.Mutex
+		wg      sync.WaitGroup
+		results = make(map[string]checkResult, len(s.healthChecks))
+		ok      = true
+	)
+	for name, check := range s.healthChecks {
+		wg.Add(1)
+		go func(name string, check HealthCheck) {
+			defer wg.Done()
+			result := checkResult{Status: "ok"}
+			err := check(ctx)
+			if err != nil {
+				result = checkResult{Status: "error", Error: err.Error()}
+			}
+			mu.Lock()
+			defer mu.Unlock()
+			results[name] = result
+			ok = ok && err == nil
+		}(name, check)
+	}
+	wg.Wait()
+	return results, ok
+}
+
+// WithJSONHealth responds to the health endpoints with JSON of the form
+// {"status":"ok","checks":{...},"timestamp":"..."} rather than plain text.
+// The status is "ok", "unavailable" or "error" for a 2xx, 503 or other
+// code respectively, with any message in the field "message".
+func WithJSONHealth() Option {
+	return func(s *Service) {
+		s.jsonHealth = true
+	}
+}
+
+// healthResponse is the response of a health endpoint when healthy.
+type healthResponse struct {
+	code int
+	body string
+}
+
+// WithReadinessResponse sets the status code and body with which the
+// readiness endpoint responds when the function is ready, in place of the
+// default 200 "READY", for probes which expect a specific response.  When
+// using WithJSONHealth the code is used but the body remains JSON.
+func WithReadinessResponse(code int, body string) Option {
+	return func(s *Service) {
+		s.readyResponse = healthResponse{code: code, body: body}
+	}
+}
+
+// WithLivenessResponse sets the status code and body with which the
+// liveness endpoint responds when the function is alive, in place of the
+// default 200 "ALIVE".  See WithReadinessResponse.
+func WithLivenessResponse(code int, body string) Option {
+	return func(s *Service) {
+		s.aliveResponse = healthResponse{code: code, body: body}
+	}
+}
+
+// healthStatus is the JSON response of a health endpoint.
+type healthStatus struct {
+	Status    string                 `json:"status"`
+	Message   string                 `json:"message,omitempty"`
+	Checks    map[string]checkResult `json:"checks,omitempty"`
+	Timestamp time.Time              `json:"timestamp"`
+}
+
+// writeHealth writes the response of a health endpoint: the given text, or
+// JSON if enabled using WithJSONHealth.
+func (s *Service) writeHealth(w http.ResponseWriter, code int, text string, checks map[string]checkResult) {
+	if s.jsonHealth {
+		writeHealthJSON(w, code, strings.TrimSpace(text), checks)
+		return
+	}
+	if code != http.StatusOK {
+		w.WriteHeader(code)
+	}
+	fmt.Fprint(w, text)
+}
+
+// writeHealthChecks writes the results of failed health checks, which are
+// always JSON.
+func writeHealthChecks(w http.ResponseWriter, results map[string]checkResult) {
+	log.Debug().Any("checks", results).Msg("health check failed")
+	writeHealthJSON(w, http.StatusServiceUnavailable, "", results)
+}
+
+func writeHealthJSON(w http.ResponseWriter, code int, message string, checks map[string]checkResult) {
+	status := healthStatus{Status: "ok", Checks: checks, Timestamp: time.Now().UTC()}
+	switch {
+	case code >= 200 && code < 300:
+	case code == http.StatusServiceUnavailable:
+		status.Status, status.Message = "unavailable", message
+	default:
+		status.Status, status.Message = "error", message
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_ = json.NewEncoder(w).Encode(status)
+}
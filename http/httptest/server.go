@@ -0,0 +1,66 @@
+// Package httptest provides for testing functions served by the HTTP
+// middleware, analogous to net/http/httptest.
+package httptest
+
+import (
+	"context"
+	"fmt"
+	"net"
+
+	fn "knative.dev/func-go/http"
+)
+
+// NewServer starts a service for the function instance f listening on an
+// OS-chosen port of the loopback interface, returning it along with its
+// base URL (for example "http://127.0.0.1:40111") and a function which stops
+// it.  It blocks until the service is listening and the function's Start
+// hook has returned (see fn.WithSynchronousStart), such that requests may
+// be sent immediately.  It panics if the service fails to start.
+//
+// Options are applied before those of NewServer, such that an address set
+// using fn.WithListenAddress, or a callback set using fn.WithReadyCallback,
+// is replaced.
+func NewServer(f fn.Handler, options ...fn.Option) (*fn.Service, string, func()) {
+	return newServer(func(oo ...fn.Option) *fn.Service { return fn.New(f, oo...) }, options)
+}
+
+// NewResponseHandlerServer is NewServer for an instance which returns its
+// responses (see fn.ResponseHandler).
+func NewResponseHandlerServer(f fn.ResponseHandler, options ...fn.Option) (*fn.Service, string, func()) {
+	return newServer(func(oo ...fn.Option) *fn.Service { return fn.NewResponseHandler(f, oo...) }, options)
+}
+
+// NewRouterServer is NewServer for an instance which routes requests itself
+// (see fn.Router).
+func NewRouterServer(f fn.Router, options ...fn.Option) (*fn.Service, string, func()) {
+	return newServer(func(oo ...fn.Option) *fn.Service { return fn.NewRouter(f, oo...) }, options)
+}
+
+// newServer starts the service returned by newService as described by
+// NewServer.
+func newServer(newService func(...fn.Option) *fn.Service, options []fn.Option) (*fn.Service, string, func()) {
+	var (
+		ctx, cancel = context.WithCancel(context.Background())
+		ready       = make(chan net.Addr, 1)
+		errCh       = make(chan error, 1)
+	)
+	options = append(options,
+		fn.WithListenAddress("127.0.0.1:0"),
+		fn.WithSynchronousStart(),
+		fn.WithReadyCallback(func(addr net.Addr) { ready <- addr }))
+	service := newService(options...)
+	go func() {
+		errCh <- service.Start(ctx)
+	}()
+
+	select {
+	case addr := <-ready:
+		return service, "http://" + addr.String(), func() {
+			cancel()
+			<-errCh
+		}
+	case err := <-errCh:
+		cancel()
+		panic(fmt.Sprintf("httptest: failed to start service: %v", err))
+	}
+}
@@ -0,0 +1,114 @@
+package httptest
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"testing"
+
+	fn "knative.dev/func-go/http"
+	"knative.dev/func-go/http/mock"
+)
+
+// TestNewServer ensures that the service is listening, and the function
+// started, once NewServer returns, and that the function is stopped by the
+// cleanup function returned.
+func TestNewServer(t *testing.T) {
+	var started, stopped bool
+	f := &mock.Function{
+		OnStart: func(context.Context, map[string]string) error {
+			started = true
+			return nil
+		},
+		OnStop: func(context.Context) error {
+			stopped = true
+			return nil
+		},
+		OnHandle: func(w http.ResponseWriter, _ *http.Request) {
+			fmt.Fprintf(w, "OK")
+		},
+	}
+	_, url, cleanup := NewServer(f)
+	if !started {
+		t.Fatal("function not started")
+	}
+
+	resp, err := http.Get(url)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusOK || string(body) != "OK" {
+		t.Fatalf("unexpected response %v %q", resp.StatusCode, body)
+	}
+
+	cleanup()
+	if !stopped {
+		t.Fatal("function not stopped")
+	}
+}
+
+// TestNewServer_StartError ensures that NewServer panics if the function
+// fails to start.
+func TestNewServer_StartError(t *testing.T) {
+	f := &mock.Function{OnStart: func(context.Context, map[string]string) error {
+		return errors.New("start failed")
+	}}
+	defer func() {
+		if recover() == nil {
+			t.Fatal("expected a panic")
+		}
+	}()
+	NewServer(f)
+}
+
+// routes is a function which implements fn.Router alone.
+type routes func(*http.ServeMux)
+
+func (f routes) Routes(mux *http.ServeMux) { f(mux) }
+
+// responses is a function which implements fn.ResponseHandler.
+type responses func(context.Context, *http.Request) (*fn.Response, error)
+
+func (f responses) Handle(ctx context.Context, r *http.Request) (*fn.Response, error) {
+	return f(ctx, r)
+}
+
+// TestNewServer_Others ensures that instances which route requests
+// themselves, or return their responses, are served by the servers of each.
+func TestNewServer_Others(t *testing.T) {
+	get := func(url string) string {
+		t.Helper()
+		resp, err := http.Get(url)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return string(body)
+	}
+
+	_, url, cleanup := NewRouterServer(routes(func(mux *http.ServeMux) {
+		mux.HandleFunc("GET /items/{id}", func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, "item %v", r.PathValue("id"))
+		})
+	}))
+	defer cleanup()
+	if body := get(url + "/items/42"); body != "item 42" {
+		t.Fatalf("unexpected response from the router %q", body)
+	}
+
+	_, url, cleanup = NewResponseHandlerServer(responses(func(context.Context, *http.Request) (*fn.Response, error) {
+		return &fn.Response{Body: []byte("OK")}, nil
+	}))
+	defer cleanup()
+	if body := get(url); body != "OK" {
+		t.Fatalf("unexpected response from the response handler %q", body)
+	}
+}
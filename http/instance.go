@@ -5,21 +5,43 @@ import (
 	"net/http"
 )
 
-// Handler is a function instance which can handle a request.
+// Handler is a function instance which can handle a request.  An instance
+// may instead implement ResponseHandler, returning the response to be
+// written by the runtime (see NewResponseHandler).
 //
 // This is of course specific to Go functions, with other languages using types
 // of their own (see language-specific runtime middleware), but the conceptual
 // framework is the same.
 type Handler interface {
 	// Handle a request.
+	// The ResponseWriter implements http.Flusher, such that a response may
+	// be streamed to the client (see StreamHandler), and http.Hijacker, such
+	// that the connection may be upgraded (see WithoutUpgradeTimeouts).
 	Handle(http.ResponseWriter, *http.Request)
 }
 
 type HandleFunc func(http.ResponseWriter, *http.Request)
 
+// Router is an instance which routes requests itself by method and path,
+// for example with patterns such as "GET /items/{id}" and "POST /items",
+// rather than handling all requests using Handle, which is then not
+// invoked.  A request matching no pattern is responded to with 404 Not
+// Found, or 405 Method Not Allowed if its path matches a pattern for another
+// method; register the pattern "/" to handle such requests instead.
+//
+// The health endpoints remain reserved, being served ahead of the routes.
+// An instance which implements Router but not Handler is served using
+// NewRouter.
+type Router interface {
+	// Routes registers the instance's handlers on the given mux.
+	Routes(*http.ServeMux)
+}
+
 // Starter is an instance which has defined the Start hook
 type Starter interface {
-	// Start instance event hook.
+	// Start instance event hook.  Its context is canceled should the
+	// service stop while it is in progress, in which case Stop is invoked
+	// only once it has returned.
 	Start(context.Context, map[string]string) error
 }
 
@@ -43,14 +65,60 @@ type LivenessReporter interface {
 
 // DefaultHandler is used for simple static function implementations which
 // need only define a single exported function named Handle of type HandleFunc.
+//
+// Static functions may also define any of the lifecycle hooks by setting
+// OnStart, OnStop, OnReady and OnAlive, which are otherwise no-ops (ready
+// and alive).
 type DefaultHandler struct {
 	// TODO: Update type HandleFunc when backwards compatibility no
 	// longer needed:
 	Handler handleFuncDeprecated
+
+	OnStart func(context.Context, map[string]string) error
+	OnStop  func(context.Context) error
+	OnReady func(context.Context) (bool, error)
+	OnAlive func(context.Context) (bool, error)
 }
 
+// Handle the request using Handler, responding 404 Not Found if it is nil.
 func (f DefaultHandler) Handle(w http.ResponseWriter, r *http.Request) {
+	if f.Handler == nil {
+		http.NotFound(w, r)
+		return
+	}
 	f.Handler(r.Context(), w, r)
 }
 
+// Start invokes OnStart if defined.
+func (f DefaultHandler) Start(ctx context.Context, cfg map[string]string) error {
+	if f.OnStart == nil {
+		return nil
+	}
+	return f.OnStart(ctx, cfg)
+}
+
+// Stop invokes OnStop if defined.
+func (f DefaultHandler) Stop(ctx context.Context) error {
+	if f.OnStop == nil {
+		return nil
+	}
+	return f.OnStop(ctx)
+}
+
+// Ready invokes OnReady if defined, reporting ready otherwise.
+func (f DefaultHandler) Ready(ctx context.Context) (bool, error) {
+	if f.OnReady == nil {
+		return true, nil
+	}
+	return f.OnReady(ctx)
+}
+
+// Alive invokes OnAlive if defined, reporting alive otherwise.
+func (f DefaultHandler) Alive(ctx context.Context) (bool, error) {
+	if f.OnAlive == nil {
+		return true, nil
+	}
+	return f.OnAlive(ctx)
+}
+
 type handleFuncDeprecated func(context.Context, http.ResponseWriter, *http.Request)
@@ -0,0 +1,119 @@
+package http
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// DefaultMaxJSONSize is the maximum size in bytes of a request body read by
+// ReadJSON unless set using MaxJSONSize.
+const DefaultMaxJSONSize = 1 << 20
+
+// JSONError is returned by ReadJSON when the request body can not be
+// decoded, with the status code with which to respond.  Its message is
+// suitable for returning to the client:
+//
+//	if err := fn.ReadJSON(r, &req); err != nil {
+//		http.Error(w, err.Error(), err.(*fn.JSONError).Status)
+//		return
+//	}
+type JSONError struct {
+	Status int
+	Err    error
+}
+
+func (e *JSONError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *JSONError) Unwrap() error {
+	return e.Err
+}
+
+// ReadJSONOption configures ReadJSON.
+type ReadJSONOption func(*readJSON)
+
+type readJSON struct {
+	maxSize               int64
+	disallowUnknownFields bool
+}
+
+// MaxJSONSize limits the size in bytes of the request body read by
+// ReadJSON, in place of DefaultMaxJSONSize.
+func MaxJSONSize(n int64) ReadJSONOption {
+	return func(o *readJSON) {
+		o.maxSize = n
+	}
+}
+
+// DisallowUnknownFields causes ReadJSON to reject a request body containing
+// object keys which do not match a field of the destination.
+func DisallowUnknownFields() ReadJSONOption {
+	return func(o *readJSON) {
+		o.disallowUnknownFields = true
+	}
+}
+
+// ReadJSON decodes the request's body, which must be a single JSON value no
+// larger than DefaultMaxJSONSize, into v.  Any error is a *JSONError.
+func ReadJSON(r *http.Request, v any, options ...ReadJSONOption) error {
+	o := readJSON{maxSize: DefaultMaxJSONSize}
+	for _, opt := range options {
+		opt(&o)
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, o.maxSize+1))
+	if err != nil {
+		return &JSONError{Status: http.StatusBadRequest, Err: fmt.Errorf("reading request body: %w", err)}
+	}
+	if int64(len(body)) > o.maxSize {
+		return &JSONError{Status: http.StatusRequestEntityTooLarge,
+			Err: fmt.Errorf("request body exceeds %v bytes", o.maxSize)}
+	}
+	if len(bytes.TrimSpace(body)) == 0 {
+		return &JSONError{Status: http.StatusBadRequest, Err: errors.New("request body is empty")}
+	}
+
+	dec := json.NewDecoder(bytes.NewReader(body))
+	if o.disallowUnknownFields {
+		dec.DisallowUnknownFields()
+	}
+	if err := dec.Decode(v); err != nil {
+		var (
+			syntaxErr *json.SyntaxError
+			typeErr   *json.UnmarshalTypeError
+		)
+		switch {
+		case errors.As(err, &syntaxErr):
+			err = fmt.Errorf("malformed JSON at offset %v: %w", syntaxErr.Offset, err)
+		case errors.As(err, &typeErr):
+			err = fmt.Errorf("invalid value for field %q: expected %v", typeErr.Field, typeErr.Type)
+		case errors.Is(err, io.ErrUnexpectedEOF):
+			err = errors.New("malformed JSON: unexpected end of request body")
+		}
+		return &JSONError{Status: http.StatusBadRequest, Err: err}
+	}
+	if dec.More() {
+		return &JSONError{Status: http.StatusBadRequest, Err: errors.New("request body must be a single JSON value")}
+	}
+	return nil
+}
+
+// WriteJSON writes v as the JSON body of the response with the given status
+// code.  Should v not be encodable, a 500 is written instead and the error
+// returned.
+func WriteJSON(w http.ResponseWriter, status int, v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		http.Error(w, "error encoding response", http.StatusInternalServerError)
+		return err
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_, err = w.Write(append(b, '\n'))
+	return err
+}
@@ -0,0 +1,132 @@
+package http
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// WithKeepAlivesDisabled closes each connection after its response, such
+// that every request is made on a new connection.  This avoids requests
+// failing on connections which a load balancer in front of the function
+// has silently dropped.  By default keep-alives are enabled, and idle
+// connections closed after the server's IdleTimeout.
+func WithKeepAlivesDisabled() Option {
+	return func(s *Service) {
+		s.keepAlivesDisabled = true
+	}
+}
+
+// WithMaxConnectionLifetime closes connections once they are older than d,
+// such that clients reconnect periodically and load is rebalanced across
+// instances behind a load balancer which balances connections.  A
+// connection which is idle when it expires is closed immediately, and one
+// which is handling a request is closed after its response.  A lifetime of
+// zero, the default, is unbounded.
+func WithMaxConnectionLifetime(d time.Duration) Option {
+	return func(s *Service) {
+		if d <= 0 {
+			s.connLifetimes = nil
+			return
+		}
+		s.connLifetimes = &connLifetimes{max: d, conns: map[net.Conn]*connLifetime{}}
+	}
+}
+
+// connKey is the key of the connection of a request in its context.
+type connKey struct{}
+
+// connLifetimes closes connections of the server which outlive the maximum
+// lifetime.  Its hooks are registered with the server by New, in addition
+// to any set using WithServerOptions.
+type connLifetimes struct {
+	max   time.Duration
+	mu    sync.Mutex
+	conns map[net.Conn]*connLifetime
+}
+
+// connLifetime is the state of a connection tracked by connLifetimes.
+type connLifetime struct {
+	idle    bool
+	expired bool
+	timer   *time.Timer
+}
+
+// register the hooks with the server, chaining any already set.
+func (l *connLifetimes) register(srv *http.Server) {
+	connState, connContext := srv.ConnState, srv.ConnContext
+	srv.ConnState = func(c net.Conn, state http.ConnState) {
+		l.track(c, state)
+		if connState != nil {
+			connState(c, state)
+		}
+	}
+	srv.ConnContext = func(ctx context.Context, c net.Conn) context.Context {
+		if connContext != nil {
+			ctx = connContext(ctx, c)
+		}
+		return context.WithValue(ctx, connKey{}, c)
+	}
+}
+
+// track the state of the connection, closing it if it is idle once expired.
+func (l *connLifetimes) track(c net.Conn, state http.ConnState) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	switch state {
+	case http.StateNew:
+		cl := &connLifetime{}
+		cl.timer = time.AfterFunc(l.max, func() {
+			l.mu.Lock()
+			defer l.mu.Unlock()
+			cl.expired = true
+			if cl.idle {
+				c.Close()
+			}
+		})
+		l.conns[c] = cl
+	case http.StateActive:
+		if cl, ok := l.conns[c]; ok {
+			cl.idle = false
+		}
+	case http.StateIdle:
+		if cl, ok := l.conns[c]; ok {
+			cl.idle = true
+			if cl.expired {
+				c.Close()
+			}
+		}
+	case http.StateHijacked, http.StateClosed:
+		if cl, ok := l.conns[c]; ok {
+			cl.timer.Stop()
+			delete(l.conns, c)
+		}
+	}
+}
+
+// expired returns true if the connection of the request has outlived the
+// maximum lifetime.
+func (l *connLifetimes) expired(r *http.Request) bool {
+	c, ok := r.Context().Value(connKey{}).(net.Conn)
+	if !ok {
+		return false
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	cl, ok := l.conns[c]
+	return ok && cl.expired
+}
+
+// middleware which asks the client to close the connection of a request
+// once it has expired, such that it does not send another request on a
+// connection about to be closed.
+func (l *connLifetimes) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if l.expired(r) {
+			w.Header().Set("Connection", "close")
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,104 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"time"
+)
+
+// Option configures a Service.
+type Option func(*Service)
+
+// invalidOption records the error of an option given an invalid value,
+// which is returned by Start.
+func (s *Service) invalidOption(format string, args ...any) {
+	s.optionErr = errors.Join(s.optionErr, fmt.Errorf(format, args...))
+}
+
+// Middleware wraps an http.Handler, returning a handler which can perform
+// work before and/or after invoking it.
+type Middleware func(http.Handler) http.Handler
+
+// WithMiddleware adds middleware to the chain which wraps the function's
+// handler.  Middleware is applied outermost-first in the order registered,
+// and is not applied to the health endpoints.
+func WithMiddleware(m ...Middleware) Option {
+	return func(s *Service) {
+		s.middleware = append(s.middleware, m...)
+	}
+}
+
+// chain returns the handler h wrapped by the given middleware such that
+// the first middleware is the outermost.
+func chain(h http.Handler, mm []Middleware) http.Handler {
+	for i := len(mm) - 1; i >= 0; i-- {
+		h = mm[i](h)
+	}
+	return h
+}
+
+// WithReadyAfterStart reports the function as not ready (503) until its
+// Start hook has returned successfully.  Requests received before then wait
+// for Start to complete rather than reaching an uninitialized function.
+func WithReadyAfterStart() Option {
+	return func(s *Service) {
+		s.readyAfterStart = true
+	}
+}
+
+// WithListenAddress listens on the given address, such as "127.0.0.1:0"
+// for an OS-chosen port, rather than that of the environment variable
+// LISTEN_ADDRESS (or the default).  As with LISTEN_ADDRESS, the path of a
+// Unix domain socket may be given prefixed with "unix://".
+func WithListenAddress(addr string) Option {
+	return func(s *Service) {
+		s.listenAddress = addr
+	}
+}
+
+// WithSynchronousStart invokes the function's Start hook before the service
+// begins listening, such that an error initializing the function is returned
+// from Start without the service ever accepting traffic.  By default the
+// Start hook is invoked asynchronously once the service is listening.
+func WithSynchronousStart() Option {
+	return func(s *Service) {
+		s.synchronousStart = true
+	}
+}
+
+// WithReadyCallback invokes cb with the address upon which the service
+// listens once it is bound, before any request is served.  This is the
+// OS-chosen port when listening on port 0 (for example LISTEN_ADDRESS
+// "127.0.0.1:0"), such that embedders and tests need not poll Addr.  It is
+// not invoked if the service fails to listen.
+func WithReadyCallback(cb func(net.Addr)) Option {
+	return func(s *Service) {
+		s.readyCallback = cb
+	}
+}
+
+// WithWriteTimeout sets the maximum duration of writing a response, which
+// defaults to 30 seconds.  A timeout of zero disables it, which may be
+// required by handlers which stream long-lived responses such as
+// Server-Sent Events.  StreamHandler clears the timeout for its own
+// responses regardless of this setting.
+func WithWriteTimeout(d time.Duration) Option {
+	return func(s *Service) {
+		s.WriteTimeout = d
+	}
+}
+
+// WithServerOptions configures the underlying http.Server using the given
+// functions, for settings which have no option of their own (for example
+// ConnState, TLSConfig or ErrorLog).  They are applied by New after all
+// other options, but before the runtime sets the server's Handler, which
+// can not be replaced.  Setting BaseContext replaces that of the runtime,
+// such that values on the context passed to Start are no longer available
+// to requests.
+func WithServerOptions(oo ...func(*http.Server)) Option {
+	return func(s *Service) {
+		s.serverOptions = append(s.serverOptions, oo...)
+	}
+}
@@ -0,0 +1,43 @@
+package http
+
+import (
+	"net/http"
+	"net/http/pprof"
+)
+
+// WithProfiling serves the runtime profiling data of net/http/pprof under
+// /debug/pprof/, for diagnosing the CPU and memory use of a running
+// function.  It is disabled by default.
+//
+// The endpoints are served alongside the health endpoints: on the admin
+// listener if set using WithAdminAddress, otherwise on the function's
+// listener, where they take precedence over the function for paths under
+// /debug/pprof/.  As with the administrative endpoints (see
+// WithAdminEndpoints) they require the token set using WithAdminToken
+// unless served on the admin listener.
+//
+// A CPU profile or trace must be shorter than the server's write timeout,
+// for example /debug/pprof/profile?seconds=10 for the default timeout.
+func WithProfiling() Option {
+	return func(s *Service) {
+		s.profiling = true
+	}
+}
+
+// profilingHandler returns the handler of the profiling endpoints, which
+// requires requests be authorized as for the administrative endpoints.
+func (s *Service) profilingHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/debug/pprof/", pprof.Index)
+	mux.HandleFunc("/debug/pprof/cmdline", pprof.Cmdline)
+	mux.HandleFunc("/debug/pprof/profile", pprof.Profile)
+	mux.HandleFunc("/debug/pprof/symbol", pprof.Symbol)
+	mux.HandleFunc("/debug/pprof/trace", pprof.Trace)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.authorizeAdmin(r) {
+			http.Error(w, "forbidden", http.StatusForbidden)
+			return
+		}
+		mux.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,31 @@
+package http
+
+import "github.com/pires/go-proxyproto"
+
+// WithProxyProtocol accepts the PROXY protocol (v1 or v2) on the function's
+// listener from the trusted upstreams, such that when the function is behind
+// a TCP load balancer which sends it, the request's RemoteAddr is that of
+// the client rather than the load balancer.  This is then the address
+// returned by RemoteIP, for example for use as the key function of
+// WithRateLimitKey.
+//
+// Each trusted upstream is an IP address or CIDR range, such as that of the
+// load balancer.  A PROXY header from any other peer is discarded and the
+// connection served with its own address, such that a client able to reach
+// the listener directly cannot spoof its address.  Connections without a
+// PROXY header, such as from probes, are served as usual.  Start fails if no
+// trusted upstream is given or one is invalid.
+func WithProxyProtocol(trusted ...string) Option {
+	return func(s *Service) {
+		if len(trusted) == 0 {
+			s.invalidOption("proxy protocol requires at least one trusted upstream")
+			return
+		}
+		policy, err := proxyproto.LaxWhiteListPolicy(trusted)
+		if err != nil {
+			s.invalidOption("invalid trusted proxy protocol upstream: %w", err)
+			return
+		}
+		s.proxyPolicy = policy
+	}
+}
@@ -0,0 +1,125 @@
+package http
+
+import (
+	"container/list"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+
+	"golang.org/x/time/rate"
+)
+
+// maxRateLimitKeys is the number of per-key limiters retained, beyond which
+// that of the least recently seen key is evicted.  A key seen again once
+// evicted is limited afresh.
+const maxRateLimitKeys = 10000
+
+// WithRateLimit limits requests to the function's handler to rps requests
+// per second with bursts of up to burst requests, using a token bucket.
+// Requests exceeding the limit are rejected with a 429 and a Retry-After
+// header.  The limit is global to the Service unless a key function is
+// provided with WithRateLimitKey.  Health endpoints are not limited.  Start
+// fails if burst is not positive, as every request would be rejected.
+func WithRateLimit(rps float64, burst int) Option {
+	return func(s *Service) {
+		if burst <= 0 {
+			s.invalidOption("invalid rate limit burst %v: must be positive", burst)
+			return
+		}
+		s.rateLimiter = newRateLimiter(rate.Limit(rps), burst)
+	}
+}
+
+// WithRateLimitKey enables per-client rate limiting by providing a function
+// which returns the key of the client making the request, for example
+// RemoteIP.  Each key is limited independently.  Has no effect unless a rate
+// limit is also enabled using WithRateLimit.
+func WithRateLimitKey(key func(*http.Request) string) Option {
+	return func(s *Service) {
+		s.rateLimitKey = key
+	}
+}
+
+// RemoteIP returns the IP address of the client making the request, for use
+// as a rate limit key.
+func RemoteIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// rateLimiter is a set of token bucket limiters, one per key, bounded to the
+// most recently seen keys.
+type rateLimiter struct {
+	limit rate.Limit
+	burst int
+	max   int // keys retained
+
+	mu       sync.Mutex
+	limiters map[string]*list.Element // of recent, by key
+	recent   *list.List               // of *keyedLimiter, most recent first
+}
+
+// keyedLimiter is the limiter of a key, as an element of rateLimiter.recent.
+type keyedLimiter struct {
+	key     string
+	limiter *rate.Limiter
+}
+
+func newRateLimiter(limit rate.Limit, burst int) *rateLimiter {
+	return &rateLimiter{
+		limit:    limit,
+		burst:    burst,
+		max:      maxRateLimitKeys,
+		limiters: make(map[string]*list.Element),
+		recent:   list.New(),
+	}
+}
+
+// get the limiter for the given key, creating it if necessary and evicting
+// that of the least recently seen key if the set is full.
+func (l *rateLimiter) get(key string) *rate.Limiter {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if e, ok := l.limiters[key]; ok {
+		l.recent.MoveToFront(e)
+		return e.Value.(*keyedLimiter).limiter
+	}
+	if l.recent.Len() >= l.max {
+		oldest := l.recent.Back()
+		l.recent.Remove(oldest)
+		delete(l.limiters, oldest.Value.(*keyedLimiter).key)
+	}
+	lim := rate.NewLimiter(l.limit, l.burst)
+	l.limiters[key] = l.recent.PushFront(&keyedLimiter{key: key, limiter: lim})
+	return lim
+}
+
+// middleware which rejects requests exceeding the rate limit, keyed by
+// the given key function (or globally if nil).
+func (l *rateLimiter) middleware(key func(*http.Request) string) Middleware {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			k := ""
+			if key != nil {
+				k = key(r)
+			}
+			res := l.get(k).Reserve()
+			if delay := res.Delay(); !res.OK() || delay > 0 {
+				res.Cancel()
+				retryAfter := int(math.Ceil(delay.Seconds()))
+				if !res.OK() || retryAfter < 1 {
+					retryAfter = 1
+				}
+				w.Header().Set("Retry-After", strconv.Itoa(retryAfter))
+				http.Error(w, "rate limit exceeded", http.StatusTooManyRequests)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
@@ -0,0 +1,45 @@
+package http
+
+import (
+	"net/http"
+	"runtime/debug"
+)
+
+// WithRecover recovers from a panic in the function, or in middleware
+// registered using WithMiddleware, invoking fn with the value recovered
+// such that it may respond to the request and report the panic (for
+// example to an alerting service).  DefaultRecover logs the panic and
+// responds 500.  A panic of http.ErrAbortHandler, which aborts the response,
+// is not recovered.
+//
+// By default, panics are recovered by net/http, which logs them and closes
+// the connection without responding.
+func WithRecover(fn func(w http.ResponseWriter, r *http.Request, recovered any)) Option {
+	return func(s *Service) {
+		s.recover = fn
+	}
+}
+
+// DefaultRecover logs the recovered panic along with its stack trace, and
+// responds 500 Internal Server Error.  See WithRecover.
+func DefaultRecover(w http.ResponseWriter, r *http.Request, recovered any) {
+	requestLog(r).Error().Any("panic", recovered).Str("stack", string(debug.Stack())).
+		Msg("function panicked")
+	http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+}
+
+// recoverPanics wraps the handler such that a panic is recovered and passed
+// to the service's recover function.
+func (s *Service) recoverPanics(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if v := recover(); v != nil {
+				if v == http.ErrAbortHandler {
+					panic(v)
+				}
+				s.recover(w, r, v)
+			}
+		}()
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,93 @@
+package http
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// ResponseHandler is a function instance which handles a request by
+// returning its response, rather than by writing it (see Handler), such
+// that it may be tested, and its outcome inspected, without an
+// http.ResponseWriter.  The runtime writes the response.
+//
+// A nil response with a nil error is written as 204 No Content.  An error
+// is written as a 500 Internal Server Error, without its message, unless it
+// is a *JSONError (as returned by ReadJSON) in which case it is written
+// with its status and message.  An error returned after the client has
+// disconnected (its context canceled) is neither written nor logged as an
+// error.
+type ResponseHandler interface {
+	// Handle a request, returning its response.
+	Handle(context.Context, *http.Request) (*Response, error)
+}
+
+// Response is the response to a request returned by a ResponseHandler.
+type Response struct {
+	// Status is the status code, which is 200 OK if not set.
+	Status int
+
+	// Header is added to the headers of the response, such as a
+	// Content-Type for the Body.
+	Header http.Header
+
+	// Body is written as the response's body.
+	Body []byte
+}
+
+// ErrUnsupportedHandler is returned by Start when the function implements
+// none of Handler, ResponseHandler or Router, as when nil.
+var ErrUnsupportedHandler = errors.New("function implements none of Handler, ResponseHandler or Router")
+
+// handlerOf returns the function by which the instance f handles requests
+// which it does not route itself (see Router).
+func handlerOf(f any) (http.HandlerFunc, error) {
+	switch h := f.(type) {
+	case Handler:
+		return h.Handle, nil
+	case ResponseHandler:
+		return respondWith(h), nil
+	case Router:
+		return http.NotFound, nil // not invoked
+	}
+	return nil, fmt.Errorf("%w: %T", ErrUnsupportedHandler, f)
+}
+
+// respondWith adapts a ResponseHandler to an http.HandlerFunc, writing the
+// response it returns.
+func respondWith(h ResponseHandler) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		resp, err := h.Handle(r.Context(), r)
+		var jsonErr *JSONError
+		switch {
+		case errors.As(err, &jsonErr):
+			http.Error(w, jsonErr.Error(), jsonErr.Status)
+			return
+		case err != nil && clientDisconnected(r):
+			// Not an error of the function, nor is there anyone to respond to.
+			requestLog(r).Debug().Err(err).Msg("function error after client disconnected")
+			return
+		case err != nil:
+			requestLog(r).Error().Err(err).Msg("function error")
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		case resp == nil:
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		for k, vv := range resp.Header {
+			for _, v := range vv {
+				w.Header().Add(k, v)
+			}
+		}
+		status := resp.Status
+		if status == 0 {
+			status = http.StatusOK
+		}
+		w.WriteHeader(status)
+		if _, err := w.Write(resp.Body); err != nil {
+			requestLog(r).Debug().Err(err).Msg("error writing response")
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package http
+
+import (
+	"context"
+	"maps"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+
+	"knative.dev/func-go/internal/config"
+)
+
+// DefaultConfigWatchInterval is how often the config is checked for changes
+// when WithRestartOnConfigChange is enabled.
+const DefaultConfigWatchInterval = 5 * time.Second
+
+// restartPollInterval is how often a restart checks whether the requests
+// in-flight have completed.
+const restartPollInterval = 10 * time.Millisecond
+
+// WithRestartOnConfigChange restarts the function instance when its config
+// changes: the function's Stop hook is invoked followed by its Start hook
+// with the new config, without closing the listener.  Readiness is reported
+// as 503 while restarting.
+//
+// Requests in-flight are allowed to complete (for up to
+// InstanceStopTimeout) before the instance is stopped, and requests
+// received while restarting wait for the restart to complete, failing with
+// a 503 should they be canceled first, such that no request reaches a
+// stopped or partially started instance.  The config is not watched until
+// the instance has first started.
+//
+// The static config file (cfg) is checked for changes every
+// DefaultConfigWatchInterval.
+func WithRestartOnConfigChange() Option {
+	return func(s *Service) {
+		s.configWatchInterval = DefaultConfigWatchInterval
+	}
+}
+
+// restarter tracks whether the function instance is restarting, and the
+// requests in-flight which a restart must await.
+type restarter struct {
+	restarting atomic.Bool
+	admitted   atomic.Int64 // requests in-flight
+	mu         sync.Mutex
+	resumed    chan struct{} // while restarting, closed upon completion
+}
+
+// enter admits a request to the instance, waiting for any restart in
+// progress to complete.  It returns false if the context is done first.
+// An admitted request must leave.
+func (r *restarter) enter(ctx context.Context) bool {
+	for {
+		r.mu.Lock()
+		resumed := r.resumed
+		if resumed == nil {
+			r.admitted.Add(1)
+			r.mu.Unlock()
+			return true
+		}
+		r.mu.Unlock()
+		select {
+		case <-resumed:
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+// leave records that an admitted request has completed.
+func (r *restarter) leave() {
+	r.admitted.Add(-1)
+}
+
+// pause admits no further requests, and waits for those in-flight to
+// complete or for the context to be done.  Requests are admitted again by
+// resume.
+func (r *restarter) pause(ctx context.Context) {
+	r.mu.Lock()
+	r.resumed = make(chan struct{})
+	r.mu.Unlock()
+
+	ticker := time.NewTicker(restartPollInterval)
+	defer ticker.Stop()
+	for r.admitted.Load() > 0 {
+		select {
+		case <-ctx.Done():
+			log.Warn().Int64("inflight", r.admitted.Load()).
+				Msg("requests in-flight did not complete before restart")
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// resume admitting requests.
+func (r *restarter) resume() {
+	r.mu.Lock()
+	close(r.resumed)
+	r.resumed = nil
+	r.mu.Unlock()
+}
+
+// awaitRestart wraps the handler such that requests do not reach the
+// function instance while it is restarting.
+func (s *Service) awaitRestart(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.restarter.enter(r.Context()) {
+			http.Error(w, "function restarting", http.StatusServiceUnavailable)
+			return
+		}
+		defer s.restarter.leave()
+		next.ServeHTTP(w, r)
+	})
+}
+
+// watchConfig polls the config for changes until the context is canceled,
+// restarting the function instance on change.  Polling begins once the
+// instance has first started.
+func (s *Service) watchConfig(ctx context.Context) {
+	select {
+	case <-s.started:
+	case <-ctx.Done():
+		return
+	}
+	last := s.config()
+	ticker := time.NewTicker(s.configWatchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		cfg, err := config.New()
+		if err != nil {
+			log.Warn().Err(err).Msg("unable to read config. Not restarting")
+			continue
+		}
+		if maps.Equal(cfg, last) {
+			continue
+		}
+		last = cfg
+		if err := s.restartInstance(ctx, cfg); err != nil {
+			select {
+			case s.stop <- err:
+			case <-ctx.Done():
+			}
+			return
+		}
+	}
+}
+
+// restartInstance stops the function instance and starts it again with the
+// given config, once the requests in-flight have completed.  Requests wait
+// for it to complete.
+func (s *Service) restartInstance(ctx context.Context, cfg map[string]string) error {
+	log.Info().Msg("config changed. Restarting function")
+	s.restarting.Store(true)
+	defer s.restarting.Store(false)
+
+	drainCtx, cancel := context.WithTimeout(ctx, InstanceStopTimeout)
+	s.restarter.pause(drainCtx)
+	cancel()
+	defer s.restarter.resume()
+
+	if i, ok := s.f.(Stopper); ok {
+		stopCtx, cancel := context.WithTimeout(context.Background(), InstanceStopTimeout)
+		defer cancel()
+		if err := i.Stop(stopCtx); err != nil {
+			return err
+		}
+	}
+	s.cfg.Store(&cfg)
+	if i, ok := s.f.(Starter); ok {
+		if err := i.Start(ctx, cfg); err != nil {
+			return err
+		}
+	}
+	return nil
+}
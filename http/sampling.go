@@ -0,0 +1,61 @@
+package http
+
+import (
+	"net/http"
+	"sync/atomic"
+
+	"github.com/rs/zerolog"
+)
+
+// WithLogSampling emits only 1 in n of the debug-level log lines about
+// requests, such as those of the runtime upon rejecting an invalid JWT, or
+// those of the function using LoggerFromContext, such that debug logging
+// remains usable at high throughput.  Each line emitted includes the number
+// of lines suppressed since the previous as the field "sampled_out".  Lines
+// at other levels are unaffected.  A value of n less than 2 disables
+// sampling.
+func WithLogSampling(n int) Option {
+	return func(s *Service) {
+		if n < 2 {
+			s.logSampler = nil
+			return
+		}
+		s.logSampler = &logSampler{n: uint32(n)}
+	}
+}
+
+// logSampler samples 1 in n log lines, counting those suppressed.  It is
+// also a hook which adds that count to the next line emitted.
+type logSampler struct {
+	n          uint32
+	count      atomic.Uint32
+	suppressed atomic.Uint64
+}
+
+func (s *logSampler) Sample(zerolog.Level) bool {
+	if (s.count.Add(1)-1)%s.n == 0 {
+		return true
+	}
+	s.suppressed.Add(1)
+	return false
+}
+
+func (s *logSampler) Run(e *zerolog.Event, level zerolog.Level, _ string) {
+	if level != zerolog.DebugLevel {
+		return
+	}
+	if n := s.suppressed.Swap(0); n > 0 {
+		e.Uint64("sampled_out", n)
+	}
+}
+
+// sampleLogs wraps the handler such that the logger of each request samples
+// its debug-level lines.
+func (s *Service) sampleLogs(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		l := LoggerFromContext(r.Context()).
+			Sample(zerolog.LevelSampler{DebugSampler: s.logSampler}).
+			Hook(s.logSampler)
+		next.ServeHTTP(w, r.WithContext(l.WithContext(r.Context())))
+	})
+}
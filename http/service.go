@@ -4,23 +4,25 @@
 package http
 
 import (
-	"bufio"
 	"context"
-	"fmt"
 	"net"
 	"net/http"
 	"os"
-	"os/signal"
-	"runtime"
-	"strings"
-	"syscall"
+	"sync"
+	"sync/atomic"
 	"time"
 
+	"github.com/pires/go-proxyproto"
 	"github.com/rs/zerolog/log"
+
+	"knative.dev/func-go/internal/config"
+	"knative.dev/func-go/internal/shutdown"
+	"knative.dev/func-go/internal/signals"
 )
 
 const (
 	DefaultLogLevel      = LogDebug
+	DefaultLogFormat     = LogJSON
 	DefaultListenAddress = "127.0.0.1:8080"
 )
 
@@ -30,26 +32,135 @@ const (
 )
 
 // Start an intance using a new Service
-// Note that for CloudEvent Handlers this effectively accepts ANY because
-// the actual type of the handler function is determined later.
 func Start(f Handler) error {
 	log.Debug().Msg("func runtime creating function instance")
 	return New(f).Start(context.Background())
 }
 
+// StartResponseHandler starts an instance which returns its responses (see
+// ResponseHandler) using a new Service.
+func StartResponseHandler(f ResponseHandler) error {
+	log.Debug().Msg("func runtime creating function instance")
+	return NewResponseHandler(f).Start(context.Background())
+}
+
+// StartRouter starts an instance which routes requests itself (see Router)
+// using a new Service.
+func StartRouter(f Router) error {
+	log.Debug().Msg("func runtime creating function instance")
+	return NewRouter(f).Start(context.Background())
+}
+
 // Service exposes a Function Instance as a an HTTP service.
+//
+// Fields of the embedded http.Server may be set before Start, either
+// directly or using WithServerOptions, with the exception of Handler which
+// is set by New.
 type Service struct {
 	http.Server
-	listener net.Listener
-	stop     chan error
-	f        Handler
+	listener      net.Listener
+	listenAddress string                // by WithListenAddress
+	proxyPolicy   proxyproto.PolicyFunc // by WithProxyProtocol
+	readyCallback func(net.Addr)
+	stop          chan error
+	f             any
+	handle        http.HandlerFunc // of f (see handlerOf)
+	handlerErr    error            // returned by Start
+	optionErr     error            // of invalid options, returned by Start
+	middleware    []Middleware
+
+	signalHandlers map[os.Signal]func()          // by WithSignalHandler
+	shutdownHooks  []func(context.Context) error // by WithShutdownHook
+
+	serverOptions []func(*http.Server)
+
+	rateLimiter  *rateLimiter
+	rateLimitKey func(*http.Request) string
+	workerPool   *workerPool
+
+	requestTimeouts  *requestTimeouts
+	concurrencyLimit chan struct{}
+	requestID        bool
+	logSampler       *logSampler
+	auth             authenticator
+	recover          func(http.ResponseWriter, *http.Request, any)
+	healthChecks     map[string]HealthCheck
+	jsonHealth       bool
+	readyResponse    healthResponse
+	aliveResponse    healthResponse
+
+	compress            bool
+	maxDecompressedSize int64 // by WithMaxDecompressedSize
+
+	started          chan struct{}
+	startCancel      context.CancelFunc // of an asynchronous Start hook
+	startDone        chan struct{}      // closed when it returns
+	readyAfterStart  bool
+	synchronousStart bool
+
+	cfg atomic.Pointer[map[string]string] // with which the instance was last started
+
+	restarter
+	configWatchInterval time.Duration
+
+	hijackTracker
+	clearUpgradeDeadlines bool
+
+	keepAlivesDisabled bool
+	connLifetimes      *connLifetimes
+
+	drainer
+	admin         bool
+	adminToken    string
+	adminAddress  string
+	adminServer   *http.Server
+	adminListener net.Listener
+	profiling     bool
+
+	idleTracker
+	idleTimeout time.Duration
+
+	readinessTracker
+
+	running       atomic.Bool
+	stopOnce      sync.Once
+	stopRequested chan struct{} // closed by Stop
+	done          chan struct{} // closed when Start returns
+}
+
+// New Service which serves the given instance, which optionally implements
+// any of the lifecycle interfaces such as Starter, or Router to route
+// requests itself.
+func New(f Handler, options ...Option) *Service {
+	return newService(f, options)
 }
 
-// New Service which serves the given instance.
-func New(f Handler) *Service {
+// NewResponseHandler returns a new Service which serves the given instance,
+// which returns its responses (see ResponseHandler), and optionally
+// implements any of the lifecycle interfaces such as Starter.
+func NewResponseHandler(f ResponseHandler, options ...Option) *Service {
+	return newService(f, options)
+}
+
+// NewRouter returns a new Service which serves the given instance, which
+// routes requests itself (see Router), and optionally implements any of the
+// lifecycle interfaces such as Starter.
+func NewRouter(f Router, options ...Option) *Service {
+	return newService(f, options)
+}
+
+// newService which serves the instance f, which is a Handler,
+// ResponseHandler or Router.  Start returns ErrUnsupportedHandler should it
+// be none of those, as when nil.
+func newService(f any, options []Option) *Service {
 	svc := &Service{
-		f:    f,
-		stop: make(chan error),
+		f:             f,
+		stop:          make(chan error),
+		started:       make(chan struct{}),
+		stopRequested: make(chan struct{}),
+		done:          make(chan struct{}),
+		readyResponse: healthResponse{code: http.StatusOK, body: "READY"},
+		aliveResponse: healthResponse{code: http.StatusOK, body: "ALIVE"},
 		Server: http.Server{
 			ReadTimeout:       30 * time.Second,
 			WriteTimeout:      30 * time.Second,
@@ -58,10 +169,49 @@ func New(f Handler) *Service {
 			ReadHeaderTimeout: 2 * time.Second,
 		},
 	}
+	for _, o := range options {
+		o(svc)
+	}
+	for _, o := range svc.serverOptions {
+		o(&svc.Server)
+	}
+	if svc.optionErr != nil {
+		log.Error().Err(svc.optionErr).Msg("invalid options")
+	}
+	if svc.keepAlivesDisabled {
+		svc.SetKeepAlivesEnabled(false)
+	}
+	if svc.connLifetimes != nil {
+		svc.connLifetimes.register(&svc.Server)
+	}
+
+	// The function's handler is validated here, such that an unsupported
+	// instance is reported before any traffic, and returned by Start.
+	if svc.handle, svc.handlerErr = handlerOf(f); svc.handlerErr != nil {
+		log.Error().Err(svc.handlerErr).Msg("function handler unsupported")
+		svc.handle = http.NotFound
+	}
+
+	// Health and admin endpoints are served alongside the function unless
+	// an admin address is set, in which case they are served separately.
 	mux := http.NewServeMux()
-	mux.HandleFunc("/health/readiness", svc.Ready)
-	mux.HandleFunc("/health/liveness", svc.Alive)
-	mux.HandleFunc("/", svc.Handle)
+	endpoints := mux
+	if svc.adminServer = svc.newAdminServer(); svc.adminServer != nil {
+		endpoints = http.NewServeMux()
+		svc.adminServer.Handler = endpoints
+	}
+	endpoints.HandleFunc("/health/readiness", svc.Ready)
+	endpoints.HandleFunc("/health/liveness", svc.Alive)
+	if svc.admin {
+		endpoints.HandleFunc("/admin/drain", svc.handleDrain)
+	}
+	if (svc.admin || svc.profiling) && svc.adminToken == "" && svc.adminServer == nil {
+		log.Warn().Msg("admin endpoints require WithAdminToken or WithAdminAddress; refusing all requests to them")
+	}
+	if svc.profiling {
+		endpoints.Handle("/debug/pprof/", svc.profilingHandler())
+	}
+	mux.Handle("/", svc.handler())
 	svc.Handler = mux
 
 	// Print some helpful information about which interfaces the function
@@ -71,52 +221,203 @@ func New(f Handler) *Service {
 	return svc
 }
 
+// handler returns the function's handler (or its routes if a Router) wrapped
+// by the runtime's own middleware (outermost) followed by any registered
+// using WithMiddleware.
+func (s *Service) handler() http.Handler {
+	var mm []Middleware
+	if s.requestID {
+		// Outermost, such that all responses bear the ID.
+		mm = append(mm, assignRequestID)
+	}
+	if s.logSampler != nil {
+		// Within assignRequestID, such that sampled lines bear the ID.
+		mm = append(mm, s.sampleLogs)
+	}
+	mm = append(mm, s.detectDisconnects, s.trackUpgrades, s.refuseWhileDraining)
+	if s.idleTimeout > 0 {
+		mm = append(mm, s.trackIdle)
+	}
+	if s.connLifetimes != nil {
+		mm = append(mm, s.connLifetimes.middleware)
+	}
+	if s.auth.enabled() {
+		mm = append(mm, s.authenticate)
+	}
+	if s.readyAfterStart {
+		mm = append(mm, s.awaitStart)
+	}
+	if s.configWatchInterval > 0 {
+		mm = append(mm, s.awaitRestart)
+	}
+	// Within awaitRestart, such that a request held during a restart is given
+	// the config of the restarted instance.
+	mm = append(mm, s.addConfig)
+	if s.rateLimiter != nil {
+		mm = append(mm, s.rateLimiter.middleware(s.rateLimitKey))
+	}
+	if s.requestTimeouts != nil {
+		mm = append(mm, s.requestTimeouts.middleware)
+	}
+	if s.concurrencyLimit != nil {
+		mm = append(mm, s.limitConcurrency)
+	}
+	if s.compress {
+		mm = append(mm, compress(s.maxDecompressedSize))
+	}
+	if s.recover != nil {
+		mm = append(mm, s.recoverPanics)
+	}
+	mm = append(mm, s.middleware...)
+	if s.workerPool != nil {
+		// Innermost, such that workers only execute the function itself.
+		mm = append(mm, s.workerPool.middleware)
+		if s.recover != nil {
+			// As a panic on a worker would otherwise not be recovered.
+			mm = append(mm, s.recoverPanics)
+		}
+	}
+	var h http.Handler = http.HandlerFunc(s.Handle)
+	if r, ok := s.f.(Router); ok {
+		routes := http.NewServeMux()
+		r.Routes(routes)
+		h = routes
+	}
+	return chain(h, mm)
+}
+
 // log which interfaces the function implements.
 // This could be more verbose for new users:
 func logImplements(f any) {
-	if _, ok := f.(Starter); ok {
-		log.Info().Msg("Function implements Start")
+	for _, i := range implemented(f) {
+		log.Info().Msg("Function implements " + i)
 	}
-	if _, ok := f.(Stopper); ok {
-		log.Info().Msg("Function implements Stop")
-	}
-	if _, ok := f.(ReadinessReporter); ok {
-		log.Info().Msg("Function implements Ready")
+}
+
+// implemented returns the names of the optional interfaces which the
+// function implements.
+func implemented(f any) (ii []string) {
+	_, start := f.(Starter)
+	_, stop := f.(Stopper)
+	_, ready := f.(ReadinessReporter)
+	_, alive := f.(LivenessReporter)
+	_, routes := f.(Router)
+	if dh, ok := f.(DefaultHandler); ok {
+		// Implements each, but only those defined are of interest.
+		start, stop = dh.OnStart != nil, dh.OnStop != nil
+		ready, alive = dh.OnReady != nil, dh.OnAlive != nil
 	}
-	if _, ok := f.(LivenessReporter); ok {
-		log.Info().Msg("Function implements Alive")
+	for _, i := range []struct {
+		name string
+		ok   bool
+	}{{"Start", start}, {"Stop", stop}, {"Ready", ready}, {"Alive", alive}, {"Routes", routes}} {
+		if i.ok {
+			ii = append(ii, i.name)
+		}
 	}
+	return
 }
 
 // Start
-// Will stop when the context is canceled, a runtime error is encountered,
-// or an os interrupt or kill signal is received.
+// Will stop when the context is canceled, Stop is invoked, a runtime error
+// is encountered, or an os interrupt or kill signal is received.
 // By default it listens on the default address DefaultListenAddress.
-// This can be modified using the environment variable LISTEN_ADDRESS
+// This can be modified using the environment variable LISTEN_ADDRESS,
+// which may also be a Unix domain socket such as unix:///tmp/function.sock.
 func (s *Service) Start(ctx context.Context) (err error) {
+	s.running.Store(true)
+	defer close(s.done)
+	select {
+	case <-s.stopRequested:
+		log.Debug().Msg("function stopped before starting")
+		return
+	default:
+	}
+	if s.handlerErr != nil {
+		return s.handlerErr
+	}
+	if s.optionErr != nil {
+		return s.optionErr
+	}
+
 	// Get the listen address
-	// TODO: Currently this is an env var for legacy reasons. Logic should
-	// be moved into the generated mainfiles, and this setting be an optional
-	// functional option WithListenAddress(os.Getenv("LISTEN_ADDRESS"))
-	addr := listenAddress()
+	// That set using WithListenAddress, or else that of the environment.
+	// TODO: The environment is read here for legacy reasons.  Logic should
+	// be moved into the generated mainfiles, passing the setting using
+	// WithListenAddress(os.Getenv("LISTEN_ADDRESS"))
+	addr := s.listenAddress
+	if addr == "" {
+		addr = listenAddress()
+	}
 	log.Debug().Str("address", addr).Msg("function starting")
 
+	// Synchronous Start
+	// Optionally starts the function instance before listening, such that
+	// initialization errors are returned without ever accepting traffic.
+	if s.synchronousStart {
+		if err = s.startInstance(ctx); err != nil {
+			return
+		}
+	}
+
 	// Listen
-	if s.listener, err = net.Listen("tcp", addr); err != nil {
+	// Should listening fail once the instance has been started
+	// synchronously, it is stopped, such that resources acquired by its
+	// Start hook are released.
+	if s.listener, err = listen(addr); err != nil {
+		if s.synchronousStart {
+			err = s.stopInstance(err, nil)
+		}
 		return
 	}
+	if s.proxyPolicy != nil {
+		s.listener = &proxyproto.Listener{Listener: s.listener, Policy: s.proxyPolicy}
+	}
+	if s.adminServer != nil {
+		if err = s.serveAdmin(); err != nil {
+			s.listener.Close()
+			if s.synchronousStart {
+				err = s.stopInstance(err, nil)
+			}
+			return
+		}
+	}
+	s.logSummary()
+	if s.readyCallback != nil {
+		s.readyCallback(s.listener.Addr())
+	}
+
+	// Base Context
+	// Requests are handled with a context derived from the one passed to
+	// Start (and thus also to the function's Start hook), such that values
+	// placed on it are available to the function's handler.  Cancellation
+	// is not propagated, as in-flight requests should be allowed to complete
+	// during a graceful shutdown.  A BaseContext set using WithServerOptions
+	// is used instead if provided.
+	if s.BaseContext == nil {
+		baseCtx := context.WithoutCancel(ctx)
+		s.BaseContext = func(net.Listener) context.Context { return baseCtx }
+	}
 
 	// Start
 	// Starts the function instance in a separate routine, sending any
 	// runtime errors on s.stop.
-	if err = s.startInstance(ctx); err != nil {
-		return
+	if !s.synchronousStart {
+		if err = s.startInstance(ctx); err != nil {
+			return
+		}
 	}
 
 	// Wait for signals
 	// Interrupts and Kill signals
 	// sending a message on the s.stop channel if either are received.
-	s.handleSignals()
+	unregister := signals.Handle(s.stop, s.signalHandlers)
+	defer unregister()
+
+	// Workers
+	if s.workerPool != nil {
+		s.workerPool.start()
+	}
 
 	// Listen and serve
 	go func() {
@@ -126,6 +427,33 @@ func (s *Service) Start(ctx context.Context) (err error) {
 		}
 	}()
 
+	// Watch config
+	// Optionally restarts the function instance when its config changes.
+	// The watch is ended (and any restart in progress completed) before
+	// shutting down.
+	stopWatching := func() {}
+	if s.configWatchInterval > 0 {
+		watchCtx, cancel := context.WithCancel(ctx)
+		watchDone := make(chan struct{})
+		go func() {
+			s.watchConfig(watchCtx)
+			close(watchDone)
+		}()
+		stopWatching = func() {
+			cancel()
+			<-watchDone
+		}
+	}
+
+	// Watch for idleness
+	// Optionally shuts down once no requests have been handled for the
+	// idle timeout.
+	if s.idleTimeout > 0 {
+		idleCtx, cancel := context.WithCancel(ctx)
+		defer cancel()
+		go s.watchIdle(idleCtx)
+	}
+
 	log.Debug().Msg("waiting for stop signals or errors")
 	// Wait for either a context cancellation or a signal on the stop channel.
 	select {
@@ -135,10 +463,39 @@ func (s *Service) Start(ctx context.Context) (err error) {
 		}
 	case <-ctx.Done():
 		log.Debug().Msg("function canceled")
+	case <-s.stopRequested:
+		log.Debug().Msg("function stop requested")
 	}
+	stopWatching()
 	return s.shutdown(err)
 }
 
+// Stop gracefully shuts down the service as if the context passed to Start
+// were canceled, returning once it has stopped (and Start has returned) or
+// with the context's error if ctx is done first.  Any shutdown error is
+// returned by Start.
+//
+// Stop may be invoked more than once, and before Start, in which case it
+// returns immediately and Start, if later invoked, returns without starting.
+func (s *Service) Stop(ctx context.Context) error {
+	s.stopOnce.Do(func() { close(s.stopRequested) })
+	if !s.running.Load() {
+		return nil
+	}
+	select {
+	case <-s.done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Done returns a channel which is closed once the service has stopped and
+// Start has returned.
+func (s *Service) Done() <-chan struct{} {
+	return s.done
+}
+
 func listenAddress() string {
 	// If they are using the corret LISTEN_ADRESS, use this immediately
 	listenAddress := os.Getenv("LISTEN_ADDRESS")
@@ -177,29 +534,57 @@ func (s *Service) Addr() net.Addr {
 
 // Handle requests for the instance
 func (s *Service) Handle(w http.ResponseWriter, r *http.Request) {
-	s.f.Handle(w, r)
+	s.handle(w, r)
 }
 
 // Ready handles readiness checks.
 func (s *Service) Ready(w http.ResponseWriter, r *http.Request) {
+	if s.readyAfterStart && !s.isStarted() {
+		message := "function not yet started"
+		log.Debug().Msg(message)
+		s.observeReadiness(false, message)
+		s.writeHealth(w, http.StatusServiceUnavailable, message+"\n", nil)
+		return
+	}
+	if s.restarting.Load() {
+		message := "function restarting"
+		log.Debug().Msg(message)
+		s.observeReadiness(false, message)
+		s.writeHealth(w, http.StatusServiceUnavailable, message+"\n", nil)
+		return
+	}
+	if s.draining.Load() {
+		message := "function draining"
+		log.Debug().Msg(message)
+		s.observeReadiness(false, message)
+		s.writeHealth(w, http.StatusServiceUnavailable, message+"\n", nil)
+		return
+	}
 	if i, ok := s.f.(ReadinessReporter); ok {
 		ready, err := i.Ready(r.Context())
 		if err != nil {
 			message := "error checking readiness"
 			log.Debug().Err(err).Msg(message)
-			w.WriteHeader(http.StatusInternalServerError)
-			fmt.Fprint(w, "error checking readiness: ", err.Error())
+			s.observeReadiness(false, message)
+			s.writeHealth(w, http.StatusInternalServerError, "error checking readiness: "+err.Error(), nil)
 			return
 		}
 		if !ready {
 			message := "function not yet ready"
 			log.Debug().Msg(message)
-			w.WriteHeader(http.StatusServiceUnavailable)
-			fmt.Fprintln(w, message)
+			s.observeReadiness(false, message)
+			s.writeHealth(w, http.StatusServiceUnavailable, message+"\n", nil)
 			return
 		}
 	}
-	fmt.Fprintf(w, "READY")
+	results, ok := s.runHealthChecks(r.Context())
+	if !ok {
+		s.observeReadiness(false, "health check failed")
+		writeHealthChecks(w, results)
+		return
+	}
+	s.observeReadiness(true, "")
+	s.writeHealth(w, s.readyResponse.code, s.readyResponse.body, results)
 }
 
 // Alive handles liveness checks.
@@ -209,96 +594,104 @@ func (s *Service) Alive(w http.ResponseWriter, r *http.Request) {
 		if err != nil {
 			message := "error checking liveness"
 			log.Err(err).Msg(message)
-			w.WriteHeader(http.StatusInternalServerError)
-			fmt.Fprint(w, "error checking liveness: ", err.Error())
+			s.writeHealth(w, http.StatusInternalServerError, "error checking liveness: "+err.Error(), nil)
 			return
 		}
 		if !alive {
 			message := "function not alive"
 			log.Debug().Msg(message)
-			w.WriteHeader(http.StatusServiceUnavailable)
-			_, _ = w.Write([]byte(message))
+			s.writeHealth(w, http.StatusServiceUnavailable, message, nil)
 			return
 		}
 	}
-	fmt.Fprintf(w, "ALIVE")
+	s.writeHealth(w, s.aliveResponse.code, s.aliveResponse.body, nil)
 }
 
 func (s *Service) startInstance(ctx context.Context) error {
+	cfg, err := config.New()
+	if err != nil {
+		return err
+	}
+	s.cfg.Store(&cfg)
 	if i, ok := s.f.(Starter); ok {
-		cfg, err := newCfg()
-		if err != nil {
-			return err
+		if s.synchronousStart {
+			if err := i.Start(ctx, cfg); err != nil {
+				return err
+			}
+			close(s.started)
+			return nil
 		}
+		// Canceled should the service stop while Start is in progress.
+		startCtx, cancel := context.WithCancel(ctx)
+		s.startCancel, s.startDone = cancel, make(chan struct{})
 		go func() {
-			if err := i.Start(ctx, cfg); err != nil {
-				s.stop <- err
+			defer close(s.startDone)
+			if err := i.Start(startCtx, cfg); err != nil {
+				select {
+				case s.stop <- err:
+				case <-startCtx.Done(): // already stopping
+				}
+				return
 			}
+			close(s.started)
 		}()
 	} else {
 		log.Debug().Msg("function does not implement Start. Skipping")
+		close(s.started)
 	}
 	return nil
 }
 
-func (s *Service) handleSignals() {
-	sigs := make(chan os.Signal, 2)
-	signal.Notify(sigs)
-	go func() {
-		for {
-			sig := <-sigs
-			if sig == syscall.SIGINT || sig == syscall.SIGTERM {
-				log.Debug().Any("signal", sig).Msg("signal received")
-				s.stop <- nil
-			} else if runtime.GOOS == "linux" && sig == syscall.Signal(0x17) {
-				// Ignore SIGURG; signal 23 (0x17)
-				// See https://go.googlesource.com/proposal/+/master/design/24543-non-cooperative-preemption.md
-			}
-		}
-	}()
-}
-
-// readCfg returns a map representation of ./cfg
-// Empty map is returned if ./cfg does not exist.
-// Error is returned for invalid entries.
-// keys and values are space-trimmed.
-// Quotes are removed from values.
-func readCfg() (map[string]string, error) {
-	cfg := map[string]string{}
-
-	f, err := os.Open("cfg")
-	if err != nil {
-		log.Debug().Msg("no static config")
-		return cfg, nil
-	}
-	defer f.Close()
-
-	scanner := bufio.NewScanner(f)
-	i := 0
-	for scanner.Scan() {
-		i++
-		line := scanner.Text()
-		parts := strings.SplitN(line, "=", 2)
-		if len(parts) != 2 {
-			return cfg, fmt.Errorf("config line %v invalid: %v", i, line)
-		}
-		cfg[strings.TrimSpace(parts[0])] = strings.Trim(strings.TrimSpace(parts[1]), "\"")
+// cancelStart cancels the context passed to the function's Start hook if it
+// is still in progress, such as when a signal is received during a slow
+// initialization.
+func (s *Service) cancelStart() {
+	if s.startDone == nil {
+		return
+	}
+	select {
+	case <-s.startDone:
+	default:
+		log.Debug().Msg("canceling function start")
+		s.startCancel()
 	}
-	return cfg, scanner.Err()
 }
 
-// newCfg creates a final map of config values built from the static
-// values in `cfg` and all environment variables.
-func newCfg() (cfg map[string]string, err error) {
-	if cfg, err = readCfg(); err != nil {
+// waitStart waits up to InstanceStopTimeout for the function's Start hook to
+// return, such that Stop is never invoked while Start is in progress.
+func (s *Service) waitStart() {
+	if s.startDone == nil {
 		return
 	}
+	select {
+	case <-s.startDone:
+	case <-time.After(InstanceStopTimeout):
+		log.Warn().Msg("timed out waiting for function start to return")
+	}
+}
 
-	for _, e := range os.Environ() {
-		pair := strings.SplitN(e, "=", 2)
-		cfg[pair[0]] = pair[1]
+// isStarted returns true if the function instance has successfully started.
+func (s *Service) isStarted() bool {
+	select {
+	case <-s.started:
+		return true
+	default:
+		return false
 	}
-	return
+}
+
+// awaitStart wraps the handler such that requests received before the
+// function instance has started wait for it to do so, failing with a 503
+// should the request be canceled first.
+func (s *Service) awaitStart(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-s.started:
+			next.ServeHTTP(w, r)
+		case <-r.Context().Done():
+			http.Error(w, "function not yet started", http.StatusServiceUnavailable)
+		}
+	})
 }
 
 // shutdown is invoked when the stop channel receives a message and attempts to
@@ -308,34 +701,57 @@ func newCfg() (cfg map[string]string, err error) {
 // cancellation or sigint/sigkill.
 func (s *Service) shutdown(sourceErr error) (err error) {
 	log.Debug().Msg("function stopping")
-	var runtimeErr, instanceErr error
+	var runtimeErr error
+
+	// Cancel a Start hook still in progress, which is waited upon before
+	// the instance is stopped.
+	s.cancelStart()
 
 	// Start a graceful shutdown of the HTTP server
 	ctx, cancel := context.WithTimeout(context.Background(), ServerShutdownTimeout)
 	defer cancel()
 	runtimeErr = s.Shutdown(ctx)
+	_ = s.listener.Close() // removes a Unix socket even if not yet served
+	if s.workerPool != nil && runtimeErr == nil {
+		s.workerPool.stop() // only once no handlers remain active
+	}
+	// Allow hijacked (upgraded) connections, which are not closed by
+	// Shutdown, to close on their own within the same timeout.
+	if runtimeErr == nil {
+		runtimeErr = s.awaitHijacked(ctx)
+	}
+	// The admin server is shut down last, such that probes are answered
+	// while the function drains.
+	if s.adminServer != nil && s.adminListener != nil {
+		if err := s.adminServer.Shutdown(ctx); runtimeErr == nil {
+			runtimeErr = err
+		}
+	}
+
+	s.waitStart()
 
-	//  Start a graceful shutdown of the Function instance
+	return s.stopInstance(sourceErr, runtimeErr)
+}
+
+// stopInstance invokes the function's Stop hook followed by the shutdown
+// hooks, returning their errors along with those given.  It is invoked by
+// shutdown, and by Start should it fail once the instance is started.
+func (s *Service) stopInstance(sourceErr, runtimeErr error) error {
+	var instanceErr error
 	if i, ok := s.f.(Stopper); ok {
-		ctx, cancel = context.WithTimeout(context.Background(), InstanceStopTimeout)
+		ctx, cancel := context.WithTimeout(context.Background(), InstanceStopTimeout)
 		defer cancel()
 		instanceErr = i.Stop(ctx)
 	}
 
-	return collapseErrors("shutdown error", sourceErr, instanceErr, runtimeErr)
-}
+	hookErr := shutdown.RunHooks(s.shutdownHooks, InstanceStopTimeout)
 
-// collapseErrors returns the first non-nil error which it is passed,
-// printing the rest to log with the given prefix.
-func collapseErrors(msg string, ee ...error) (err error) {
-	for _, e := range ee {
-		if e != nil {
-			if err == nil {
-				err = e
-			} else {
-				log.Error().Err(e).Msg(msg)
-			}
-		}
-	}
-	return
+	return shutdown.Errors(instanceErr, sourceErr, runtimeErr, hookErr)
 }
+
+// ShutdownError is returned by Start when more than one error occurs in
+// stopping the service.  Errors are ordered by precedence: that of the
+// function's Stop hook, followed by that which caused the service to stop,
+// followed by any of the runtime itself.  errors.Is and errors.As consider
+// each of them.
+type ShutdownError = shutdown.Error
@@ -1,15 +1,39 @@
 package http
 
 import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
 	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"net"
 	"net/http"
+	"net/http/httptest"
+	"net/http/httptrace"
 	"os"
+	"os/signal"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"syscall"
 	"testing"
 	"time"
 
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/rs/zerolog"
+	zlog "github.com/rs/zerolog/log"
+
 	"knative.dev/func-go/http/mock"
+	"knative.dev/func-go/internal/logs"
 )
 
 // TestStart_Invoked ensures that the Start method of a function is invoked
@@ -420,3 +444,2686 @@ func TestHandle_WithContext(t *testing.T) {
 	}()
 	t.Log("legacy static handler signature accepted.  see func tests for confirmation of invocation")
 }
+
+// TestHandle_StartContext ensures that values on the context passed to Start
+// are available to both the function's Start hook and its handler.
+func TestHandle_StartContext(t *testing.T) {
+	t.Setenv("LISTEN_ADDRESS", "127.0.0.1:") // use an OS-chosen port
+
+	type ctxKey struct{}
+
+	var (
+		ctx, cancel = context.WithCancel(context.WithValue(context.Background(), ctxKey{}, "example"))
+		errCh       = make(chan error)
+		startCh     = make(chan any)
+		timeoutCh   = time.After(500 * time.Millisecond)
+		onStart     = func(ctx context.Context, _ map[string]string) error {
+			if v := ctx.Value(ctxKey{}); v != "example" {
+				t.Errorf("Start did not receive context value.  got %v", v)
+			}
+			startCh <- true
+			return nil
+		}
+		onHandle = func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, r.Context().Value(ctxKey{}))
+		}
+	)
+	defer cancel()
+
+	f := &mock.Function{OnStart: onStart, OnHandle: onHandle}
+	service := New(f)
+
+	go func() {
+		if err := service.Start(ctx); err != nil {
+			errCh <- err
+		}
+	}()
+
+	select {
+	case <-timeoutCh:
+		t.Fatal("function failed to start")
+	case err := <-errCh:
+		t.Fatal(err)
+	case <-startCh:
+	}
+
+	resp, err := http.Get("http://" + service.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(body) != "example" {
+		t.Fatalf("handler did not receive context value.  got %q", string(body))
+	}
+}
+
+// startService starts a Service for the given function on an OS-chosen port,
+// returning once the function's Start hook has been invoked.  The service is
+// stopped when the test completes.
+func startService(t *testing.T, f *mock.Function, options ...Option) *Service {
+	t.Helper()
+	options = append([]Option{WithListenAddress("127.0.0.1:0")}, options...) // an OS-chosen port
+
+	var (
+		ctx, cancel = context.WithCancel(context.Background())
+		startCh     = make(chan any, 1)
+		errCh       = make(chan error, 1)
+		onStart     = f.OnStart
+	)
+	f.OnStart = func(ctx context.Context, cfg map[string]string) error {
+		select {
+		case startCh <- true:
+		default:
+		}
+		if onStart != nil {
+			return onStart(ctx, cfg)
+		}
+		return nil
+	}
+
+	service := New(f, options...)
+	go func() {
+		errCh <- service.Start(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		select {
+		case <-errCh:
+		case <-time.After(time.Second):
+			t.Error("service failed to stop")
+		}
+	})
+
+	select {
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("function failed to start")
+	case err := <-errCh:
+		t.Fatal(err)
+	case <-startCh:
+	}
+	return service
+}
+
+// get the given path from the service, returning the response and its body.
+func get(t *testing.T, s *Service, path string) (*http.Response, string) {
+	t.Helper()
+	resp, err := http.Get("http://" + s.Addr().String() + path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp, string(body)
+}
+
+// TestMiddleware ensures that middleware wraps the function's handler in
+// the order registered, and is not applied to the health endpoints.
+func TestMiddleware(t *testing.T) {
+	var (
+		mu    sync.Mutex
+		calls []string
+		call  = func(name string) {
+			mu.Lock()
+			defer mu.Unlock()
+			calls = append(calls, name)
+		}
+		reset = func() string {
+			mu.Lock()
+			defer mu.Unlock()
+			s := fmt.Sprint(calls)
+			calls = nil
+			return s
+		}
+		mw = func(name string) Middleware {
+			return func(next http.Handler) http.Handler {
+				return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					call(name)
+					next.ServeHTTP(w, r)
+				})
+			}
+		}
+		onHandle = func(w http.ResponseWriter, _ *http.Request) {
+			call("handler")
+		}
+	)
+
+	f := &mock.Function{OnHandle: onHandle}
+	service := startService(t, f, WithMiddleware(mw("first"), mw("second")), WithMiddleware(mw("third")))
+
+	get(t, service, "/")
+	if c := reset(); c != "[first second third handler]" {
+		t.Fatalf("unexpected middleware invocation order: %v", c)
+	}
+
+	get(t, service, "/health/readiness")
+	get(t, service, "/health/liveness")
+	if c := reset(); c != "[]" {
+		t.Fatalf("middleware unexpectedly applied to health endpoints: %v", c)
+	}
+}
+
+// TestRateLimit ensures that requests exceeding the rate limit are rejected
+// with a 429 and a Retry-After header, and that health endpoints bypass the
+// limit.
+func TestRateLimit(t *testing.T) {
+	f := &mock.Function{}
+	service := startService(t, f, WithRateLimit(0.001, 2))
+
+	for i := 0; i < 2; i++ {
+		if resp, _ := get(t, service, "/"); resp.StatusCode != http.StatusOK {
+			t.Fatalf("request %v within burst: unexpected http status code: %v", i, resp.StatusCode)
+		}
+	}
+	resp, _ := get(t, service, "/")
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("expected %v, got %v", http.StatusTooManyRequests, resp.StatusCode)
+	}
+	if resp.Header.Get("Retry-After") == "" {
+		t.Fatal("expected a Retry-After header")
+	}
+
+	if resp, _ := get(t, service, "/health/readiness"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("readiness check was rate limited: %v", resp.StatusCode)
+	}
+}
+
+// TestRateLimit_Key ensures that rate limits are applied per-key when a key
+// function is provided.
+func TestRateLimit_Key(t *testing.T) {
+	key := func(r *http.Request) string { return r.URL.Query().Get("client") }
+
+	f := &mock.Function{}
+	service := startService(t, f, WithRateLimit(0.001, 1), WithRateLimitKey(key))
+
+	if resp, _ := get(t, service, "/?client=a"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("client a: unexpected http status code: %v", resp.StatusCode)
+	}
+	if resp, _ := get(t, service, "/?client=a"); resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("client a: expected %v, got %v", http.StatusTooManyRequests, resp.StatusCode)
+	}
+	if resp, _ := get(t, service, "/?client=b"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("client b: unexpected http status code: %v", resp.StatusCode)
+	}
+}
+
+// TestRateLimit_Keys ensures that the limiters retained are bounded to
+// those of the most recently seen keys, and that a burst which is not
+// positive is rejected.
+func TestRateLimit_Keys(t *testing.T) {
+	l := newRateLimiter(0.001, 1)
+	l.max = 2
+
+	a := l.get("a")
+	l.get("b")
+	if l.get("a") != a {
+		t.Fatal("expected the limiter of a recent key to be retained")
+	}
+	l.get("c") // evicts b, the least recently seen
+	if len(l.limiters) != 2 || l.recent.Len() != 2 {
+		t.Fatalf("expected 2 limiters, got %v", len(l.limiters))
+	}
+	if _, ok := l.limiters["b"]; ok {
+		t.Fatal("expected the limiter of the least recently seen key to be evicted")
+	}
+	if l.get("a") != a {
+		t.Fatal("expected the limiter of a recent key to be retained")
+	}
+
+	for _, burst := range []int{0, -1} {
+		if err := New(&mock.Function{}, WithRateLimit(1, burst)).Start(context.Background()); err == nil {
+			t.Fatalf("expected an error for a burst of %v", burst)
+		}
+	}
+}
+
+// TestWorkerPool ensures that handler parallelism is bounded by the number
+// of workers, and that requests beyond those which can be queued are
+// rejected with a 503.
+func TestWorkerPool(t *testing.T) {
+	var (
+		active, maxActive int32
+		mu                sync.Mutex
+		release           = make(chan any)
+		invokedCh         = make(chan any, 10)
+	)
+	onHandle := func(w http.ResponseWriter, _ *http.Request) {
+		mu.Lock()
+		active++
+		if active > maxActive {
+			maxActive = active
+		}
+		mu.Unlock()
+		invokedCh <- true
+		<-release
+		mu.Lock()
+		active--
+		mu.Unlock()
+	}
+
+	f := &mock.Function{OnHandle: onHandle}
+	service := startService(t, f, WithWorkerPool(1))
+
+	// One request occupies the single worker, and a second is queued.
+	results := make(chan int, 2)
+	for i := 0; i < 2; i++ {
+		go func() {
+			resp, err := http.Get("http://" + service.Addr().String())
+			if err != nil {
+				results <- 0
+				return
+			}
+			resp.Body.Close()
+			results <- resp.StatusCode
+		}()
+		if i == 0 {
+			<-invokedCh // ensure the first is being handled before queueing
+		}
+	}
+	time.Sleep(100 * time.Millisecond) // allow the second to be queued
+
+	// A third overflows the queue.
+	if resp, _ := get(t, service, "/"); resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("expected %v, got %v", http.StatusServiceUnavailable, resp.StatusCode)
+	}
+
+	close(release)
+	for i := 0; i < 2; i++ {
+		if code := <-results; code != http.StatusOK {
+			t.Fatalf("unexpected http status code: %v", code)
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if maxActive != 1 {
+		t.Fatalf("expected at most 1 concurrent invocation, got %v", maxActive)
+	}
+}
+
+// TestWorkerPool_Panic ensures that a panic on a worker is recovered and
+// responded to with a 500, leaving the worker to serve further requests,
+// and that a pool of no workers is rejected.
+func TestWorkerPool_Panic(t *testing.T) {
+	f := &mock.Function{OnHandle: func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/panic" {
+			panic("boom")
+		}
+		fmt.Fprint(w, "OK")
+	}}
+	service := startService(t, f, WithWorkerPool(1))
+	if resp, _ := get(t, service, "/panic"); resp.StatusCode != http.StatusInternalServerError {
+		t.Fatalf("expected a 500 for a panic, got %v", resp.StatusCode)
+	}
+	if resp, body := get(t, service, "/"); resp.StatusCode != http.StatusOK || body != "OK" {
+		t.Fatalf("expected the worker to survive the panic, got %v %q", resp.StatusCode, body)
+	}
+
+	for _, n := range []int{0, -1} {
+		if err := New(&mock.Function{}, WithWorkerPool(n)).Start(context.Background()); err == nil {
+			t.Fatalf("expected an error for a pool of %v workers", n)
+		}
+	}
+}
+
+// TestReadyAfterStart ensures that when enabled, readiness is reported as
+// 503 until the function's Start hook returns.
+func TestReadyAfterStart(t *testing.T) {
+	release := make(chan any)
+	f := &mock.Function{OnStart: func(context.Context, map[string]string) error {
+		<-release
+		return nil
+	}}
+	service := startService(t, f, WithReadyAfterStart())
+
+	if resp, _ := get(t, service, "/health/readiness"); resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("expected %v before Start returned, got %v", http.StatusServiceUnavailable, resp.StatusCode)
+	}
+
+	close(release)
+	deadline := time.Now().Add(500 * time.Millisecond)
+	for {
+		resp, _ := get(t, service, "/health/readiness")
+		if resp.StatusCode == http.StatusOK {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %v after Start returned, got %v", http.StatusOK, resp.StatusCode)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// TestStart_Synchronous ensures that when enabled, an error from the
+// function's Start hook is returned from Start before the service listens.
+// By default the Start hook runs after the service is listening.
+func TestStart_Synchronous(t *testing.T) {
+	t.Setenv("LISTEN_ADDRESS", "127.0.0.1:") // use an OS-chosen port
+
+	for _, tc := range []struct {
+		name      string
+		options   []Option
+		listening bool
+	}{
+		{name: "synchronous", options: []Option{WithSynchronousStart()}, listening: false},
+		{name: "asynchronous", listening: true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			startErr := errors.New("example start error")
+			f := &mock.Function{OnStart: func(context.Context, map[string]string) error {
+				return startErr
+			}}
+			service := New(f, tc.options...)
+
+			errCh := make(chan error)
+			go func() {
+				errCh <- service.Start(context.Background())
+			}()
+
+			select {
+			case <-time.After(500 * time.Millisecond):
+				t.Fatal("Start did not return the start error")
+			case err := <-errCh:
+				if !errors.Is(err, startErr) {
+					t.Fatalf("unexpected error: %v", err)
+				}
+			}
+			if listening := service.Addr() != nil; listening != tc.listening {
+				t.Fatalf("expected listening %v, got %v", tc.listening, listening)
+			}
+		})
+	}
+}
+
+// TestStart_SynchronousListenError ensures that when the service fails to
+// listen once the function has been started synchronously, its Stop hook
+// and the shutdown hooks are invoked, such that its resources are released.
+func TestStart_SynchronousListenError(t *testing.T) {
+	taken, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer taken.Close()
+
+	var stopped, hooked bool
+	f := &mock.Function{OnStop: func(context.Context) error {
+		stopped = true
+		return nil
+	}}
+	service := New(f, WithSynchronousStart(), WithListenAddress(taken.Addr().String()),
+		WithShutdownHook(func(context.Context) error {
+			hooked = true
+			return nil
+		}))
+	if err := service.Start(context.Background()); err == nil {
+		t.Fatal("expected an error listening on an address in use")
+	}
+	if !stopped || !hooked {
+		t.Fatalf("expected the function stopped (%v) and hooks run (%v)", stopped, hooked)
+	}
+}
+
+// withConfigWatchInterval enables restart on config change, checking for
+// changes at the given interval.
+func withConfigWatchInterval(d time.Duration) Option {
+	return func(s *Service) {
+		s.configWatchInterval = d
+	}
+}
+
+// TestRestartOnConfigChange ensures that when enabled, a change to the
+// function's config results in its Stop hook followed by its Start hook
+// being invoked with the new config, while the listener remains bound.
+func TestRestartOnConfigChange(t *testing.T) {
+	wd, _ := os.Getwd() // may not exist if removed by another test
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.Chdir(wd) })
+	if err := os.WriteFile("cfg", []byte("EXAMPLE=v1"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	events := make(chan string, 10)
+	f := &mock.Function{
+		OnStart: func(_ context.Context, cfg map[string]string) error {
+			events <- "start " + cfg["EXAMPLE"]
+			return nil
+		},
+		OnStop: func(context.Context) error {
+			events <- "stop"
+			return nil
+		},
+	}
+	service := startService(t, f, withConfigWatchInterval(10*time.Millisecond))
+	addr := service.Addr().String()
+
+	next := func() string {
+		select {
+		case e := <-events:
+			return e
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for lifecycle event")
+			return ""
+		}
+	}
+	if e := next(); e != "start v1" {
+		t.Fatalf("unexpected lifecycle event %q", e)
+	}
+
+	if err := os.WriteFile("cfg", []byte("EXAMPLE=v2"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	if e := next(); e != "stop" {
+		t.Fatalf("expected stop, got %q", e)
+	}
+	if e := next(); e != "start v2" {
+		t.Fatalf("expected start with new config, got %q", e)
+	}
+
+	if service.Addr().String() != addr {
+		t.Fatalf("listener changed from %v to %v", addr, service.Addr())
+	}
+	resp, err := http.Get("http://" + addr + "/health/liveness")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected http status code after restart: %v", resp.StatusCode)
+	}
+}
+
+// TestRestartOnConfigChange_Requests ensures that a restart awaits the
+// requests in-flight before stopping the function, and that requests
+// received while restarting wait for the function to have started again,
+// and are then given the new config.
+func TestRestartOnConfigChange_Requests(t *testing.T) {
+	wd, _ := os.Getwd() // may not exist if removed by another test
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.Chdir(wd) })
+	if err := os.WriteFile("cfg", []byte("EXAMPLE=v1"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+
+	var (
+		events       = make(chan string, 10)
+		release      = make(chan any)
+		releaseStart = make(chan any)
+	)
+	f := &mock.Function{
+		OnStart: func(_ context.Context, cfg map[string]string) error {
+			events <- "start " + cfg["EXAMPLE"]
+			if cfg["EXAMPLE"] == "v2" {
+				<-releaseStart
+			}
+			return nil
+		},
+		OnStop: func(context.Context) error {
+			events <- "stop"
+			return nil
+		},
+		OnHandle: func(_ http.ResponseWriter, r *http.Request) {
+			events <- "handle " + r.URL.Path + " " + ConfigFromContext(r.Context())["EXAMPLE"]
+			if r.URL.Path == "/slow" {
+				<-release
+			}
+		},
+	}
+	service := startService(t, f, withConfigWatchInterval(10*time.Millisecond))
+
+	next := func() string {
+		t.Helper()
+		select {
+		case e := <-events:
+			return e
+		case <-time.After(time.Second):
+			t.Fatal("timed out waiting for event")
+			return ""
+		}
+	}
+	none := func() {
+		t.Helper()
+		select {
+		case e := <-events:
+			t.Fatalf("unexpected event %q", e)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+	getAsync := func(path string) {
+		go func() {
+			if resp, err := http.Get("http://" + service.Addr().String() + path); err == nil {
+				resp.Body.Close()
+			}
+		}()
+	}
+	if e := next(); e != "start v1" {
+		t.Fatalf("unexpected event %q", e)
+	}
+
+	getAsync("/slow")
+	if e := next(); e != "handle /slow v1" {
+		t.Fatalf("unexpected event %q", e)
+	}
+	if err := os.WriteFile("cfg", []byte("EXAMPLE=v2"), os.ModePerm); err != nil {
+		t.Fatal(err)
+	}
+	none() // not stopped while a request is in-flight
+	getAsync("/held")
+	none() // not handled while restarting
+	close(release)
+	if e := next(); e != "stop" {
+		t.Fatalf("expected stop once the request completed, got %q", e)
+	}
+	if e := next(); e != "start v2" {
+		t.Fatalf("expected start with new config, got %q", e)
+	}
+
+	getAsync("/")
+	none() // not handled while starting
+	close(releaseStart)
+	handled := []string{next(), next()}
+	slices.Sort(handled)
+	if handled[0] != "handle / v2" || handled[1] != "handle /held v2" {
+		t.Fatalf("expected the requests handled with the new config once started, got %q", handled)
+	}
+}
+
+// TestStreamHandler ensures that events sent by a StreamHandler are flushed
+// to the client as they are sent, and that the stream is not cut off by the
+// server's write timeout.
+func TestStreamHandler(t *testing.T) {
+	release := make(chan struct{})
+	stream := StreamHandler(func(r *http.Request, send func(Event) error) error {
+		if err := send(Event{Data: "first"}); err != nil {
+			return err
+		}
+		select {
+		case <-release:
+		case <-r.Context().Done():
+			return r.Context().Err()
+		}
+		time.Sleep(100 * time.Millisecond) // exceed the write timeout
+		if err := send(Event{Data: "second\nline"}); err != nil {
+			return err
+		}
+		return send(Event{ID: "3", Event: "done", Data: "third"})
+	})
+	f := &mock.Function{OnHandle: stream.Handle}
+	service := startService(t, f, WithWriteTimeout(50*time.Millisecond))
+
+	resp, err := http.Get("http://" + service.Addr().String() + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Fatalf("unexpected content type %q", ct)
+	}
+
+	// readEvent reads lines up to and including the blank line which
+	// terminates an event.
+	r := bufio.NewReader(resp.Body)
+	readEvent := func() string {
+		t.Helper()
+		var event string
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				t.Fatalf("error reading event: %v", err)
+			}
+			if line == "\n" {
+				return event
+			}
+			event += line
+		}
+	}
+
+	// The first event must be received before the handler is released,
+	// which is only possible if it was flushed.
+	if e := readEvent(); e != "data: first\n" {
+		t.Fatalf("unexpected first event %q", e)
+	}
+	close(release)
+	if e := readEvent(); e != "data: second\ndata: line\n" {
+		t.Fatalf("unexpected second event %q", e)
+	}
+	if e := readEvent(); e != "id: 3\nevent: done\ndata: third\n" {
+		t.Fatalf("unexpected third event %q", e)
+	}
+	if rest, err := io.ReadAll(r); err != nil || len(rest) != 0 {
+		t.Fatalf("unexpected end of stream %q: %v", rest, err)
+	}
+}
+
+// TestUpgrade ensures that a handler can hijack the connection of an upgrade
+// request, that the hijacked connection is exempt from the server's timeouts
+// when enabled, and that shutdown allows it to close on its own.
+func TestUpgrade(t *testing.T) {
+	t.Setenv("LISTEN_ADDRESS", "127.0.0.1:") // use an OS-chosen port
+
+	var (
+		ctx, cancel = context.WithCancel(context.Background())
+		startCh     = make(chan any)
+		errCh       = make(chan error, 1)
+		onStart     = func(_ context.Context, _ map[string]string) error {
+			startCh <- true
+			return nil
+		}
+		// onHandle upgrades to a protocol which echoes lines.
+		onHandle = func(w http.ResponseWriter, r *http.Request) {
+			conn, brw, err := http.NewResponseController(w).Hijack()
+			if err != nil {
+				t.Errorf("unable to hijack connection: %v", err)
+				return
+			}
+			defer conn.Close()
+			_, _ = brw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+			_ = brw.Flush()
+			for {
+				line, err := brw.ReadString('\n')
+				if err != nil {
+					return
+				}
+				_, _ = brw.WriteString(line)
+				_ = brw.Flush()
+			}
+		}
+		withShortTimeouts = func(s *Service) {
+			s.ReadTimeout = 50 * time.Millisecond
+			s.WriteTimeout = 50 * time.Millisecond
+		}
+	)
+	f := &mock.Function{OnStart: onStart, OnHandle: onHandle}
+	service := New(f, WithoutUpgradeTimeouts(), withShortTimeouts)
+	go func() {
+		errCh <- service.Start(ctx)
+	}()
+	defer cancel()
+	select {
+	case <-startCh:
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("function failed to notify of start")
+	}
+
+	conn, err := net.Dial("tcp", service.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	r := bufio.NewReader(conn)
+	_, _ = conn.Write([]byte("GET / HTTP/1.1\r\nHost: localhost\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n"))
+	resp, err := http.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		t.Fatalf("unexpected http status code: %v", resp.StatusCode)
+	}
+
+	echo := func(msg string) {
+		t.Helper()
+		if _, err := conn.Write([]byte(msg + "\n")); err != nil {
+			t.Fatal(err)
+		}
+		line, err := r.ReadString('\n')
+		if err != nil {
+			t.Fatal(err)
+		}
+		if line != msg+"\n" {
+			t.Fatalf("unexpected echo %q", line)
+		}
+	}
+	time.Sleep(100 * time.Millisecond) // exceed the server's timeouts
+	echo("hello")
+
+	// Shutdown waits for the hijacked connection to be closed.
+	cancel()
+	select {
+	case err := <-errCh:
+		t.Fatalf("service stopped with an open hijacked connection: %v", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	echo("still open")
+	conn.Close()
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("service failed to stop after the hijacked connection closed")
+	}
+}
+
+// TestRouteTimeout ensures that the context of a request is canceled after
+// the timeout of the route it matches, or the default timeout otherwise.
+func TestRouteTimeout(t *testing.T) {
+	// onHandle reports the timeout of the request, and whether it was
+	// canceled before completing its work.
+	onHandle := func(w http.ResponseWriter, r *http.Request) {
+		dl, _ := r.Context().Deadline()
+		select {
+		case <-r.Context().Done():
+			fmt.Fprint(w, "canceled")
+		case <-time.After(100 * time.Millisecond):
+			fmt.Fprint(w, "completed")
+		}
+		if time.Until(dl) > 500*time.Millisecond {
+			fmt.Fprint(w, " long")
+		}
+	}
+	f := &mock.Function{OnHandle: onHandle}
+	service := startService(t, f,
+		WithRequestTimeout(20*time.Millisecond),
+		WithRouteTimeout("/fast", 10*time.Millisecond),
+		WithRouteTimeout("/heavy/", 10*time.Second))
+
+	tests := []struct {
+		path string
+		want string
+	}{
+		{"/", "canceled"},
+		{"/fast", "canceled"},
+		{"/heavy/report", "completed long"},
+	}
+	for _, test := range tests {
+		if _, body := get(t, service, test.path); body != test.want {
+			t.Errorf("expected %v to be %q, got %q", test.path, test.want, body)
+		}
+	}
+}
+
+// TestDefaultHandler_Lifecycle ensures that the lifecycle hooks defined on a
+// static function's DefaultHandler are invoked.
+func TestDefaultHandler_Lifecycle(t *testing.T) {
+	t.Setenv("LISTEN_ADDRESS", "127.0.0.1:") // use an OS-chosen port
+
+	var (
+		ctx, cancel = context.WithCancel(context.Background())
+		startCh     = make(chan any, 1)
+		stopCh      = make(chan any, 1)
+		errCh       = make(chan error, 1)
+	)
+	f := DefaultHandler{
+		Handler: func(context.Context, http.ResponseWriter, *http.Request) {},
+		OnStart: func(context.Context, map[string]string) error {
+			startCh <- true
+			return nil
+		},
+		OnStop: func(context.Context) error {
+			stopCh <- true
+			return nil
+		},
+		OnReady: func(context.Context) (bool, error) {
+			return false, nil
+		},
+	}
+	service := New(f)
+	go func() {
+		errCh <- service.Start(ctx)
+	}()
+
+	select {
+	case <-startCh:
+	case err := <-errCh:
+		t.Fatal(err)
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("OnStart was not invoked")
+	}
+	if resp, _ := get(t, service, "/health/readiness"); resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("expected OnReady to report not ready, got %v", resp.StatusCode)
+	}
+	if resp, _ := get(t, service, "/health/liveness"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected alive without OnAlive, got %v", resp.StatusCode)
+	}
+
+	cancel()
+	select {
+	case err := <-errCh:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("service failed to stop")
+	}
+	select {
+	case <-stopCh:
+	default:
+		t.Fatal("OnStop was not invoked")
+	}
+}
+
+// TestHealthCheck ensures that a failing health check results in a 503 from
+// the readiness endpoint with a JSON body listing the status of each check.
+func TestHealthCheck(t *testing.T) {
+	var healthy atomic.Bool
+
+	f := &mock.Function{}
+	service := startService(t, f,
+		WithHealthCheck("cache", func(context.Context) error { return nil }),
+		WithHealthCheck("db", func(context.Context) error {
+			if !healthy.Load() {
+				return errors.New("connection refused")
+			}
+			return nil
+		}))
+
+	resp, body := get(t, service, "/health/readiness")
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("unexpected http status code: %v", resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+		t.Fatalf("unexpected content type %q", ct)
+	}
+	var payload struct {
+		Checks map[string]checkResult `json:"checks"`
+	}
+	if err := json.Unmarshal([]byte(body), &payload); err != nil {
+		t.Fatal(err)
+	}
+	if payload.Checks["cache"].Status != "ok" {
+		t.Errorf("unexpected cache check %+v", payload.Checks["cache"])
+	}
+	if c := payload.Checks["db"]; c.Status != "error" || c.Error != "connection refused" {
+		t.Errorf("unexpected db check %+v", c)
+	}
+
+	healthy.Store(true)
+	if resp, _ := get(t, service, "/health/readiness"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected http status code once healthy: %v", resp.StatusCode)
+	}
+}
+
+// TestJSONHealth ensures that when enabled, the health endpoints respond
+// with JSON while retaining their status codes.
+func TestJSONHealth(t *testing.T) {
+	release := make(chan struct{})
+	f := &mock.Function{OnStart: func(context.Context, map[string]string) error {
+		<-release
+		return nil
+	}}
+	service := startService(t, f, WithJSONHealth(), WithReadyAfterStart(),
+		WithHealthCheck("db", func(context.Context) error { return nil }))
+
+	health := func(path string) (int, healthStatus) {
+		t.Helper()
+		resp, body := get(t, service, path)
+		if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+			t.Fatalf("unexpected content type %q", ct)
+		}
+		var status healthStatus
+		if err := json.Unmarshal([]byte(body), &status); err != nil {
+			t.Fatal(err)
+		}
+		if status.Timestamp.IsZero() {
+			t.Fatal("expected a timestamp")
+		}
+		return resp.StatusCode, status
+	}
+
+	code, status := health("/health/readiness")
+	if code != http.StatusServiceUnavailable || status.Status != "unavailable" || status.Message != "function not yet started" {
+		t.Fatalf("unexpected readiness before start %v %+v", code, status)
+	}
+	close(release)
+	for deadline := time.Now().Add(500 * time.Millisecond); time.Now().Before(deadline); {
+		if code, status = health("/health/readiness"); code == http.StatusOK {
+			break
+		}
+		time.Sleep(10 * time.Millisecond) // allow Start to return
+	}
+	if code != http.StatusOK || status.Status != "ok" || status.Checks["db"].Status != "ok" {
+		t.Fatalf("unexpected readiness %v %+v", code, status)
+	}
+	code, status = health("/health/liveness")
+	if code != http.StatusOK || status.Status != "ok" {
+		t.Fatalf("unexpected liveness %v %+v", code, status)
+	}
+}
+
+// TestAdminDrain ensures that draining via the admin endpoint reports not
+// ready and refuses new requests while allowing those in-flight to complete,
+// and that draining can be ceased.
+func TestAdminDrain(t *testing.T) {
+	var (
+		invoked  = make(chan any)
+		release  = make(chan any)
+		onHandle = func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path == "/slow" {
+				invoked <- true
+				<-release
+			}
+			fmt.Fprint(w, "OK")
+		}
+	)
+	f := &mock.Function{OnHandle: onHandle}
+	service := startService(t, f, WithAdminEndpoints(), WithAdminToken("secret"))
+	url := "http://" + service.Addr().String()
+
+	admin := func(method string) int {
+		t.Helper()
+		req, err := http.NewRequest(method, url+"/admin/drain", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer secret")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return resp.StatusCode
+	}
+
+	// An in-flight request
+	inflight := make(chan string)
+	go func() {
+		resp, err := http.Get(url + "/slow")
+		if err != nil {
+			inflight <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		inflight <- string(body)
+	}()
+	<-invoked
+
+	if code := admin(http.MethodPost); code != http.StatusAccepted {
+		t.Fatalf("unexpected drain status code: %v", code)
+	}
+	if resp, _ := get(t, service, "/health/readiness"); resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("expected not ready while draining, got %v", resp.StatusCode)
+	}
+	if resp, _ := get(t, service, "/"); resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("expected new requests refused while draining, got %v", resp.StatusCode)
+	}
+	close(release)
+	if body := <-inflight; body != "OK" {
+		t.Fatalf("in-flight request did not complete: %v", body)
+	}
+
+	if code := admin(http.MethodDelete); code != http.StatusOK {
+		t.Fatalf("unexpected undrain status code: %v", code)
+	}
+	if resp, _ := get(t, service, "/health/readiness"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected ready once no longer draining, got %v", resp.StatusCode)
+	}
+}
+
+// TestAdminToken ensures that when a token is configured, admin requests
+// without it are forbidden, and that a token is required (even of requests
+// from a loopback address) unless the endpoints are served on the admin
+// listener.
+func TestAdminToken(t *testing.T) {
+	service := startService(t, &mock.Function{}, WithAdminEndpoints(), WithAdminToken("secret"))
+
+	drainAt := func(addr net.Addr, token string) int {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodPost, "http://"+addr.String()+"/admin/drain", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return resp.StatusCode
+	}
+	drain := func(token string) int {
+		t.Helper()
+		return drainAt(service.Addr(), token)
+	}
+	if code := drain(""); code != http.StatusForbidden {
+		t.Fatalf("expected forbidden without a token, got %v", code)
+	}
+	if code := drain("wrong"); code != http.StatusForbidden {
+		t.Fatalf("expected forbidden with the wrong token, got %v", code)
+	}
+	if code := drain("secret"); code != http.StatusAccepted {
+		t.Fatalf("expected accepted with the token, got %v", code)
+	}
+
+	service = startService(t, &mock.Function{}, WithAdminEndpoints())
+	if code := drainAt(service.Addr(), ""); code != http.StatusForbidden {
+		t.Fatalf("expected forbidden without a token configured, got %v", code)
+	}
+	service = startService(t, &mock.Function{}, WithAdminEndpoints(), WithAdminAddress("127.0.0.1:0"))
+	if code := drainAt(service.AdminAddr(), ""); code != http.StatusAccepted {
+		t.Fatalf("expected accepted on the admin listener, got %v", code)
+	}
+}
+
+// TestProfiling ensures that the profiling endpoints are served only when
+// enabled, and do not interfere with the function.
+func TestProfiling(t *testing.T) {
+	onHandle := func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "function")
+	}
+	get := func(url string) (int, string) {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodGet, url, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer secret")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp.StatusCode, string(body)
+	}
+
+	service := startService(t, &mock.Function{OnHandle: onHandle}, WithProfiling(), WithAdminToken("secret"))
+	url := "http://" + service.Addr().String()
+	if code, body := get(url + "/debug/pprof/"); code != http.StatusOK || !strings.Contains(body, "goroutine") {
+		t.Fatalf("expected the profile index, got %v %q", code, body)
+	}
+	if code, _ := get(url + "/debug/pprof/heap"); code != http.StatusOK {
+		t.Fatalf("expected a heap profile, got %v", code)
+	}
+	if _, body := get(url + "/"); body != "function" {
+		t.Fatalf("expected the function to handle /, got %q", body)
+	}
+
+	// Disabled by default
+	service = startService(t, &mock.Function{OnHandle: onHandle})
+	if _, body := get("http://" + service.Addr().String() + "/debug/pprof/"); body != "function" {
+		t.Fatalf("expected the function to handle /debug/pprof/ by default, got %q", body)
+	}
+}
+
+// TestIdleShutdown ensures that the service shuts down once it has not
+// handled a request for the idle duration, and that requests to the health
+// endpoints do not count as activity.
+func TestIdleShutdown(t *testing.T) {
+	t.Setenv("LISTEN_ADDRESS", "127.0.0.1:") // use an OS-chosen port
+	const idle = 100 * time.Millisecond
+
+	var (
+		startCh = make(chan any)
+		errCh   = make(chan error, 1)
+		onStart = func(context.Context, map[string]string) error {
+			startCh <- true
+			return nil
+		}
+	)
+	service := New(&mock.Function{OnStart: onStart}, WithIdleShutdown(idle))
+	go func() {
+		errCh <- service.Start(context.Background())
+	}()
+	select {
+	case <-startCh:
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("function failed to notify of start")
+	}
+	url := "http://" + service.Addr().String()
+
+	// Requests for longer than the idle duration keep the service running.
+	for i := 0; i < 5; i++ {
+		resp, err := http.Get(url + "/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		_, _ = io.Copy(io.Discard, resp.Body)
+		resp.Body.Close()
+		time.Sleep(idle / 3)
+	}
+	select {
+	case err := <-errCh:
+		t.Fatalf("service stopped while active: %v", err)
+	default:
+	}
+
+	// Health checks alone do not.
+	started := time.Now()
+	for {
+		select {
+		case err := <-errCh:
+			if err != nil {
+				t.Fatal(err)
+			}
+			if time.Since(started) < idle/2 {
+				t.Fatal("service stopped before the idle duration")
+			}
+			return
+		case <-time.After(idle / 5):
+			if resp, err := http.Get(url + "/health/readiness"); err == nil {
+				_, _ = io.Copy(io.Discard, resp.Body)
+				resp.Body.Close()
+			}
+		}
+		if time.Since(started) > time.Second {
+			t.Fatal("service failed to shut down once idle")
+		}
+	}
+}
+
+// TestMaxConcurrentRequests ensures that a request received while the
+// maximum number of requests are in-flight is rejected with a 503, and that
+// a maximum which is not positive is rejected.
+func TestMaxConcurrentRequests(t *testing.T) {
+	const max = 2
+	var (
+		invoked  = make(chan any, max)
+		release  = make(chan any)
+		onHandle = func(w http.ResponseWriter, _ *http.Request) {
+			invoked <- true
+			<-release
+		}
+	)
+	service := startService(t, &mock.Function{OnHandle: onHandle}, WithMaxConcurrentRequests(max))
+	url := "http://" + service.Addr().String()
+
+	done := make(chan int, max)
+	for i := 0; i < max; i++ {
+		go func() {
+			resp, err := http.Get(url + "/")
+			if err != nil {
+				done <- 0
+				return
+			}
+			_, _ = io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			done <- resp.StatusCode
+		}()
+	}
+	for i := 0; i < max; i++ {
+		<-invoked
+	}
+
+	resp, _ := get(t, service, "/")
+	if resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("expected 503 when saturated, got %v", resp.StatusCode)
+	}
+	if resp.Header.Get("Retry-After") == "" {
+		t.Fatal("expected a Retry-After header")
+	}
+	if resp, _ := get(t, service, "/health/readiness"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected health endpoints to bypass the limit, got %v", resp.StatusCode)
+	}
+
+	close(release)
+	for i := 0; i < max; i++ {
+		if code := <-done; code != http.StatusOK {
+			t.Fatalf("unexpected in-flight status code: %v", code)
+		}
+	}
+	if resp, _ := get(t, service, "/"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected requests accepted once no longer saturated, got %v", resp.StatusCode)
+	}
+
+	for _, n := range []int{0, -1} {
+		if err := New(&mock.Function{}, WithMaxConcurrentRequests(n)).Start(context.Background()); err == nil {
+			t.Fatalf("expected an error for a maximum of %v concurrent requests", n)
+		}
+	}
+}
+
+// TestServerOptions ensures that the underlying http.Server can be configured
+// using WithServerOptions, without replacing the runtime's Handler.
+func TestServerOptions(t *testing.T) {
+	var conns atomic.Int64
+	f := &mock.Function{OnHandle: func(w http.ResponseWriter, _ *http.Request) {
+		fmt.Fprint(w, "OK")
+	}}
+	service := startService(t, f, WithServerOptions(func(srv *http.Server) {
+		srv.ConnState = func(_ net.Conn, state http.ConnState) {
+			if state == http.StateNew {
+				conns.Add(1)
+			}
+		}
+		srv.Handler = http.NotFoundHandler() // replaced by New
+	}))
+
+	if _, body := get(t, service, "/"); body != "OK" {
+		t.Fatalf("unexpected response %q; the runtime's handler was replaced", body)
+	}
+	if conns.Load() == 0 {
+		t.Fatal("ConnState set using WithServerOptions was not invoked")
+	}
+}
+
+// TestRequestID ensures that requests are assigned an ID, generated if not
+// provided, which is available to the function and echoed in the response.
+func TestRequestID(t *testing.T) {
+	f := &mock.Function{OnHandle: func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, RequestID(r.Context()))
+	}}
+	service := startService(t, f, WithRequestID())
+
+	resp, body := get(t, service, "/")
+	id := resp.Header.Get(RequestIDHeader)
+	if id == "" {
+		t.Fatal("expected a generated request ID in the response")
+	}
+	if body != id {
+		t.Fatalf("function saw request ID %q, response has %q", body, id)
+	}
+
+	req, err := http.NewRequest(http.MethodGet, "http://"+service.Addr().String()+"/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set(RequestIDHeader, "example-id")
+	resp, err = http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	b, _ := io.ReadAll(resp.Body)
+	if resp.Header.Get(RequestIDHeader) != "example-id" || string(b) != "example-id" {
+		t.Fatalf("expected the provided request ID to be used, got %q (%q)", resp.Header.Get(RequestIDHeader), b)
+	}
+}
+
+// TestLoggerFromContext ensures that the logger available to the function
+// includes the request's ID, and is the global logger outside of a request.
+func TestLoggerFromContext(t *testing.T) {
+	f := &mock.Function{OnHandle: func(w http.ResponseWriter, r *http.Request) {
+		l := LoggerFromContext(r.Context()).Output(w)
+		l.Info().Msg("handling request")
+	}}
+	service := startService(t, f, WithRequestID())
+
+	req, err := http.NewRequest(http.MethodGet, "http://"+service.Addr().String()+"/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set(RequestIDHeader, "example-id")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var line map[string]any
+	if err := json.NewDecoder(resp.Body).Decode(&line); err != nil {
+		t.Fatal(err)
+	}
+	if line["request_id"] != "example-id" || line["message"] != "handling request" {
+		t.Fatalf("expected the log line to include the request ID, got %v", line)
+	}
+
+	var buf bytes.Buffer
+	l := LoggerFromContext(context.Background()).Output(&buf)
+	l.Info().Msg("outside of a request")
+	if buf.Len() == 0 || strings.Contains(buf.String(), "request_id") {
+		t.Fatalf("expected the global logger outside of a request, got %q", buf.String())
+	}
+}
+
+// TestLogFormat ensures that logs are written as JSON by default, and for
+// the console when selected using SetLogFormat or FUNC_LOG_FORMAT.
+func TestLogFormat(t *testing.T) {
+	var buf bytes.Buffer
+	output, logger := logs.Output, zlog.Logger
+	logs.Output = &buf
+	t.Cleanup(func() { logs.Output, zlog.Logger = output, logger })
+
+	isJSON := func() bool {
+		t.Helper()
+		buf.Reset()
+		zlog.Info().Msg("example")
+		if !strings.Contains(buf.String(), "example") {
+			t.Fatalf("expected the message to be logged, got %q", buf.String())
+		}
+		return json.Valid(buf.Bytes())
+	}
+
+	if DefaultLogFormat != LogJSON {
+		t.Fatal("expected JSON logs by default")
+	}
+	SetLogFormat(LogJSON)
+	if !isJSON() {
+		t.Fatalf("expected JSON logs, got %q", buf.String())
+	}
+	SetLogFormat(LogConsole)
+	if isJSON() {
+		t.Fatalf("expected console logs, got %q", buf.String())
+	}
+
+	for value, expected := range map[string]bool{"json": true, "": true, "other": true, "console": false, "CONSOLE": false} {
+		t.Setenv("FUNC_LOG_FORMAT", value)
+		SetLogFormat(logFormatFromEnv())
+		if isJSON() != expected {
+			t.Fatalf("FUNC_LOG_FORMAT=%q: expected JSON logs %v, got %q", value, expected, buf.String())
+		}
+	}
+}
+
+// responseFunction is a function instance which returns its response.
+type responseFunction struct {
+	OnHandle func(context.Context, *http.Request) (*Response, error)
+}
+
+func (f *responseFunction) Handle(ctx context.Context, r *http.Request) (*Response, error) {
+	return f.OnHandle(ctx, r)
+}
+
+// TestResponseHandler ensures that the response returned by a
+// ResponseHandler is written, and that errors are mapped to a status code.
+func TestResponseHandler(t *testing.T) {
+	t.Setenv("LISTEN_ADDRESS", "127.0.0.1:") // use an OS-chosen port
+	f := &responseFunction{OnHandle: func(_ context.Context, r *http.Request) (*Response, error) {
+		switch r.URL.Path {
+		case "/error":
+			return nil, errors.New("secret detail")
+		case "/json":
+			var v any
+			return nil, ReadJSON(r, &v)
+		case "/empty":
+			return nil, nil
+		}
+		return &Response{
+			Status: http.StatusCreated,
+			Header: http.Header{"Content-Type": {"text/plain"}},
+			Body:   []byte("created"),
+		}, nil
+	}}
+	var (
+		ctx, cancel = context.WithCancel(context.Background())
+		errCh       = make(chan error, 1)
+	)
+	service := NewResponseHandler(f)
+	go func() {
+		errCh <- service.Start(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-errCh; err != nil {
+			t.Error(err)
+		}
+	})
+	<-service.started
+
+	resp, body := get(t, service, "/")
+	if resp.StatusCode != http.StatusCreated || resp.Header.Get("Content-Type") != "text/plain" || body != "created" {
+		t.Fatalf("unexpected response %v %v %q", resp.StatusCode, resp.Header, body)
+	}
+	resp, body = get(t, service, "/error")
+	if resp.StatusCode != http.StatusInternalServerError || strings.Contains(body, "secret") {
+		t.Fatalf("expected a 500 without the error's message, got %v %q", resp.StatusCode, body)
+	}
+	resp, _ = get(t, service, "/json")
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected the status of a JSONError, got %v", resp.StatusCode)
+	}
+	resp, _ = get(t, service, "/empty")
+	if resp.StatusCode != http.StatusNoContent {
+		t.Fatalf("expected a 204 for no response, got %v", resp.StatusCode)
+	}
+
+	if err := New(nil).Start(context.Background()); !errors.Is(err, ErrUnsupportedHandler) {
+		t.Fatalf("expected ErrUnsupportedHandler, got %v", err)
+	}
+}
+
+// TestKeepAlives ensures that connections are reused by default, are
+// closed after each response with keep-alives disabled, and are closed
+// once they outlive a maximum lifetime.
+func TestKeepAlives(t *testing.T) {
+	// reused returns whether each of n requests reused a connection,
+	// pausing between them.
+	reused := func(s *Service, n int, pause time.Duration) (rr []bool) {
+		t.Helper()
+		c := &http.Client{Transport: &http.Transport{}}
+		defer c.CloseIdleConnections()
+		for i := 0; i < n; i++ {
+			time.Sleep(pause)
+			var info httptrace.GotConnInfo
+			ctx := httptrace.WithClientTrace(context.Background(), &httptrace.ClientTrace{
+				GotConn: func(i httptrace.GotConnInfo) { info = i },
+			})
+			req, _ := http.NewRequestWithContext(ctx, http.MethodGet, "http://"+s.Addr().String(), nil)
+			resp, err := c.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			_, _ = io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			rr = append(rr, info.Reused)
+		}
+		return
+	}
+
+	if rr := reused(startService(t, &mock.Function{}), 3, 0); !rr[1] || !rr[2] {
+		t.Fatalf("expected connections to be reused by default, got %v", rr)
+	}
+	if rr := reused(startService(t, &mock.Function{}, WithKeepAlivesDisabled()), 3, 0); rr[1] || rr[2] {
+		t.Fatalf("expected no connection reused with keep-alives disabled, got %v", rr)
+	}
+	if rr := reused(startService(t, &mock.Function{}, WithMaxConnectionLifetime(200*time.Millisecond)), 3, 120*time.Millisecond); !rr[1] || rr[2] {
+		t.Fatalf("expected a connection reused only within its lifetime, got %v", rr)
+	}
+}
+
+// TestClientDisconnect ensures that a request whose client disconnects
+// mid-handler is logged as such, and that the error the function returns
+// as a result is neither written as a 500 nor logged as an error.
+func TestClientDisconnect(t *testing.T) {
+	f := &responseFunction{OnHandle: func(ctx context.Context, r *http.Request) (*Response, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	}}
+	var (
+		buf         bytes.Buffer
+		ctx, cancel = context.WithCancel(context.Background())
+	)
+	ctx = zerolog.New(&buf).WithContext(ctx)
+	r := httptest.NewRequest(http.MethodGet, "/", nil).WithContext(ctx)
+	w := httptest.NewRecorder()
+	time.AfterFunc(10*time.Millisecond, cancel) // mid-handler
+
+	(&Service{}).detectDisconnects(respondWith(f)).ServeHTTP(w, r)
+
+	if w.Code == http.StatusInternalServerError || w.Body.Len() > 0 {
+		t.Fatalf("expected no response to a disconnected client, got %v %q", w.Code, w.Body)
+	}
+	var lines []map[string]any
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var line map[string]any
+		if err := dec.Decode(&line); err != nil {
+			t.Fatal(err)
+		}
+		lines = append(lines, line)
+	}
+	if len(lines) != 2 || lines[0]["level"] != "debug" {
+		t.Fatalf("expected a debug line about the function's error, got %v", lines)
+	}
+	if lines[1]["level"] != "warn" || lines[1]["message"] != "client disconnected before response completed" ||
+		lines[1]["response_started"] != false {
+		t.Fatalf("expected a line about the client disconnecting, got %v", lines[1])
+	}
+
+	// Not a disconnect.
+	buf.Reset()
+	ctx, cancel = context.WithTimeout(zerolog.New(&buf).WithContext(context.Background()), time.Millisecond)
+	defer cancel()
+	w = httptest.NewRecorder()
+	(&Service{}).detectDisconnects(respondWith(f)).ServeHTTP(w, r.WithContext(ctx))
+	if w.Code != http.StatusInternalServerError || strings.Contains(buf.String(), "disconnected") {
+		t.Fatalf("expected a timeout to be a server error, got %v %q", w.Code, buf.String())
+	}
+}
+
+// TestLogSampling ensures that only 1 in n debug-level lines about requests
+// are emitted, each with the count of those suppressed, and that lines at
+// other levels are unaffected.
+func TestLogSampling(t *testing.T) {
+	f := &mock.Function{OnHandle: func(w http.ResponseWriter, r *http.Request) {
+		l := LoggerFromContext(r.Context()).Output(w)
+		if r.URL.Path == "/info" {
+			l.Info().Msg("handling request")
+		} else {
+			l.Debug().Msg("handling request")
+		}
+	}}
+	service := startService(t, f, WithLogSampling(3))
+
+	var emitted []map[string]any
+	for i := 0; i < 7; i++ {
+		if _, body := get(t, service, "/"); body != "" {
+			var line map[string]any
+			if err := json.Unmarshal([]byte(body), &line); err != nil {
+				t.Fatal(err)
+			}
+			emitted = append(emitted, line)
+		}
+	}
+	if len(emitted) != 3 {
+		t.Fatalf("expected 3 of 7 debug lines emitted, got %v", len(emitted))
+	}
+	if _, ok := emitted[0]["sampled_out"]; ok || emitted[1]["sampled_out"] != 2.0 || emitted[2]["sampled_out"] != 2.0 {
+		t.Fatalf("unexpected counts of lines sampled out %v", emitted)
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, body := get(t, service, "/info"); body == "" {
+			t.Fatal("info line sampled out")
+		}
+	}
+}
+
+// TestHealthResponse ensures that the success responses of the health
+// endpoints can be customized, and that failures are unaffected.
+func TestHealthResponse(t *testing.T) {
+	var ready atomic.Bool
+	ready.Store(true)
+	service := startService(t, &mock.Function{},
+		WithHealthCheck("db", func(context.Context) error {
+			if !ready.Load() {
+				return errors.New("unavailable")
+			}
+			return nil
+		}),
+		WithReadinessResponse(http.StatusAccepted, "ok"),
+		WithLivenessResponse(http.StatusOK, `{"alive":true}`))
+
+	if resp, body := get(t, service, "/health/readiness"); resp.StatusCode != http.StatusAccepted || body != "ok" {
+		t.Fatalf("unexpected readiness %v %q", resp.StatusCode, body)
+	}
+	if resp, body := get(t, service, "/health/liveness"); resp.StatusCode != http.StatusOK || body != `{"alive":true}` {
+		t.Fatalf("unexpected liveness %v %q", resp.StatusCode, body)
+	}
+	ready.Store(false)
+	if resp, _ := get(t, service, "/health/readiness"); resp.StatusCode != http.StatusServiceUnavailable {
+		t.Fatalf("unexpected readiness when not ready %v", resp.StatusCode)
+	}
+}
+
+// TestDefaultHandler_NilHandler ensures that a DefaultHandler without a
+// Handler responds 404 rather than panicking.
+func TestDefaultHandler_NilHandler(t *testing.T) {
+	w := httptest.NewRecorder()
+	DefaultHandler{}.Handle(w, httptest.NewRequest(http.MethodGet, "/", nil))
+	if w.Code != http.StatusNotFound {
+		t.Fatalf("unexpected http status code: %v", w.Code)
+	}
+}
+
+// TestStop ensures that Stop gracefully shuts down a started service,
+// causing Start to return and Done to be closed, and that it is safe to
+// invoke before Start.
+func TestStop(t *testing.T) {
+	t.Setenv("LISTEN_ADDRESS", "127.0.0.1:") // use an OS-chosen port
+
+	var (
+		started = make(chan any, 1)
+		stopped = make(chan any, 1)
+		errCh   = make(chan error, 1)
+	)
+	f := &mock.Function{
+		OnStart: func(context.Context, map[string]string) error {
+			started <- true
+			return nil
+		},
+		OnStop: func(context.Context) error {
+			stopped <- true
+			return nil
+		},
+	}
+	service := New(f)
+	go func() {
+		errCh <- service.Start(context.Background())
+	}()
+	select {
+	case <-started:
+	case err := <-errCh:
+		t.Fatal(err)
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("function failed to start")
+	}
+
+	if err := service.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-service.Done():
+	default:
+		t.Fatal("Done not closed once stopped")
+	}
+	if err := <-errCh; err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-stopped:
+	default:
+		t.Fatal("function Stop hook not invoked")
+	}
+	if err := service.Stop(context.Background()); err != nil {
+		t.Fatalf("unexpected error stopping again: %v", err)
+	}
+
+	// Stopped before started
+	service = New(&mock.Function{})
+	if err := service.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := service.Start(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	<-service.Done()
+}
+
+// TestAdminAddress ensures that when an admin address is set the health
+// endpoints are served only on the admin listener, and the function only on
+// its own.
+func TestAdminAddress(t *testing.T) {
+	f := &mock.Function{OnHandle: func(w http.ResponseWriter, _ *http.Request) {
+		fmt.Fprint(w, "function")
+	}}
+	service := startService(t, f, WithAdminAddress("127.0.0.1:0"))
+	if service.AdminAddr() == nil {
+		t.Fatal("expected an admin listener")
+	}
+
+	if _, body := get(t, service, "/health/readiness"); body != "function" {
+		t.Fatalf("expected the function's listener to serve only the function, got %q", body)
+	}
+	resp, err := http.Get("http://" + service.AdminAddr().String() + "/health/readiness")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || string(body) != "READY" {
+		t.Fatalf("unexpected admin readiness response %v %q", resp.StatusCode, body)
+	}
+	resp, err = http.Get("http://" + service.AdminAddr().String() + "/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected the function not to be served on the admin listener, got %v", resp.StatusCode)
+	}
+}
+
+// TestConfigFromContext ensures that the function's config is available to
+// its handler using ConfigFromContext.
+func TestConfigFromContext(t *testing.T) {
+	t.Setenv("TEST_CONFIG_VALUE", "example")
+	f := &mock.Function{OnHandle: func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, ConfigFromContext(r.Context())["TEST_CONFIG_VALUE"])
+	}}
+	service := startService(t, f)
+
+	if _, body := get(t, service, "/"); body != "example" {
+		t.Fatalf("unexpected config value: %q", body)
+	}
+}
+
+// TestConfigFromContext_Copy ensures that a handler which modifies the
+// config it is given does not modify that of other requests.
+func TestConfigFromContext_Copy(t *testing.T) {
+	t.Setenv("TEST_CONFIG_VALUE", "example")
+	f := &mock.Function{OnHandle: func(w http.ResponseWriter, r *http.Request) {
+		cfg := ConfigFromContext(r.Context())
+		fmt.Fprint(w, cfg["TEST_CONFIG_VALUE"])
+		cfg["TEST_CONFIG_VALUE"] = "modified"
+	}}
+	service := startService(t, f)
+
+	for i := 0; i < 2; i++ {
+		if _, body := get(t, service, "/"); body != "example" {
+			t.Fatalf("unexpected config value: %q", body)
+		}
+	}
+}
+
+// TestShutdownError ensures that when both the error which stopped the
+// service and the function's Stop hook fail, both are returned, with that of
+// Stop first.
+func TestShutdownError(t *testing.T) {
+	t.Setenv("LISTEN_ADDRESS", "127.0.0.1:") // use an OS-chosen port
+
+	var (
+		errStart = errors.New("start failed")
+		errStop  = errors.New("stop failed")
+	)
+	f := &mock.Function{
+		OnStart: func(context.Context, map[string]string) error { return errStart },
+		OnStop:  func(context.Context) error { return errStop },
+	}
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- New(f).Start(context.Background())
+	}()
+
+	var err error
+	select {
+	case err = <-errCh:
+	case <-time.After(time.Second):
+		t.Fatal("service failed to stop")
+	}
+	var se *ShutdownError
+	if !errors.As(err, &se) {
+		t.Fatalf("expected a ShutdownError, got %v", err)
+	}
+	if len(se.Errors) != 2 || se.Errors[0] != errStop || se.Errors[1] != errStart {
+		t.Fatalf("unexpected errors %v", se.Errors)
+	}
+	if !errors.Is(err, errStart) || !errors.Is(err, errStop) {
+		t.Fatalf("expected errors.Is to match each error, got %v", err)
+	}
+}
+
+// TestCompression ensures that gzip-encoded requests are decoded, and that
+// responses are gzip-encoded only when accepted and not already compressed.
+func TestCompression(t *testing.T) {
+	data := bytes.Repeat([]byte("example "), 1024)
+	f := &mock.Function{OnHandle: func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if ct := r.URL.Query().Get("type"); ct != "" {
+			w.Header().Set("Content-Type", ct)
+		}
+		_, _ = w.Write(body)
+	}}
+	service := startService(t, f, WithCompression())
+
+	send := func(path string, body []byte, contentEncoding, acceptEncoding string) (*http.Response, []byte) {
+		t.Helper()
+		req, err := http.NewRequest(http.MethodPost, "http://"+service.Addr().String()+path, bytes.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Encoding", contentEncoding)
+		req.Header.Set("Accept-Encoding", acceptEncoding)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		b, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return resp, b
+	}
+	gunzip := func(b []byte) []byte {
+		t.Helper()
+		zr, err := gzip.NewReader(bytes.NewReader(b))
+		if err != nil {
+			t.Fatal(err)
+		}
+		out, err := io.ReadAll(zr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return out
+	}
+
+	// Request decoding
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	_, _ = zw.Write(data)
+	_ = zw.Close()
+	resp, body := send("/", compressed.Bytes(), "gzip", "identity")
+	if resp.Header.Get("Content-Encoding") != "" || !bytes.Equal(body, data) {
+		t.Fatalf("expected the request to be decoded, got %q encoding and %v bytes", resp.Header.Get("Content-Encoding"), len(body))
+	}
+	if resp, _ := send("/", []byte("not gzip"), "gzip", ""); resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected an invalid gzip request to be rejected, got %v", resp.StatusCode)
+	}
+
+	// Response encoding
+	resp, body = send("/", data, "", "gzip")
+	if resp.Header.Get("Content-Encoding") != "gzip" || !bytes.Equal(gunzip(body), data) {
+		t.Fatalf("expected a gzip-encoded response, got %q encoding", resp.Header.Get("Content-Encoding"))
+	}
+	if !strings.HasPrefix(resp.Header.Get("Content-Type"), "text/plain") {
+		t.Fatalf("expected the content type of the unencoded content, got %q", resp.Header.Get("Content-Type"))
+	}
+	resp, body = send("/?type=image/png", data, "", "gzip")
+	if resp.Header.Get("Content-Encoding") != "" || !bytes.Equal(body, data) {
+		t.Fatalf("expected an already compressed content type not to be encoded, got %q", resp.Header.Get("Content-Encoding"))
+	}
+}
+
+// TestCompression_MaxDecompressedSize ensures that a gzip-encoded request
+// body which decodes to more than the maximum size is rejected with a 413,
+// and that a maximum which is not positive is rejected.
+func TestCompression_MaxDecompressedSize(t *testing.T) {
+	f := &mock.Function{OnHandle: func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		fmt.Fprint(w, len(body))
+	}}
+	service := startService(t, f, WithCompression(), WithMaxDecompressedSize(1024))
+
+	post := func(size int) (*http.Response, string) {
+		t.Helper()
+		var compressed bytes.Buffer
+		zw := gzip.NewWriter(&compressed)
+		_, _ = zw.Write(make([]byte, size))
+		_ = zw.Close()
+		req, err := http.NewRequest(http.MethodPost, "http://"+service.Addr().String()+"/", &compressed)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Encoding", "gzip")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(resp.Body)
+		return resp, string(body)
+	}
+
+	if resp, body := post(1024); resp.StatusCode != http.StatusOK || body != "1024" {
+		t.Fatalf("expected a body within the maximum to be decoded, got %v %q", resp.StatusCode, body)
+	}
+	if resp, body := post(1 << 20); resp.StatusCode != http.StatusRequestEntityTooLarge {
+		t.Fatalf("expected %v, got %v %q", http.StatusRequestEntityTooLarge, resp.StatusCode, body)
+	}
+
+	for _, n := range []int64{0, -1} {
+		if err := New(&mock.Function{}, WithCompression(), WithMaxDecompressedSize(n)).Start(context.Background()); err == nil {
+			t.Fatalf("expected an error for a maximum decompressed size of %v", n)
+		}
+	}
+}
+
+// TestHijack ensures that the ResponseWriter of a request which is not an
+// upgrade, and so is wrapped by the runtime, remains an http.Hijacker,
+// including when its request or response is gzip-encoded.
+func TestHijack(t *testing.T) {
+	f := &mock.Function{OnHandle: func(w http.ResponseWriter, r *http.Request) {
+		hj, ok := w.(http.Hijacker)
+		if !ok {
+			http.Error(w, fmt.Sprintf("%T is not an http.Hijacker", w), http.StatusInternalServerError)
+			return
+		}
+		conn, brw, err := hj.Hijack()
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		defer conn.Close()
+		_, _ = brw.WriteString("HTTP/1.1 200 OK\r\nContent-Length: 8\r\nConnection: close\r\n\r\nhijacked")
+		_ = brw.Flush()
+	}}
+	service := startService(t, f, WithCompression())
+
+	var compressed bytes.Buffer
+	zw := gzip.NewWriter(&compressed)
+	_, _ = zw.Write([]byte("example"))
+	_ = zw.Close()
+	tests := map[string]struct {
+		body                            []byte
+		contentEncoding, acceptEncoding string
+	}{
+		"identity":                  {nil, "", "identity"},
+		"gzip response":             {nil, "", "gzip"},
+		"gzip request":              {compressed.Bytes(), "gzip", "identity"},
+		"gzip request and response": {compressed.Bytes(), "gzip", "gzip"},
+	}
+	for name, test := range tests {
+		req, err := http.NewRequest(http.MethodPost, "http://"+service.Addr().String(), bytes.NewReader(test.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Encoding", test.contentEncoding)
+		req.Header.Set("Accept-Encoding", test.acceptEncoding)
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK || string(body) != "hijacked" {
+			t.Errorf("%v: expected the hijacked response, got %v %q", name, resp.StatusCode, body)
+		}
+	}
+}
+
+// TestJSON ensures that values round-trip using ReadJSON and WriteJSON, and
+// that invalid request bodies result in a descriptive JSONError.
+func TestJSON(t *testing.T) {
+	type message struct {
+		Name  string `json:"name"`
+		Count int    `json:"count"`
+	}
+
+	w := httptest.NewRecorder()
+	if err := WriteJSON(w, http.StatusCreated, message{Name: "example", Count: 2}); err != nil {
+		t.Fatal(err)
+	}
+	if w.Code != http.StatusCreated || w.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("unexpected response %v %q", w.Code, w.Header().Get("Content-Type"))
+	}
+	var got message
+	if err := ReadJSON(httptest.NewRequest(http.MethodPost, "/", w.Body), &got); err != nil {
+		t.Fatal(err)
+	}
+	if got != (message{Name: "example", Count: 2}) {
+		t.Fatalf("unexpected value %+v", got)
+	}
+
+	for _, tc := range []struct {
+		body    string
+		options []ReadJSONOption
+		status  int
+		message string
+	}{
+		{body: `{"name":`, status: http.StatusBadRequest, message: "unexpected end"},
+		{body: `{"name" "x"}`, status: http.StatusBadRequest, message: "malformed JSON at offset"},
+		{body: `{"count":"two"}`, status: http.StatusBadRequest, message: `field "count"`},
+		{body: ``, status: http.StatusBadRequest, message: "empty"},
+		{body: `{} {}`, status: http.StatusBadRequest, message: "single JSON value"},
+		{body: `{"other":1}`, options: []ReadJSONOption{DisallowUnknownFields()}, status: http.StatusBadRequest, message: "unknown field"},
+		{body: `{"name":"too long"}`, options: []ReadJSONOption{MaxJSONSize(8)}, status: http.StatusRequestEntityTooLarge, message: "exceeds 8 bytes"},
+	} {
+		err := ReadJSON(httptest.NewRequest(http.MethodPost, "/", strings.NewReader(tc.body)), &message{}, tc.options...)
+		var jsonErr *JSONError
+		if !errors.As(err, &jsonErr) {
+			t.Fatalf("%q: expected a JSONError, got %v", tc.body, err)
+		}
+		if jsonErr.Status != tc.status || !strings.Contains(err.Error(), tc.message) {
+			t.Fatalf("%q: unexpected error %v: %v", tc.body, jsonErr.Status, err)
+		}
+	}
+	if err := ReadJSON(httptest.NewRequest(http.MethodPost, "/", strings.NewReader(`{"other":1}`)), &message{}); err != nil {
+		t.Fatalf("expected unknown fields to be allowed by default, got %v", err)
+	}
+
+	w = httptest.NewRecorder()
+	if err := WriteJSON(w, http.StatusOK, make(chan int)); err == nil || w.Code != http.StatusInternalServerError {
+		t.Fatalf("expected an unencodable value to result in a 500, got %v %v", w.Code, err)
+	}
+}
+
+// TestUnixSocket ensures that a function can be served on a Unix domain
+// socket, replacing a stale socket file, which is removed on shutdown.
+func TestUnixSocket(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "f.sock")
+	t.Setenv("LISTEN_ADDRESS", "unix://"+path)
+
+	// A socket file left by a previous process
+	stale, err := net.Listen("unix", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stale.(*net.UnixListener).SetUnlinkOnClose(false)
+	stale.Close()
+
+	started := make(chan any, 1)
+	f := &mock.Function{
+		OnStart: func(context.Context, map[string]string) error {
+			started <- true
+			return nil
+		},
+		OnHandle: func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, "OK")
+		},
+	}
+	service := New(f)
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- service.Start(context.Background())
+	}()
+	select {
+	case <-started:
+	case err := <-errCh:
+		t.Fatal(err)
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("function failed to start")
+	}
+	if addr := service.Addr(); addr.Network() != "unix" || addr.String() != path {
+		t.Fatalf("unexpected address %v %v", addr.Network(), addr)
+	}
+
+	client := &http.Client{Transport: &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			return (&net.Dialer{}).DialContext(ctx, "unix", path)
+		},
+	}}
+	resp, err := client.Get("http://function/")
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != "OK" {
+		t.Fatalf("unexpected response %v %q", resp.StatusCode, body)
+	}
+	client.CloseIdleConnections()
+
+	if err := service.Stop(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-errCh; err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Fatalf("expected socket file to be removed, got %v", err)
+	}
+}
+
+// TestProxyProtocol ensures that when enabled, the RemoteAddr of a request
+// is that of the PROXY header sent by a trusted upstream, and that a header
+// sent by any other peer is discarded.
+func TestProxyProtocol(t *testing.T) {
+	f := &mock.Function{
+		OnHandle: func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, r.RemoteAddr)
+		},
+	}
+	proxied := func(service *Service) string {
+		t.Helper()
+		conn, err := net.Dial("tcp", service.Addr().String())
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		fmt.Fprint(conn, "PROXY TCP4 203.0.113.7 127.0.0.1 51234 8080\r\n")
+		fmt.Fprint(conn, "GET / HTTP/1.1\r\nHost: function\r\nConnection: close\r\n\r\n")
+		resp, err := http.ReadResponse(bufio.NewReader(conn), nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		return string(body)
+	}
+
+	service := startService(t, f, WithProxyProtocol("127.0.0.0/8"))
+	if body := proxied(service); body != "203.0.113.7:51234" {
+		t.Fatalf("expected RemoteAddr of the PROXY header, got %q", body)
+	}
+	// Without a PROXY header
+	if _, body := get(t, service, "/"); !strings.HasPrefix(body, "127.0.0.1:") {
+		t.Fatalf("expected RemoteAddr of the connection, got %q", body)
+	}
+
+	// From an untrusted upstream
+	service = startService(t, f, WithProxyProtocol("192.0.2.1"))
+	if body := proxied(service); !strings.HasPrefix(body, "127.0.0.1:") {
+		t.Fatalf("expected RemoteAddr of the untrusted connection, got %q", body)
+	}
+
+	for _, trusted := range [][]string{nil, {"not-an-address"}} {
+		if err := New(&mock.Function{}, WithProxyProtocol(trusted...)).Start(context.Background()); err == nil {
+			t.Fatalf("expected an error for trusted upstreams %q", trusted)
+		}
+	}
+}
+
+// TestReadyCallback ensures that the ready callback receives the address
+// bound, including an OS-chosen port, upon which requests are then served.
+func TestReadyCallback(t *testing.T) {
+	t.Setenv("LISTEN_ADDRESS", "127.0.0.1:0")
+	var (
+		ctx, cancel = context.WithCancel(context.Background())
+		addrCh      = make(chan net.Addr, 1)
+		errCh       = make(chan error, 1)
+	)
+	defer cancel()
+	f := &mock.Function{OnHandle: func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "OK")
+	}}
+	service := New(f, WithReadyCallback(func(addr net.Addr) { addrCh <- addr }))
+	go func() {
+		errCh <- service.Start(ctx)
+	}()
+
+	var addr net.Addr
+	select {
+	case addr = <-addrCh:
+	case err := <-errCh:
+		t.Fatal(err)
+	case <-time.After(time.Second):
+		t.Fatal("ready callback not invoked")
+	}
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok || tcpAddr.Port == 0 {
+		t.Fatalf("expected a bound TCP address, got %#v", addr)
+	}
+
+	resp, err := http.Get("http://" + addr.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if string(body) != "OK" {
+		t.Fatalf("unexpected response %q", body)
+	}
+
+	cancel()
+	if err := <-errCh; err != nil {
+		t.Fatal(err)
+	}
+}
+
+// routerFunction is a function which routes requests itself.
+type routerFunction struct {
+	mock.Function
+	routes func(*http.ServeMux)
+}
+
+func (f *routerFunction) Routes(mux *http.ServeMux) { f.routes(mux) }
+
+// TestRouter ensures that requests to a Router are routed by method and
+// path, with 405 on a method mismatch, and that health endpoints remain
+// served by the runtime.
+func TestRouter(t *testing.T) {
+	f := &routerFunction{routes: func(mux *http.ServeMux) {
+		mux.HandleFunc("GET /items/{id}", func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprintf(w, "get %v", r.PathValue("id"))
+		})
+		mux.HandleFunc("POST /items", func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, "post")
+		})
+		mux.HandleFunc("/health/readiness", func(w http.ResponseWriter, r *http.Request) {
+			fmt.Fprint(w, "function readiness")
+		})
+	}}
+	f.OnHandle = func(w http.ResponseWriter, r *http.Request) {
+		t.Error("Handle invoked for a Router")
+	}
+	t.Setenv("LISTEN_ADDRESS", "127.0.0.1:0")
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	addrCh := make(chan net.Addr, 1)
+	service := New(f, WithReadyCallback(func(addr net.Addr) { addrCh <- addr }))
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- service.Start(ctx)
+	}()
+	select {
+	case <-addrCh:
+	case err := <-errCh:
+		t.Fatal(err)
+	}
+
+	if resp, body := get(t, service, "/items/42"); resp.StatusCode != http.StatusOK || body != "get 42" {
+		t.Fatalf("unexpected response %v %q", resp.StatusCode, body)
+	}
+	resp, err := http.Post("http://"+service.Addr().String()+"/items", "text/plain", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := io.ReadAll(resp.Body)
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK || string(body) != "post" {
+		t.Fatalf("unexpected response %v %q", resp.StatusCode, body)
+	}
+	if resp, _ := get(t, service, "/items"); resp.StatusCode != http.StatusMethodNotAllowed {
+		t.Fatalf("expected 405 on method mismatch, got %v", resp.StatusCode)
+	}
+	if resp, _ := get(t, service, "/other"); resp.StatusCode != http.StatusNotFound {
+		t.Fatalf("expected 404 for an unrouted path, got %v", resp.StatusCode)
+	}
+	if _, body := get(t, service, "/health/readiness"); body != "READY" {
+		t.Fatalf("expected the runtime's readiness endpoint, got %q", body)
+	}
+
+	cancel()
+	if err := <-errCh; err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestReadinessChange ensures that the readiness change callback is invoked
+// only when the result of the readiness endpoint changes.
+func TestReadinessChange(t *testing.T) {
+	var ready atomic.Bool
+	changes := make(chan bool, 10)
+	f := DefaultHandler{OnReady: func(context.Context) (bool, error) {
+		return ready.Load(), nil
+	}}
+	service := New(f, WithReadinessChangeCallback(func(ready bool) { changes <- ready }))
+
+	probe := func(want bool) {
+		t.Helper()
+		ready.Store(want)
+		service.Ready(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/health/readiness", nil))
+	}
+	expect := func(want bool) {
+		t.Helper()
+		select {
+		case got := <-changes:
+			if got != want {
+				t.Fatalf("expected change to %v, got %v", want, got)
+			}
+		case <-time.After(time.Second):
+			t.Fatalf("expected change to %v", want)
+		}
+	}
+	expectNone := func() {
+		t.Helper()
+		select {
+		case got := <-changes:
+			t.Fatalf("unexpected change to %v", got)
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+
+	probe(false) // initially not ready
+	expectNone()
+	probe(true)
+	expect(true)
+	probe(true)
+	expectNone()
+	probe(false)
+	expect(false)
+	probe(false)
+	expectNone()
+	probe(true)
+	expect(true)
+}
+
+// TestSignalDuringStart ensures that a signal received while the function's
+// Start hook is in progress cancels its context, and that Stop is invoked
+// only once Start has returned.
+func TestSignalDuringStart(t *testing.T) {
+	t.Setenv("LISTEN_ADDRESS", "127.0.0.1:0")
+
+	// Ensure the test process is not terminated should the signal be sent
+	// before the service is handling signals.
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+
+	var (
+		starting      = make(chan any)
+		startReturned atomic.Bool
+		stopped       = make(chan bool, 1)
+		errCh         = make(chan error, 1)
+	)
+	f := &mock.Function{
+		OnStart: func(ctx context.Context, _ map[string]string) error {
+			close(starting)
+			<-ctx.Done()
+			time.Sleep(50 * time.Millisecond) // slow to return
+			startReturned.Store(true)
+			return ctx.Err()
+		},
+		OnStop: func(context.Context) error {
+			stopped <- startReturned.Load()
+			return nil
+		},
+	}
+	go func() {
+		errCh <- New(f).Start(context.Background())
+	}()
+	<-starting
+
+	// Signal until stopped, as the service may not yet be handling signals.
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case <-ticker.C:
+			if err := syscall.Kill(os.Getpid(), syscall.SIGTERM); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		case afterStart := <-stopped:
+			if !afterStart {
+				t.Fatal("Stop invoked before Start returned")
+			}
+		case <-timeout:
+			t.Fatal("function not stopped")
+		}
+		break
+	}
+	if err := <-errCh; err != nil {
+		t.Fatal(err)
+	}
+
+	// Await delivery of any signal still in flight, such that it does not
+	// stop the service of a later test.
+	for quiet := time.After(50 * time.Millisecond); ; {
+		select {
+		case <-sigs:
+			quiet = time.After(50 * time.Millisecond)
+			continue
+		case <-quiet:
+		}
+		break
+	}
+}
+
+// TestSignalMultipleServices ensures that several services in one process
+// each stop cleanly, and only once, upon a signal.
+func TestSignalMultipleServices(t *testing.T) {
+	t.Setenv("LISTEN_ADDRESS", "127.0.0.1:") // use an OS-chosen port
+
+	// Ensure the test process is not terminated should the signal be sent
+	// before the services are handling signals.
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGTERM)
+	defer signal.Stop(sigs)
+
+	const n = 2
+	var (
+		stops   atomic.Int32
+		errCh   = make(chan error, n)
+		started = make(chan any, n)
+	)
+	for i := 0; i < n; i++ {
+		f := &mock.Function{
+			OnStart: func(context.Context, map[string]string) error {
+				started <- true
+				return nil
+			},
+			OnStop: func(context.Context) error {
+				stops.Add(1)
+				return nil
+			},
+		}
+		go func() {
+			errCh <- New(f).Start(context.Background())
+		}()
+	}
+	for i := 0; i < n; i++ {
+		<-started
+	}
+
+	// Signal until both are stopped, as they may not yet be handling signals.
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	timeout := time.After(2 * time.Second)
+	for stopped := 0; stopped < n; {
+		select {
+		case <-ticker.C:
+			if err := syscall.Kill(os.Getpid(), syscall.SIGTERM); err != nil {
+				t.Fatal(err)
+			}
+		case err := <-errCh:
+			if err != nil {
+				t.Fatal(err)
+			}
+			stopped++
+		case <-timeout:
+			t.Fatal("services not stopped")
+		}
+	}
+	if got := stops.Load(); got != n {
+		t.Fatalf("expected each service stopped once, got %v stops", got)
+	}
+
+	// Await delivery of any signal still in flight, such that it does not
+	// stop the service of a later test.
+	for quiet := time.After(50 * time.Millisecond); ; {
+		select {
+		case <-sigs:
+			quiet = time.After(50 * time.Millisecond)
+			continue
+		case <-quiet:
+		}
+		break
+	}
+}
+
+// TestSignalHandler ensures that a handler registered for a signal is
+// invoked upon it, without stopping the service.
+func TestSignalHandler(t *testing.T) {
+	// Ensure the test process is not terminated should the signal be sent
+	// before the service is handling signals.
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGUSR1)
+	defer signal.Stop(sigs)
+
+	invoked := make(chan any, 1)
+	service := startService(t, &mock.Function{}, WithSignalHandler(syscall.SIGUSR1, func() {
+		select {
+		case invoked <- true:
+		default:
+		}
+	}))
+
+	// Signal until handled, as the service may not yet be handling signals.
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	timeout := time.After(2 * time.Second)
+	for {
+		select {
+		case <-ticker.C:
+			if err := syscall.Kill(os.Getpid(), syscall.SIGUSR1); err != nil {
+				t.Fatal(err)
+			}
+			continue
+		case <-invoked:
+		case <-timeout:
+			t.Fatal("signal handler not invoked")
+		}
+		break
+	}
+
+	resp, err := http.Get("http://" + service.Addr().String() + "/health/liveness")
+	if err != nil {
+		t.Fatalf("service stopped upon a handled signal: %v", err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+}
+
+// TestShutdownHook ensures that shutdown hooks are invoked in the order
+// registered after the function's Stop hook, and that their errors are
+// returned from Start.
+func TestShutdownHook(t *testing.T) {
+	t.Setenv("LISTEN_ADDRESS", "127.0.0.1:") // use an OS-chosen port
+	var (
+		ctx, cancel = context.WithCancel(context.Background())
+		errCh       = make(chan error, 1)
+		hookErr     = errors.New("hook error")
+		invoked     []string
+	)
+	f := &mock.Function{OnStop: func(context.Context) error {
+		invoked = append(invoked, "stop")
+		return nil
+	}}
+	hook := func(name string, err error) Option {
+		return WithShutdownHook(func(context.Context) error {
+			invoked = append(invoked, name)
+			return err
+		})
+	}
+	service := New(f, hook("first", nil), hook("second", hookErr))
+	go func() {
+		errCh <- service.Start(ctx)
+	}()
+	select {
+	case <-service.started:
+	case err := <-errCh:
+		t.Fatal(err)
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("function failed to start")
+	}
+	cancel()
+
+	select {
+	case err := <-errCh:
+		if !errors.Is(err, hookErr) {
+			t.Fatalf("expected the hook error, got %v", err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("service failed to stop")
+	}
+	if got := strings.Join(invoked, ","); got != "stop,first,second" {
+		t.Fatalf("unexpected invocation order %q", got)
+	}
+}
+
+// TestAuthentication ensures that requests to the function must bear the
+// shared secret or a valid JWT, and that health endpoints remain open.
+func TestAuthentication(t *testing.T) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	jwksServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, `{"keys":[{"kty":"EC","kid":"k1","use":"sig","crv":"P-256","x":%q,"y":%q}]}`,
+			base64.RawURLEncoding.EncodeToString(key.X.FillBytes(make([]byte, 32))),
+			base64.RawURLEncoding.EncodeToString(key.Y.FillBytes(make([]byte, 32))))
+	}))
+	defer jwksServer.Close()
+	sign := func(claims jwt.MapClaims) string {
+		token := jwt.NewWithClaims(jwt.SigningMethodES256, claims)
+		token.Header["kid"] = "k1"
+		s, err := token.SignedString(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+	exp := time.Now().Add(time.Hour).Unix()
+
+	f := &mock.Function{OnHandle: func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, JWTClaims(r.Context())["sub"])
+	}}
+	service := startService(t, f, WithBearerToken("secret"),
+		WithJWTValidation(jwksServer.URL, "https://issuer.example.com", "function"))
+
+	for _, tc := range []struct {
+		name          string
+		authorization string
+		status        int
+		body          string
+	}{
+		{name: "missing", status: http.StatusUnauthorized},
+		{name: "invalid", authorization: "Bearer wrong", status: http.StatusUnauthorized},
+		{name: "not bearer", authorization: "Basic secret", status: http.StatusUnauthorized},
+		{name: "shared secret", authorization: "Bearer secret", status: http.StatusOK},
+		{name: "jwt", authorization: "Bearer " + sign(jwt.MapClaims{
+			"iss": "https://issuer.example.com", "aud": "function", "sub": "alice", "exp": exp,
+		}), status: http.StatusOK, body: "alice"},
+		{name: "jwt expired", authorization: "Bearer " + sign(jwt.MapClaims{
+			"iss": "https://issuer.example.com", "aud": "function", "sub": "alice", "exp": time.Now().Add(-time.Hour).Unix(),
+		}), status: http.StatusUnauthorized},
+		{name: "jwt issuer", authorization: "Bearer " + sign(jwt.MapClaims{
+			"iss": "https://other.example.com", "aud": "function", "sub": "alice", "exp": exp,
+		}), status: http.StatusUnauthorized},
+		{name: "jwt audience", authorization: "Bearer " + sign(jwt.MapClaims{
+			"iss": "https://issuer.example.com", "aud": "other", "sub": "alice", "exp": exp,
+		}), status: http.StatusUnauthorized},
+		{name: "jwt no audience", authorization: "Bearer " + sign(jwt.MapClaims{
+			"iss": "https://issuer.example.com", "sub": "alice", "exp": exp,
+		}), status: http.StatusUnauthorized},
+		{name: "jwt unsigned", authorization: "Bearer " + func() string {
+			s, _ := jwt.NewWithClaims(jwt.SigningMethodNone, jwt.MapClaims{
+				"iss": "https://issuer.example.com", "aud": "function", "exp": exp,
+			}).SignedString(jwt.UnsafeAllowNoneSignatureType)
+			return s
+		}(), status: http.StatusUnauthorized},
+	} {
+		req, _ := http.NewRequest(http.MethodGet, "http://"+service.Addr().String()+"/", nil)
+		if tc.authorization != "" {
+			req.Header.Set("Authorization", tc.authorization)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, _ := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode != tc.status {
+			t.Fatalf("%v: expected %v, got %v", tc.name, tc.status, resp.StatusCode)
+		}
+		if tc.status == http.StatusUnauthorized && resp.Header.Get("WWW-Authenticate") == "" {
+			t.Fatalf("%v: expected a WWW-Authenticate header", tc.name)
+		}
+		if tc.body != "" && string(body) != tc.body {
+			t.Fatalf("%v: expected claims of the JWT, got %q", tc.name, body)
+		}
+	}
+
+	if resp, _ := get(t, service, "/health/readiness"); resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected health endpoints not to require authentication, got %v", resp.StatusCode)
+	}
+
+	if err := New(f, WithJWTValidation(jwksServer.URL, "https://issuer.example.com", "")).Start(context.Background()); err == nil {
+		t.Fatal("expected an error for JWT validation without an audience")
+	}
+}
+
+// TestJWKS_Fetch ensures that concurrent lookups of an unknown key share a
+// single fetch of the JWKS, during which known keys are still returned.
+func TestJWKS_Fetch(t *testing.T) {
+	var (
+		fetches atomic.Int32
+		release = make(chan any)
+	)
+	jwksServer := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fetches.Add(1)
+		<-release
+		fmt.Fprint(w, `{"keys":[{"kty":"RSA","kid":"k2","n":"AQAB","e":"AQAB"}]}`)
+	}))
+	defer jwksServer.Close()
+	defer func() {
+		select {
+		case <-release:
+		default:
+			close(release)
+		}
+	}()
+	j := &jwks{url: jwksServer.URL, client: jwksServer.Client(), keys: map[string]any{"k1": "key"}}
+
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := j.key("k2"); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	for fetches.Load() == 0 {
+		time.Sleep(time.Millisecond)
+	}
+
+	known := make(chan error, 1)
+	go func() {
+		_, err := j.key("k1")
+		known <- err
+	}()
+	select {
+	case err := <-known:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("known key blocked by a fetch of the JWKS")
+	}
+
+	close(release)
+	wg.Wait()
+	if n := fetches.Load(); n != 1 {
+		t.Fatalf("expected a single fetch of the JWKS, got %v", n)
+	}
+}
+
+// TestRecover ensures that a panic in the function is passed to the recover
+// callback, which responds, including when invoked on a worker pool.
+func TestRecover(t *testing.T) {
+	for _, options := range [][]Option{nil, {WithWorkerPool(1)}} {
+		var recovered atomic.Value
+		f := &mock.Function{OnHandle: func(w http.ResponseWriter, r *http.Request) {
+			panic("boom")
+		}}
+		service := startService(t, f, append(options, WithRecover(func(w http.ResponseWriter, r *http.Request, v any) {
+			recovered.Store(v)
+			http.Error(w, "recovered", http.StatusTeapot)
+		}))...)
+
+		resp, body := get(t, service, "/")
+		if resp.StatusCode != http.StatusTeapot || body != "recovered\n" {
+			t.Fatalf("unexpected response %v %q", resp.StatusCode, body)
+		}
+		if v := recovered.Load(); v != "boom" {
+			t.Fatalf("expected the recovered value, got %v", v)
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package http
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Event is a single Server-Sent Event.
+// Only Data is required; ID and Event are omitted from the stream if empty.
+type Event struct {
+	ID    string
+	Event string
+	Data  string
+}
+
+// StreamHandler is a function which streams Server-Sent Events to a client
+// by invoking send for each event.  Each event is flushed to the client
+// as soon as it is sent.  The stream ends when the handler returns, and
+// handlers should return when the request's context is done, which happens
+// when the client disconnects.
+//
+// StreamHandler implements Handler, such that it can be used directly as a
+// function:
+//
+//	fn.Start(fn.StreamHandler(func(r *http.Request, send func(fn.Event) error) error {
+//		return send(fn.Event{Data: "hello"})
+//	}))
+//
+// The server's write timeout is cleared for the stream, such that it is
+// not cut off after the period set using WithWriteTimeout.
+type StreamHandler func(r *http.Request, send func(Event) error) error
+
+// Handle the request as a stream of Server-Sent Events.
+func (h StreamHandler) Handle(w http.ResponseWriter, r *http.Request) {
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil && !errors.Is(err, http.ErrNotSupported) {
+		requestLog(r).Warn().Err(err).Msg("unable to clear write deadline for stream")
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	if err := rc.Flush(); err != nil {
+		// The ResponseWriter has likely been wrapped by middleware which
+		// does not implement http.Flusher (or Unwrap).
+		requestLog(r).Error().Err(err).Msg("response does not support streaming")
+		return
+	}
+
+	send := func(e Event) error {
+		if _, err := fmt.Fprint(w, e); err != nil {
+			return err
+		}
+		return rc.Flush()
+	}
+	if err := h(r, send); err != nil {
+		requestLog(r).Error().Err(err).Msg("stream handler error")
+	}
+}
+
+// String returns the event in the Server-Sent Events wire format, including
+// the blank line which terminates it.  Multi-line data is sent as multiple
+// data fields.
+func (e Event) String() string {
+	var b strings.Builder
+	if e.ID != "" {
+		fmt.Fprintf(&b, "id: %v\n", e.ID)
+	}
+	if e.Event != "" {
+		fmt.Fprintf(&b, "event: %v\n", e.Event)
+	}
+	for _, line := range strings.Split(e.Data, "\n") {
+		fmt.Fprintf(&b, "data: %v\n", line)
+	}
+	b.WriteString("\n")
+	return b.String()
+}
@@ -0,0 +1,43 @@
+package http
+
+import (
+	"fmt"
+
+	"github.com/rs/zerolog/log"
+)
+
+// logSummary logs the effective configuration of the service once
+// listening, such that misconfiguration (for example of the environment)
+// is apparent from a single log line.
+func (s *Service) logSummary() {
+	e := log.Info().
+		Str("address", s.listener.Addr().String()).
+		Str("handler", fmt.Sprintf("%T", s.f)).
+		Strs("implements", implemented(s.f)).
+		Dur("read_timeout", s.ReadTimeout).
+		Dur("write_timeout", s.WriteTimeout).
+		Dur("idle_timeout", s.IdleTimeout)
+	if s.keepAlivesDisabled {
+		e = e.Bool("keep_alives", false)
+	}
+	if s.connLifetimes != nil {
+		e = e.Dur("max_connection_lifetime", s.connLifetimes.max)
+	}
+	if s.adminListener != nil {
+		e = e.Str("admin_address", s.adminListener.Addr().String())
+	}
+	if s.requestTimeouts != nil {
+		e = e.Dur("request_timeout", s.requestTimeouts.fallback)
+	}
+	if s.auth.enabled() {
+		// Whether, but not how (its secrets), requests are authenticated.
+		e = e.Bool("authentication", true)
+	}
+	if s.profiling {
+		e = e.Bool("profiling", true)
+	}
+	if s.logSampler != nil {
+		e = e.Uint32("log_sampling", s.logSampler.n)
+	}
+	e.Msg("function runtime configured")
+}
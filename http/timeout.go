@@ -0,0 +1,76 @@
+package http
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// WithRequestTimeout sets the default timeout of the function's handler,
+// after which the context of the request is canceled.  By default the
+// context of a request is canceled only when the client disconnects.
+// Timeouts configured for specific routes using WithRouteTimeout override
+// this default.
+func WithRequestTimeout(d time.Duration) Option {
+	return func(s *Service) {
+		s.timeouts().fallback = d
+	}
+}
+
+// WithRouteTimeout sets the timeout of requests matching the given pattern,
+// after which the context of the request is canceled.  Patterns are those
+// of http.ServeMux, such that the most specific matching pattern applies
+// (for example "/reports/" matches all paths beneath /reports, while
+// "/status" matches only /status).  This allows routes with different
+// latency profiles to be given timeouts of their own.
+func WithRouteTimeout(pattern string, d time.Duration) Option {
+	return func(s *Service) {
+		t := s.timeouts()
+		if _, ok := t.routes[pattern]; !ok {
+			t.mux.Handle(pattern, http.NotFoundHandler()) // only matched
+		}
+		t.routes[pattern] = d
+	}
+}
+
+// timeouts returns the service's request timeouts, creating them if this is
+// the first to be configured.
+func (s *Service) timeouts() *requestTimeouts {
+	if s.requestTimeouts == nil {
+		s.requestTimeouts = &requestTimeouts{
+			mux:    http.NewServeMux(),
+			routes: map[string]time.Duration{},
+		}
+	}
+	return s.requestTimeouts
+}
+
+// requestTimeouts applies a timeout to the context of each request
+// according to the route pattern it matches.
+type requestTimeouts struct {
+	fallback time.Duration
+	mux      *http.ServeMux // used only to match request to pattern
+	routes   map[string]time.Duration
+}
+
+// timeout returns the timeout for the request; zero for none.
+func (t *requestTimeouts) timeout(r *http.Request) time.Duration {
+	if _, pattern := t.mux.Handler(r); pattern != "" {
+		if d, ok := t.routes[pattern]; ok {
+			return d
+		}
+	}
+	return t.fallback
+}
+
+// middleware which cancels the context of a request after its timeout.
+func (t *requestTimeouts) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if d := t.timeout(r); d > 0 {
+			ctx, cancel := context.WithTimeout(r.Context(), d)
+			defer cancel()
+			r = r.WithContext(ctx)
+		}
+		next.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,125 @@
+package http
+
+import (
+	"bufio"
+	"context"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// hijackPollInterval is how often shutdown checks whether all hijacked
+// connections have been closed.
+const hijackPollInterval = 10 * time.Millisecond
+
+// WithoutUpgradeTimeouts exempts upgraded connections (for example
+// WebSockets) from the server's read and write timeouts.  Depending on the
+// version of Go, a hijacked connection may retain the deadlines set by the
+// server, closing a long-lived connection after its ReadTimeout or
+// WriteTimeout.  With this option the deadlines are cleared once a handler
+// has hijacked the connection of an upgrade request, and handlers are then
+// responsible for setting deadlines of their own as needed.
+func WithoutUpgradeTimeouts() Option {
+	return func(s *Service) {
+		s.clearUpgradeDeadlines = true
+	}
+}
+
+// hijackTracker counts the connections of upgrade requests which have been
+// hijacked from the server and not yet closed.  Server.Shutdown neither
+// closes nor waits for hijacked connections, so they are tracked in order
+// that shutdown can allow them to close on their own.
+type hijackTracker struct {
+	hijacked atomic.Int64
+}
+
+// trackUpgrades wraps the ResponseWriter of upgrade requests such that
+// hijacking the connection is tracked.
+func (s *Service) trackUpgrades(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isUpgrade(r) {
+			w = &upgradeResponseWriter{ResponseWriter: w, s: s}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// awaitHijacked waits for all hijacked connections to be closed, or for the
+// context to be done.
+func (s *Service) awaitHijacked(ctx context.Context) error {
+	ticker := time.NewTicker(hijackPollInterval)
+	defer ticker.Stop()
+	for s.hijackTracker.hijacked.Load() > 0 {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-ticker.C:
+		}
+	}
+	return nil
+}
+
+// isUpgrade returns true if the request is asking to upgrade the protocol
+// of its connection.
+func isUpgrade(r *http.Request) bool {
+	for _, v := range r.Header.Values("Connection") {
+		for _, token := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// upgradeResponseWriter is a ResponseWriter whose hijacked connection is
+// tracked by the service.
+type upgradeResponseWriter struct {
+	http.ResponseWriter
+	s *Service
+}
+
+// Hijack the underlying connection, clearing its deadlines if enabled using
+// WithoutUpgradeTimeouts.
+func (w *upgradeResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, brw, err := http.NewResponseController(w.ResponseWriter).Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	if w.s.clearUpgradeDeadlines {
+		if err := conn.SetDeadline(time.Time{}); err != nil {
+			conn.Close()
+			return nil, nil, err
+		}
+	}
+	w.s.hijackTracker.hijacked.Add(1)
+	return &hijackedConn{
+		Conn:   conn,
+		closed: sync.OnceFunc(func() { w.s.hijackTracker.hijacked.Add(-1) }),
+	}, brw, nil
+}
+
+// Flush the response, such that wrapping does not prevent streaming.
+func (w *upgradeResponseWriter) Flush() {
+	_ = http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Unwrap returns the wrapped ResponseWriter for use by
+// http.ResponseController.
+func (w *upgradeResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// hijackedConn is a hijacked connection which reports when it is closed.
+type hijackedConn struct {
+	net.Conn
+	closed func()
+}
+
+func (c *hijackedConn) Close() error {
+	defer c.closed()
+	return c.Conn.Close()
+}
@@ -0,0 +1,161 @@
+package http
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+	"runtime/debug"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+)
+
+// WithWorkerPool invokes the function's handler on a fixed pool of n worker
+// routines rather than on the routine serving each connection.  Up to n
+// requests may be queued awaiting a free worker, beyond which requests are
+// rejected with a 503 and a Retry-After header.  Health endpoints are served
+// normally.
+//
+// By default, net/http handles each request on its own routine, so
+// parallelism is bounded only by the number of concurrent requests.  This
+// keeps latency low for I/O-bound functions, but CPU-bound functions may
+// benefit from a worker pool which bounds parallelism deterministically
+// (for example to GOMAXPROCS), improving cache locality and shedding load
+// rather than degrading all requests when saturated.  The tradeoff is
+// that requests which block (on I/O for example) hold a worker, reducing
+// throughput, and that each request incurs a handoff between routines.
+//
+// A panic on a worker is recovered, logged and responded to with a 500 (if
+// a response has not already begun), as net/http would not otherwise
+// recover it.  Use WithRecover to handle panics otherwise.  Start returns
+// an error if n is not positive.
+func WithWorkerPool(n int) Option {
+	return func(s *Service) {
+		if n <= 0 {
+			s.invalidOption("worker pool size must be positive: %d", n)
+			return
+		}
+		s.workerPool = newWorkerPool(n)
+	}
+}
+
+type job struct {
+	w        http.ResponseWriter
+	r        *http.Request
+	h        http.Handler
+	done     chan struct{}
+	panicked any // recovered on the worker, closing done
+}
+
+type workerPool struct {
+	n    int
+	jobs chan *job
+	wg   sync.WaitGroup
+}
+
+func newWorkerPool(n int) *workerPool {
+	return &workerPool{n: n, jobs: make(chan *job, n)}
+}
+
+// start the pool's workers.
+func (p *workerPool) start() {
+	log.Debug().Int("workers", p.n).Msg("starting worker pool")
+	for i := 0; i < p.n; i++ {
+		p.wg.Add(1)
+		go func() {
+			defer p.wg.Done()
+			for j := range p.jobs {
+				p.serve(j)
+			}
+		}()
+	}
+}
+
+// serve the job, recovering a panic of its handler such that the worker
+// survives and the request is responded to.
+func (p *workerPool) serve(j *job) {
+	defer close(j.done)
+	// Skip requests whose client gave up while queued.
+	if j.r.Context().Err() != nil {
+		return
+	}
+	w := &workerResponseWriter{ResponseWriter: j.w}
+	defer func() {
+		v := recover()
+		if v == nil {
+			return
+		}
+		j.panicked = v
+		if v == http.ErrAbortHandler {
+			return // re-panicked by the request's routine
+		}
+		requestLog(j.r).Error().Any("panic", v).Str("stack", string(debug.Stack())).
+			Msg("function panicked on worker")
+		if !w.wroteHeader {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		}
+	}()
+	j.h.ServeHTTP(w, j.r)
+}
+
+// stop the pool's workers once they have completed all queued requests.
+// Must only be called after the server has stopped accepting requests.
+func (p *workerPool) stop() {
+	close(p.jobs)
+	p.wg.Wait()
+}
+
+// middleware which dispatches requests to the pool.
+func (p *workerPool) middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		j := &job{w: w, r: r, h: next, done: make(chan struct{})}
+		select {
+		case p.jobs <- j:
+		default:
+			w.Header().Set("Retry-After", "1")
+			http.Error(w, "worker pool queue full", http.StatusServiceUnavailable)
+			return
+		}
+		<-j.done
+		if j.panicked == http.ErrAbortHandler {
+			panic(j.panicked) // aborting the response, as net/http does
+		}
+	})
+}
+
+// workerResponseWriter is a ResponseWriter which records whether a response
+// has begun, such that a panic can be responded to if not.
+type workerResponseWriter struct {
+	http.ResponseWriter
+	wroteHeader bool
+}
+
+func (w *workerResponseWriter) WriteHeader(code int) {
+	if code >= 200 {
+		w.wroteHeader = true
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *workerResponseWriter) Write(b []byte) (int, error) {
+	w.wroteHeader = true
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush the response, such that wrapping does not prevent streaming.
+func (w *workerResponseWriter) Flush() {
+	w.wroteHeader = true
+	_ = http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+// Hijack the connection, such that wrapping does not prevent upgrades.
+func (w *workerResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	w.wroteHeader = true
+	return http.NewResponseController(w.ResponseWriter).Hijack()
+}
+
+// Unwrap returns the wrapped ResponseWriter for use by
+// http.ResponseController.
+func (w *workerResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
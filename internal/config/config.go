@@ -0,0 +1,110 @@
+// Package config builds the config with which a function instance is
+// started, as passed to its Start hook, such that it is built the same way
+// by all middleware.
+package config
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+)
+
+// New creates a final map of config values built from the static
+// values in `cfg`, the secrets in FUNC_SECRETS_DIR and all environment
+// variables, in increasing order of precedence.
+func New() (cfg map[string]string, err error) {
+	if cfg, err = readCfg(); err != nil {
+		return
+	}
+
+	secrets, err := readSecrets()
+	if err != nil {
+		return
+	}
+	for k, v := range secrets {
+		cfg[k] = v
+	}
+
+	for _, e := range os.Environ() {
+		pair := strings.SplitN(e, "=", 2)
+		cfg[pair[0]] = pair[1]
+	}
+	return
+}
+
+// ListenAddress returns the address upon which a service listens: that of
+// the environment variable LISTEN_ADDRESS if set, or def otherwise.
+func ListenAddress(def string) string {
+	if listenAddress := os.Getenv("LISTEN_ADDRESS"); listenAddress != "" {
+		return listenAddress
+	}
+	return def
+}
+
+// readCfg returns a map representation of ./cfg
+// Empty map is returned if ./cfg does not exist.
+// Error is returned for invalid entries.
+// keys and values are space-trimmed.
+// Quotes are removed from values.
+func readCfg() (map[string]string, error) {
+	cfg := map[string]string{}
+
+	f, err := os.Open("cfg")
+	if err != nil {
+		log.Debug().Msg("no static config")
+		return cfg, nil
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	i := 0
+	for scanner.Scan() {
+		i++
+		line := scanner.Text()
+		parts := strings.SplitN(line, "=", 2)
+		if len(parts) != 2 {
+			return cfg, fmt.Errorf("config line %v invalid: %v", i, line)
+		}
+		cfg[strings.TrimSpace(parts[0])] = strings.Trim(strings.TrimSpace(parts[1]), "\"")
+	}
+	return cfg, scanner.Err()
+}
+
+// readSecrets returns a map of the files in the directory FUNC_SECRETS_DIR,
+// such as a mounted Kubernetes Secret, keyed by file name.  Trailing newlines
+// are removed from values.  Hidden files and directories, such as those
+// used by Kubernetes to update a mounted volume atomically, are ignored.
+// An empty map is returned if FUNC_SECRETS_DIR is not set.
+func readSecrets() (map[string]string, error) {
+	secrets := map[string]string{}
+
+	dir := os.Getenv("FUNC_SECRETS_DIR")
+	if dir == "" {
+		return secrets, nil
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return secrets, fmt.Errorf("reading FUNC_SECRETS_DIR: %w", err)
+	}
+	for _, e := range entries {
+		if strings.HasPrefix(e.Name(), ".") {
+			continue
+		}
+		path := filepath.Join(dir, e.Name())
+		if fi, err := os.Stat(path); err != nil {
+			return secrets, err
+		} else if fi.IsDir() {
+			continue
+		}
+		b, err := os.ReadFile(path)
+		if err != nil {
+			return secrets, err
+		}
+		secrets[e.Name()] = strings.TrimRight(string(b), "\r\n")
+	}
+	return secrets, nil
+}
@@ -0,0 +1,102 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// TestNew_Static ensures that the values of ./cfg are included in the
+// config, with quotes and surrounding space removed, and that an invalid
+// line is an error.
+func TestNew_Static(t *testing.T) {
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(t.TempDir()); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { _ = os.Chdir(wd) })
+	if err := os.WriteFile("cfg", []byte("FUNC_VERSION = \"v1.2.3\"\nTEST_CFG=from_cfg\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("TEST_CFG", "from_env")
+
+	cfg, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := cfg["FUNC_VERSION"]; v != "v1.2.3" {
+		t.Fatalf("expected FUNC_VERSION of cfg, got %q", v)
+	}
+	if v := cfg["TEST_CFG"]; v != "from_env" {
+		t.Fatalf("expected the environment to take precedence, got %q", v)
+	}
+
+	if err := os.WriteFile("cfg", []byte("invalid"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := New(); err == nil {
+		t.Fatal("expected an invalid cfg to fail")
+	}
+}
+
+// TestNew_Secrets ensures that the files in FUNC_SECRETS_DIR, such as a
+// mounted Kubernetes Secret, are included in the config keyed by file name,
+// and that environment variables take precedence over them.
+func TestNew_Secrets(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"API_KEY":        "s3cret\n",
+		"TEST_ENV":       "from_file",
+		".hidden":        "ignored",
+		"..data/API_KEY": "ignored",
+	}
+	for name, value := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(value), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	t.Setenv("FUNC_SECRETS_DIR", dir)
+	t.Setenv("TEST_ENV", "from_env")
+
+	cfg, err := New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v := cfg["API_KEY"]; v != "s3cret" {
+		t.Fatalf("expected secret API_KEY without trailing newline, got %q", v)
+	}
+	if v := cfg["TEST_ENV"]; v != "from_env" {
+		t.Fatalf("expected the environment to take precedence, got %q", v)
+	}
+	if _, ok := cfg[".hidden"]; ok {
+		t.Fatal("hidden file included in config")
+	}
+	if _, ok := cfg["..data"]; ok {
+		t.Fatal("directory included in config")
+	}
+
+	t.Setenv("FUNC_SECRETS_DIR", filepath.Join(dir, "missing"))
+	if _, err := New(); err == nil {
+		t.Fatal("expected a missing FUNC_SECRETS_DIR to fail")
+	}
+}
+
+// TestListenAddress ensures that LISTEN_ADDRESS takes precedence over the
+// default address.
+func TestListenAddress(t *testing.T) {
+	t.Setenv("LISTEN_ADDRESS", "")
+	if addr := ListenAddress("127.0.0.1:8080"); addr != "127.0.0.1:8080" {
+		t.Fatalf("expected the default address, got %q", addr)
+	}
+	t.Setenv("LISTEN_ADDRESS", "0.0.0.0:9090")
+	if addr := ListenAddress("127.0.0.1:8080"); addr != "0.0.0.0:9090" {
+		t.Fatalf("expected LISTEN_ADDRESS, got %q", addr)
+	}
+}
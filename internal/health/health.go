@@ -0,0 +1,74 @@
+// Package health responds to the readiness and liveness checks of a
+// function instance, for the middleware which serve health endpoints over
+// HTTP for use by probes, alongside consuming messages.
+package health
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+
+	"github.com/rs/zerolog/log"
+)
+
+// readinessReporter is an instance which reports its readiness, as the
+// ReadinessReporter of each middleware.
+type readinessReporter interface {
+	Ready(context.Context) (bool, error)
+}
+
+// livenessReporter is an instance which reports it is alive, as the
+// LivenessReporter of each middleware.
+type livenessReporter interface {
+	Alive(context.Context) (bool, error)
+}
+
+// NotReady responds to a readiness check with a 503, giving the reason.
+func NotReady(w http.ResponseWriter, reason string) {
+	log.Debug().Msg(reason)
+	w.WriteHeader(http.StatusServiceUnavailable)
+	fmt.Fprintln(w, reason)
+}
+
+// Ready responds to a readiness check of the function instance f, which is
+// ready unless it reports otherwise.
+func Ready(w http.ResponseWriter, r *http.Request, f any) {
+	if i, ok := f.(readinessReporter); ok {
+		ready, err := i.Ready(r.Context())
+		if err != nil {
+			message := "error checking readiness"
+			log.Debug().Err(err).Msg(message)
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, "error checking readiness: ", err.Error())
+			return
+		}
+		if !ready {
+			NotReady(w, "function not yet ready")
+			return
+		}
+	}
+	fmt.Fprintf(w, "READY")
+}
+
+// Alive responds to a liveness check of the function instance f, which is
+// alive unless it reports otherwise.
+func Alive(w http.ResponseWriter, r *http.Request, f any) {
+	if i, ok := f.(livenessReporter); ok {
+		alive, err := i.Alive(r.Context())
+		if err != nil {
+			message := "error checking liveness"
+			log.Err(err).Msg(message)
+			w.WriteHeader(http.StatusInternalServerError)
+			fmt.Fprint(w, "error checking liveness: ", err.Error())
+			return
+		}
+		if !alive {
+			message := "function not alive"
+			log.Debug().Msg(message)
+			w.WriteHeader(http.StatusServiceUnavailable)
+			_, _ = w.Write([]byte(message))
+			return
+		}
+	}
+	fmt.Fprintf(w, "ALIVE")
+}
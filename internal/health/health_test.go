@@ -0,0 +1,50 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// reporter is a function instance which reports its readiness and liveness.
+type reporter struct {
+	ok  bool
+	err error
+}
+
+func (r reporter) Ready(context.Context) (bool, error) { return r.ok, r.err }
+func (r reporter) Alive(context.Context) (bool, error) { return r.ok, r.err }
+
+// TestReadyAlive ensures that the health of an instance is as it reports,
+// and that an instance which does not report its health is healthy.
+func TestReadyAlive(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		f      any
+		status int
+	}{
+		{name: "not a reporter", f: struct{}{}, status: http.StatusOK},
+		{name: "healthy", f: reporter{ok: true}, status: http.StatusOK},
+		{name: "unhealthy", f: reporter{}, status: http.StatusServiceUnavailable},
+		{name: "error", f: reporter{err: errors.New("example")}, status: http.StatusInternalServerError},
+	} {
+		for check, handle := range map[string]func(http.ResponseWriter, *http.Request, any){
+			"readiness": Ready,
+			"liveness":  Alive,
+		} {
+			w := httptest.NewRecorder()
+			handle(w, httptest.NewRequest(http.MethodGet, "/health/"+check, nil), tc.f)
+			if w.Code != tc.status {
+				t.Errorf("%v %v: expected %v, got %v", tc.name, check, tc.status, w.Code)
+			}
+		}
+	}
+
+	w := httptest.NewRecorder()
+	NotReady(w, "example reason")
+	if w.Code != http.StatusServiceUnavailable || w.Body.String() != "example reason\n" {
+		t.Fatalf("expected a 503 with the reason, got %v %q", w.Code, w.Body.String())
+	}
+}
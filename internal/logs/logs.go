@@ -0,0 +1,78 @@
+// Package logs configures the global logger of the middleware, whose
+// exported log settings delegate to it, and redacts the values they log.
+package logs
+
+import (
+	"context"
+	"io"
+	"net/url"
+	"os"
+	"strings"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// Output is the writer to which logs are written, replaced by tests.
+var Output io.Writer = os.Stderr
+
+// Format of the logs written, as the logFormat of each middleware.
+type Format int
+
+const (
+	JSON Format = iota
+	Console
+)
+
+// SetFormat replaces the global logger with one writing the given format
+// to Output.
+func SetFormat(f Format) {
+	switch f {
+	case Console:
+		log.Logger = zerolog.New(zerolog.ConsoleWriter{Out: Output}).With().Timestamp().Logger()
+	default:
+		log.Logger = zerolog.New(Output).With().Timestamp().Logger()
+	}
+}
+
+// FormatFromEnv returns the format requested by FUNC_LOG_FORMAT ("json" or
+// "console"), or def if it is unset or unrecognized.
+func FormatFromEnv(def Format) Format {
+	switch strings.ToLower(os.Getenv("FUNC_LOG_FORMAT")) {
+	case "json":
+		return JSON
+	case "console":
+		return Console
+	default:
+		return def
+	}
+}
+
+// FromContext returns the logger of the given context, or the global logger
+// if the context has none.
+func FromContext(ctx context.Context) zerolog.Logger {
+	if l := zerolog.Ctx(ctx); l != zerolog.Ctx(context.Background()) {
+		return *l
+	}
+	return log.Logger
+}
+
+// RedactURL returns the URL with any credentials it contains replaced.  A
+// value which is not a URL, such as a host:port address, is returned as-is.
+func RedactURL(s string) string {
+	if !strings.Contains(s, "://") {
+		return s
+	}
+	u, err := url.Parse(s)
+	if err != nil {
+		return "(invalid URL)"
+	}
+	if u.User != nil {
+		if _, ok := u.User.Password(); ok {
+			u.User = url.UserPassword(u.User.Username(), "xxxxx")
+		} else {
+			u.User = url.User("xxxxx") // such as a token
+		}
+	}
+	return u.String()
+}
@@ -0,0 +1,69 @@
+// Package shutdown aggregates the errors which occur in stopping a service,
+// and runs its shutdown hooks, such that both are done the same way by all
+// middleware.
+package shutdown
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strings"
+	"time"
+)
+
+// Error is the error of a service when more than one error occurs in
+// stopping it, exported by each middleware as its ShutdownError.  Errors
+// are in order of precedence, and errors.Is and errors.As consider each of
+// them.
+type Error struct {
+	Errors []error
+}
+
+func (e *Error) Error() string {
+	ss := make([]string, len(e.Errors))
+	for i, err := range e.Errors {
+		ss[i] = err.Error()
+	}
+	return "shutdown errors: " + strings.Join(ss, "; ")
+}
+
+func (e *Error) Unwrap() []error {
+	return e.Errors
+}
+
+// Errors returns the errors which it is passed, ignoring those which are
+// nil or benign (a listener already closed): nil if none remain, the error
+// itself if only one, or an *Error of all in order otherwise.  The errors
+// of an *Error passed are included in its place.
+func Errors(ee ...error) error {
+	var errs []error
+	for _, e := range ee {
+		var se *Error
+		switch {
+		case e == nil || errors.Is(e, net.ErrClosed):
+		case errors.As(e, &se):
+			errs = append(errs, se.Errors...)
+		default:
+			errs = append(errs, e)
+		}
+	}
+	switch len(errs) {
+	case 0:
+		return nil
+	case 1:
+		return errs[0]
+	}
+	return &Error{Errors: errs}
+}
+
+// RunHooks invokes each shutdown hook in turn, each with up to timeout to
+// complete, returning their errors.
+func RunHooks(hooks []func(context.Context) error, timeout time.Duration) error {
+	var errs []error
+	for _, fn := range hooks {
+		ctx, cancel := context.WithTimeout(context.Background(), timeout)
+		errs = append(errs, fn(ctx))
+		cancel()
+	}
+	return Errors(errs...)
+}
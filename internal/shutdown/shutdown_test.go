@@ -0,0 +1,67 @@
+package shutdown
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+// TestErrors ensures that nil and benign errors are ignored, that a single
+// error is returned as-is, and that the errors of an *Error are flattened
+// in order.
+func TestErrors(t *testing.T) {
+	var (
+		errA = errors.New("a")
+		errB = errors.New("b")
+		errC = errors.New("c")
+	)
+	if err := Errors(nil, fmt.Errorf("closing: %w", net.ErrClosed)); err != nil {
+		t.Fatalf("expected no error, got %v", err)
+	}
+	if err := Errors(nil, errA, net.ErrClosed); err != errA {
+		t.Fatalf("expected a single error to be returned as-is, got %v", err)
+	}
+
+	err := Errors(errA, Errors(errB, errC))
+	var se *Error
+	if !errors.As(err, &se) {
+		t.Fatalf("expected an *Error, got %v", err)
+	}
+	if len(se.Errors) != 3 || se.Errors[0] != errA || se.Errors[1] != errB || se.Errors[2] != errC {
+		t.Fatalf("unexpected errors %v", se.Errors)
+	}
+	if !errors.Is(err, errB) {
+		t.Fatalf("expected errors.Is to match each error, got %v", err)
+	}
+	if err.Error() != "shutdown errors: a; b; c" {
+		t.Fatalf("unexpected message %q", err.Error())
+	}
+}
+
+// TestRunHooks ensures that each hook is invoked in turn with a deadline,
+// and that their errors are returned.
+func TestRunHooks(t *testing.T) {
+	var (
+		invoked []int
+		errHook = errors.New("hook failed")
+	)
+	hook := func(i int, err error) func(context.Context) error {
+		return func(ctx context.Context) error {
+			if _, ok := ctx.Deadline(); !ok {
+				t.Errorf("hook %v invoked without a deadline", i)
+			}
+			invoked = append(invoked, i)
+			return err
+		}
+	}
+	err := RunHooks([]func(context.Context) error{hook(1, nil), hook(2, errHook), hook(3, nil)}, time.Second)
+	if err != errHook {
+		t.Fatalf("expected the error of the hook, got %v", err)
+	}
+	if fmt.Sprint(invoked) != "[1 2 3]" {
+		t.Fatalf("expected hooks invoked in order, got %v", invoked)
+	}
+}
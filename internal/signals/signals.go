@@ -0,0 +1,107 @@
+// Package signals dispatches the signals received by the process to the
+// services running in it, such that services of any of the middleware (for
+// example an HTTP and a CloudEvents service in one binary) share a single
+// registration with os/signal.
+package signals
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/rs/zerolog/log"
+)
+
+// dispatcher delivers signals to the handlers registered.  Signals are only
+// caught while at least one registration exists.
+var dispatcher struct {
+	mu            sync.Mutex
+	sigs          chan os.Signal
+	registrations map[*registration]struct{}
+}
+
+// registration is the handlers of one service, by signal.
+type registration struct {
+	handlers map[os.Signal]func()
+}
+
+// Register invokes the handler of each signal received by the process until
+// the returned function is invoked.  Handlers of all registrations are
+// invoked in turn on a single routine, so should not block.  Signals
+// without a handler, such as the SIGURG used by the Go runtime for
+// preemption, are ignored.
+func Register(handlers map[os.Signal]func()) (unregister func()) {
+	reg := &registration{handlers: handlers}
+
+	dispatcher.mu.Lock()
+	defer dispatcher.mu.Unlock()
+	if dispatcher.sigs == nil {
+		dispatcher.sigs = make(chan os.Signal, 2)
+		dispatcher.registrations = map[*registration]struct{}{}
+		go dispatch(dispatcher.sigs)
+	}
+	if len(dispatcher.registrations) == 0 {
+		signal.Notify(dispatcher.sigs)
+	}
+	dispatcher.registrations[reg] = struct{}{}
+
+	return sync.OnceFunc(func() {
+		dispatcher.mu.Lock()
+		defer dispatcher.mu.Unlock()
+		delete(dispatcher.registrations, reg)
+		if len(dispatcher.registrations) == 0 {
+			signal.Stop(dispatcher.sigs)
+		}
+	})
+}
+
+// Handle registers the signal handlers of a service until the returned
+// function is invoked: for SIGINT and SIGTERM by default, sending nil on
+// stop such that the service stops.  The given handlers are registered in
+// addition, replacing the default of SIGINT or SIGTERM if for either.
+func Handle(stop chan<- error, handlers map[os.Signal]func()) (unregister func()) {
+	done := make(chan struct{})
+	stopFn := func() {
+		go func() { // such that other services' handlers are not delayed
+			select {
+			case stop <- nil:
+			case <-done: // already stopped
+			}
+		}()
+	}
+	hh := map[os.Signal]func(){
+		syscall.SIGINT:  stopFn,
+		syscall.SIGTERM: stopFn,
+	}
+	for sig, fn := range handlers {
+		hh[sig] = fn
+	}
+
+	unregisterHandlers := Register(hh)
+	return func() {
+		unregisterHandlers()
+		close(done)
+	}
+}
+
+// dispatch invokes the handlers of each registration for each signal
+// received.
+func dispatch(sigs <-chan os.Signal) {
+	for sig := range sigs {
+		var fns []func()
+		dispatcher.mu.Lock()
+		for reg := range dispatcher.registrations {
+			if fn, ok := reg.handlers[sig]; ok {
+				fns = append(fns, fn)
+			}
+		}
+		dispatcher.mu.Unlock()
+		if len(fns) > 0 {
+			log.Debug().Any("signal", sig).Msg("signal received")
+		}
+		for _, fn := range fns {
+			fn()
+		}
+	}
+}
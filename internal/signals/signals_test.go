@@ -0,0 +1,110 @@
+package signals_test
+
+import (
+	"context"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/cloudevents/sdk-go/v2/event"
+
+	ce "knative.dev/func-go/cloudevents"
+	cemock "knative.dev/func-go/cloudevents/mock"
+	fn "knative.dev/func-go/http"
+	fnmock "knative.dev/func-go/http/mock"
+)
+
+// TestServicesOfEachMiddleware ensures that an HTTP and a CloudEvents
+// service in one process each invoke their own handlers upon a signal, and
+// each stop cleanly, and only once, upon SIGTERM.
+func TestServicesOfEachMiddleware(t *testing.T) {
+	// Ensure the test process is not terminated should the signal be sent
+	// before the services are handling signals.
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGTERM, syscall.SIGUSR1)
+	defer signal.Stop(sigs)
+
+	var (
+		stops   atomic.Int32
+		usr1    [2]atomic.Int32
+		errCh   = make(chan error, 2)
+		started = make(chan any, 2)
+		onStart = func(context.Context, map[string]string) error {
+			started <- true
+			return nil
+		}
+		onStop = func(context.Context) error {
+			stops.Add(1)
+			return nil
+		}
+	)
+	httpService := fn.New(&fnmock.Function{
+		OnStart:  onStart,
+		OnStop:   onStop,
+		OnHandle: func(http.ResponseWriter, *http.Request) {},
+	}, fn.WithListenAddress("127.0.0.1:0"), fn.WithSignalHandler(syscall.SIGUSR1, func() { usr1[0].Add(1) }))
+	ceService := ce.New(&cemock.Function{
+		OnStart: onStart,
+		OnStop:  onStop,
+		OnHandle: func(context.Context, event.Event) (*event.Event, error) {
+			return nil, nil
+		},
+	}, ce.WithListenAddress("127.0.0.1:0"), ce.WithSignalHandler(syscall.SIGUSR1, func() { usr1[1].Add(1) }))
+	go func() { errCh <- httpService.Start(context.Background()) }()
+	go func() { errCh <- ceService.Start(context.Background()) }()
+	for i := 0; i < 2; i++ {
+		<-started
+	}
+
+	// Signal until both handlers are invoked, as the services may not yet
+	// be handling signals.  Neither is stopped.
+	ticker := time.NewTicker(10 * time.Millisecond)
+	defer ticker.Stop()
+	timeout := time.After(2 * time.Second)
+	for usr1[0].Load() == 0 || usr1[1].Load() == 0 {
+		select {
+		case <-ticker.C:
+			if err := syscall.Kill(os.Getpid(), syscall.SIGUSR1); err != nil {
+				t.Fatal(err)
+			}
+		case err := <-errCh:
+			t.Fatalf("service stopped upon SIGUSR1: %v", err)
+		case <-timeout:
+			t.Fatal("SIGUSR1 not handled by both services")
+		}
+	}
+
+	// Both stop upon SIGTERM.
+	if err := syscall.Kill(os.Getpid(), syscall.SIGTERM); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-errCh:
+			if err != nil {
+				t.Fatal(err)
+			}
+		case <-time.After(2 * time.Second):
+			t.Fatal("services not stopped")
+		}
+	}
+	if got := stops.Load(); got != 2 {
+		t.Fatalf("expected each service stopped once, got %v stops", got)
+	}
+
+	// Await delivery of any signal still in flight, such that it does not
+	// terminate the test process once no longer caught.
+	for quiet := time.After(50 * time.Millisecond); ; {
+		select {
+		case <-sigs:
+			quiet = time.After(50 * time.Millisecond)
+			continue
+		case <-quiet:
+		}
+		break
+	}
+}
@@ -0,0 +1,18 @@
+package mqtt
+
+import (
+	"context"
+	"maps"
+)
+
+type configKey struct{}
+
+// ConfigFromContext returns the function's config, as passed to its Start
+// hook, from the context with which a message is handled.  This allows a
+// function which does not implement Start, such as a static function, to
+// read its config.  It returns nil if the context is not that of a message.
+// The map returned is a copy, which the function may modify.
+func ConfigFromContext(ctx context.Context) map[string]string {
+	cfg, _ := ctx.Value(configKey{}).(map[string]string)
+	return maps.Clone(cfg)
+}
@@ -0,0 +1,50 @@
+package mqtt
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	paho "github.com/eclipse/paho.mqtt.golang"
+)
+
+// errMalformedEvent is returned when a message can not be decoded as a
+// CloudEvent, such that it is discarded rather than redelivered.
+var errMalformedEvent = errors.New("malformed CloudEvent")
+
+// handleFn is the single handler signature to which each of the supported
+// function types is adapted.
+type handleFn func(context.Context, paho.Message) error
+
+// newHandleFn adapts f, which must implement either Handler or
+// CloudEventHandler, to a handleFn.
+func newHandleFn(f any) (handleFn, error) {
+	switch h := f.(type) {
+	case Handler:
+		return h.Handle, nil
+	case CloudEventHandler:
+		return func(ctx context.Context, m paho.Message) error {
+			e, err := toEvent(m)
+			if err != nil {
+				return err
+			}
+			return h.Handle(ctx, e)
+		}, nil
+	default:
+		return nil, ErrNoHandler
+	}
+}
+
+// toEvent decodes a CloudEvent from the payload of a message in structured
+// content mode.
+func toEvent(m paho.Message) (e cloudevents.Event, err error) {
+	if err = json.Unmarshal(m.Payload(), &e); err == nil {
+		err = e.Validate()
+	}
+	if err != nil {
+		return e, fmt.Errorf("%w: %v", errMalformedEvent, err)
+	}
+	return
+}
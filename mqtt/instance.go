@@ -0,0 +1,67 @@
+package mqtt
+
+import (
+	"context"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	paho "github.com/eclipse/paho.mqtt.golang"
+)
+
+// Handler is a function instance which can handle an MQTT message.
+//
+// Messages received with QoS 1 or 2 are acknowledged when Handle returns
+// without error.  Otherwise they are not acknowledged, such that the broker
+// redelivers them when the session is resumed, as MQTT has no negative
+// acknowledgement.  The message's own Ack method should therefore not be
+// used.
+type Handler interface {
+	// Handle a message.
+	Handle(context.Context, paho.Message) error
+}
+
+type HandleFunc func(context.Context, paho.Message) error
+
+// CloudEventHandler is a function instance which can handle a CloudEvent
+// received as an MQTT message in structured content mode, that being the
+// only mode supported by MQTT 3.1.1: the message's payload is the JSON
+// encoded event.
+//
+// A function must implement either Handler or CloudEventHandler.
+type CloudEventHandler interface {
+	// Handle an event.
+	Handle(context.Context, cloudevents.Event) error
+}
+
+// Starter is an instance which has defined the Start hook
+type Starter interface {
+	// Start instance event hook.
+	Start(context.Context, map[string]string) error
+}
+
+// Stopper is an instance which has defined the  Stop hook
+type Stopper interface {
+	// Stop instance event hook.
+	Stop(context.Context) error
+}
+
+// ReadinessReporter is an instance which reports its readiness.
+type ReadinessReporter interface {
+	// Ready to be invoked or not.
+	Ready(context.Context) (bool, error)
+}
+
+// LivenessReporter is an instance which reports it is alive.
+type LivenessReporter interface {
+	// Alive allows the instance to report it's liveness status.
+	Alive(context.Context) (bool, error)
+}
+
+// DefaultHandler is used for simple static function implementations which
+// need only define a single exported function named Handle of type HandleFunc.
+type DefaultHandler struct {
+	Handler HandleFunc
+}
+
+func (f DefaultHandler) Handle(ctx context.Context, m paho.Message) error {
+	return f.Handler(ctx, m)
+}
@@ -0,0 +1,4 @@
+package mqtt
+
+// Option configures a Service.
+type Option func(*Service)
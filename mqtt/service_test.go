@@ -0,0 +1,248 @@
+package mqtt
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	paho "github.com/eclipse/paho.mqtt.golang"
+	"knative.dev/func-go/mqtt/mock"
+)
+
+// fakeMsg is a received message which reports whether it was acknowledged.
+type fakeMsg struct {
+	payload []byte
+	acked   chan struct{}
+}
+
+func newFakeMsg(payload string) *fakeMsg {
+	return &fakeMsg{payload: []byte(payload), acked: make(chan struct{})}
+}
+
+func (m *fakeMsg) Duplicate() bool   { return false }
+func (m *fakeMsg) Qos() byte         { return 1 }
+func (m *fakeMsg) Retained() bool    { return false }
+func (m *fakeMsg) Topic() string     { return "example/topic" }
+func (m *fakeMsg) MessageID() uint16 { return 1 }
+func (m *fakeMsg) Payload() []byte   { return m.payload }
+func (m *fakeMsg) Ack()              { close(m.acked) }
+
+// isAcked returns whether the message is acknowledged within the given time.
+func (m *fakeMsg) isAcked(wait time.Duration) bool {
+	select {
+	case <-m.acked:
+		return true
+	case <-time.After(wait):
+		return false
+	}
+}
+
+// fakeSubscriber delivers messages sent on msgs in place of a broker.
+type fakeSubscriber struct {
+	msgs      chan paho.Message
+	cfg       mqttConfig
+	connected atomic.Bool
+}
+
+func (f *fakeSubscriber) subscribe(_ context.Context, cfg mqttConfig, handle func(paho.Message)) (func() bool, func(), error) {
+	f.cfg = cfg
+	f.connected.Store(true)
+	done := make(chan struct{})
+	go func() {
+		for {
+			select {
+			case m := <-f.msgs:
+				go handle(m)
+			case <-done:
+				return
+			}
+		}
+	}()
+	return f.connected.Load, func() { close(done) }, nil
+}
+
+// startService starts a Service for the given function using a fake
+// subscriber, returning once it has subscribed.  The service is stopped when
+// the test completes.
+func startService(t *testing.T, f any, options ...Option) (*Service, *fakeSubscriber) {
+	t.Helper()
+	t.Setenv("LISTEN_ADDRESS", "127.0.0.1:") // use an OS-chosen port
+	t.Setenv("MQTT_TOPICS", "example/topic, other/+")
+
+	var (
+		ctx, cancel = context.WithCancel(context.Background())
+		errCh       = make(chan error, 1)
+		sub         = &fakeSubscriber{msgs: make(chan paho.Message)}
+	)
+	service := New(f, append(options, func(s *Service) { s.subscribe = sub.subscribe })...)
+	go func() {
+		errCh <- service.Start(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		select {
+		case <-errCh:
+		case <-time.After(time.Second):
+			t.Error("service failed to stop")
+		}
+	})
+
+	select {
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("function failed to subscribe")
+	case err := <-errCh:
+		t.Fatal(err)
+	case <-service.started:
+	}
+	return service, sub
+}
+
+// TestStart_Invoked ensures that the Start method of a function is invoked
+// before subscribing, and that the subscription is configured from the
+// environment.
+func TestStart_Invoked(t *testing.T) {
+	var started bool
+	f := &mock.Function{OnStart: func(context.Context, map[string]string) error {
+		started = true
+		return nil
+	}}
+	_, sub := startService(t, f)
+	if !started {
+		t.Fatal("function was subscribed before being started")
+	}
+	if len(sub.cfg.topics) != 2 || sub.cfg.topics[0] != "example/topic" || sub.cfg.topics[1] != "other/+" {
+		t.Fatalf("unexpected topics %q", sub.cfg.topics)
+	}
+	hostname, _ := os.Hostname()
+	if sub.cfg.broker != DefaultBroker || sub.cfg.qos != DefaultQoS || sub.cfg.clientID != hostname {
+		t.Fatalf("unexpected config %+v", sub.cfg)
+	}
+}
+
+// TestStart_Errors ensures that a function which implements no handler, or
+// which is started without topics or with an invalid QoS, fails to start.
+func TestStart_Errors(t *testing.T) {
+	t.Setenv("MQTT_TOPICS", "example/topic")
+	if err := New(struct{}{}).Start(context.Background()); !errors.Is(err, ErrNoHandler) {
+		t.Fatalf("expected ErrNoHandler, got %v", err)
+	}
+	t.Setenv("MQTT_QOS", "3")
+	if err := New(&mock.Function{}).Start(context.Background()); err == nil {
+		t.Fatal("expected an invalid MQTT_QOS to fail")
+	}
+	t.Setenv("MQTT_TOPICS", "")
+	if err := New(&mock.Function{}).Start(context.Background()); !errors.Is(err, ErrNoTopics) {
+		t.Fatalf("expected ErrNoTopics, got %v", err)
+	}
+}
+
+// TestHandle_Ack ensures that messages are acknowledged when handled
+// successfully, and not acknowledged otherwise.
+func TestHandle_Ack(t *testing.T) {
+	handled := make(chan string, 1)
+	f := &mock.Function{OnHandle: func(_ context.Context, m paho.Message) error {
+		defer func() { handled <- string(m.Payload()) }()
+		if string(m.Payload()) == "fail" {
+			return errors.New("handler error")
+		}
+		return nil
+	}}
+	_, sub := startService(t, f)
+
+	ok := newFakeMsg("ok")
+	sub.msgs <- ok
+	<-handled
+	if !ok.isAcked(time.Second) {
+		t.Fatal("message not acknowledged")
+	}
+	fail := newFakeMsg("fail")
+	sub.msgs <- fail
+	<-handled
+	if fail.isAcked(50 * time.Millisecond) {
+		t.Fatal("failed message acknowledged")
+	}
+}
+
+// ceFunction is a function which handles CloudEvents.
+type ceFunction struct {
+	events chan cloudevents.Event
+}
+
+func (f *ceFunction) Handle(_ context.Context, e cloudevents.Event) error {
+	f.events <- e
+	return nil
+}
+
+// TestHandle_CloudEvent ensures that CloudEvents are decoded from messages in
+// structured content mode, and that malformed events are discarded.
+func TestHandle_CloudEvent(t *testing.T) {
+	f := &ceFunction{events: make(chan cloudevents.Event, 1)}
+	_, sub := startService(t, f)
+
+	structured := newFakeMsg(`{"specversion":"1.0","id":"1","source":"example/uri","type":"example.type","data":"hello"}`)
+	sub.msgs <- structured
+	if e := <-f.events; e.ID() != "1" || e.Source() != "example/uri" || e.Type() != "example.type" {
+		t.Fatalf("unexpected event %v", e)
+	}
+	if !structured.isAcked(time.Second) {
+		t.Fatal("message not acknowledged")
+	}
+
+	malformed := newFakeMsg(`{"specversion":"1.0","id":"2"}`) // no source or type
+	sub.msgs <- malformed
+	if !malformed.isAcked(time.Second) {
+		t.Fatal("malformed event not discarded")
+	}
+	select {
+	case e := <-f.events:
+		t.Fatalf("malformed event handled %v", e)
+	default:
+	}
+}
+
+// TestReady ensures that the function reports ready once subscribed, and not
+// ready while reconnecting to the broker.
+func TestReady(t *testing.T) {
+	service, sub := startService(t, &mock.Function{})
+	ready := func() int {
+		resp, err := http.Get("http://" + service.Addr().String() + "/health/readiness")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		_, _ = io.Copy(io.Discard, resp.Body)
+		return resp.StatusCode
+	}
+	if code := ready(); code != http.StatusOK {
+		t.Fatalf("unexpected http status code: %v", code)
+	}
+	sub.connected.Store(false)
+	if code := ready(); code != http.StatusServiceUnavailable {
+		t.Fatalf("expected not ready while reconnecting, got %v", code)
+	}
+}
+
+// TestConfigFromContext ensures that the function's config is available to
+// its handler using ConfigFromContext.
+func TestConfigFromContext(t *testing.T) {
+	t.Setenv("TEST_CONFIG_VALUE", "example")
+	values := make(chan string, 1)
+	f := &mock.Function{OnHandle: func(ctx context.Context, _ paho.Message) error {
+		values <- ConfigFromContext(ctx)["TEST_CONFIG_VALUE"]
+		return nil
+	}}
+	_, sub := startService(t, f)
+
+	m := newFakeMsg("hello")
+	sub.msgs <- m
+	if v := <-values; v != "example" {
+		t.Fatalf("unexpected config value: %q", v)
+	}
+	m.isAcked(time.Second)
+}
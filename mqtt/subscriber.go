@@ -0,0 +1,110 @@
+package mqtt
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+	"github.com/rs/zerolog/log"
+)
+
+// disconnectQuiesce is the time in milliseconds allowed for work in
+// progress, such as acknowledgements, to complete when disconnecting.
+const disconnectQuiesce = 250
+
+// mqttConfig is the configuration of the subscription, read from the
+// environment (see Service.Start).
+type mqttConfig struct {
+	broker   string
+	topics   []string
+	qos      byte
+	clientID string
+}
+
+// newMqttConfig reads the configuration of the subscription from the
+// environment.
+func newMqttConfig() (cfg mqttConfig, err error) {
+	cfg = mqttConfig{
+		broker:   os.Getenv("MQTT_BROKER"),
+		qos:      DefaultQoS,
+		clientID: os.Getenv("MQTT_CLIENT_ID"),
+	}
+	if cfg.broker == "" {
+		cfg.broker = DefaultBroker
+	}
+	for _, topic := range strings.Split(os.Getenv("MQTT_TOPICS"), ",") {
+		if topic = strings.TrimSpace(topic); topic != "" {
+			cfg.topics = append(cfg.topics, topic)
+		}
+	}
+	if len(cfg.topics) == 0 {
+		return cfg, ErrNoTopics
+	}
+	if v := os.Getenv("MQTT_QOS"); v != "" {
+		qos, err := strconv.Atoi(v)
+		if err != nil || qos < 0 || qos > 2 {
+			return cfg, fmt.Errorf("invalid MQTT_QOS %q: must be 0, 1 or 2", v)
+		}
+		cfg.qos = byte(qos)
+	}
+	if cfg.clientID == "" {
+		if cfg.clientID, err = os.Hostname(); err != nil {
+			return cfg, fmt.Errorf("MQTT_CLIENT_ID not set, and hostname unavailable: %w", err)
+		}
+	}
+	return
+}
+
+// subscriber subscribes to the configured topics, invoking handle for each
+// message received, possibly concurrently.  The returned connected function
+// reports whether the client is connected rather than reconnecting, and the
+// disconnect function disconnects once messages in-flight are acknowledged.
+type subscriber func(ctx context.Context, cfg mqttConfig, handle func(paho.Message)) (connected func() bool, disconnect func(), err error)
+
+// pahoSubscriber subscribes using a persistent session, such that messages
+// published with QoS 1 or 2 while disconnected, or which were not
+// acknowledged, are delivered once reconnected.  The client reconnects
+// automatically, and resubscribes on each connection.
+func pahoSubscriber(_ context.Context, cfg mqttConfig, handle func(paho.Message)) (connected func() bool, disconnect func(), err error) {
+	filters := make(map[string]byte, len(cfg.topics))
+	for _, topic := range cfg.topics {
+		filters[topic] = cfg.qos
+	}
+	subscribed := make(chan error, 1)
+	opts := paho.NewClientOptions().
+		AddBroker(cfg.broker).
+		SetClientID(cfg.clientID).
+		SetCleanSession(false).
+		SetAutoAckDisabled(true).
+		SetOrderMatters(false).
+		SetAutoReconnect(true).
+		SetOnConnectHandler(func(c paho.Client) {
+			t := c.SubscribeMultiple(filters, func(_ paho.Client, m paho.Message) { handle(m) })
+			t.Wait()
+			if err := t.Error(); err != nil {
+				log.Error().Err(err).Strs("topics", cfg.topics).Msg("error subscribing")
+			}
+			select {
+			case subscribed <- t.Error(): // to the first connection
+			default:
+			}
+		}).
+		SetConnectionLostHandler(func(_ paho.Client, err error) {
+			log.Warn().Err(err).Msg("connection to broker lost, reconnecting")
+		}).
+		SetReconnectingHandler(func(paho.Client, *paho.ClientOptions) {
+			log.Debug().Msg("reconnecting to broker")
+		})
+	client := paho.NewClient(opts)
+	if t := client.Connect(); t.Wait() && t.Error() != nil {
+		return nil, nil, t.Error()
+	}
+	if err = <-subscribed; err != nil {
+		client.Disconnect(0)
+		return nil, nil, err
+	}
+	return client.IsConnectionOpen, func() { client.Disconnect(disconnectQuiesce) }, nil
+}
@@ -0,0 +1,25 @@
+package mqtt
+
+import (
+	"fmt"
+
+	"github.com/rs/zerolog/log"
+
+	"knative.dev/func-go/internal/logs"
+)
+
+// logSummary logs the effective configuration of the service once
+// listening, such that misconfiguration (for example of the environment)
+// is apparent from a single log line.  Credentials in the broker URL are
+// redacted.
+func (s *Service) logSummary(cfg mqttConfig) {
+	log.Info().
+		Str("address", s.listener.Addr().String()).
+		Str("broker", logs.RedactURL(cfg.broker)).
+		Strs("topics", cfg.topics).
+		Uint8("qos", cfg.qos).
+		Str("client_id", cfg.clientID).
+		Str("handler", fmt.Sprintf("%T", s.f)).
+		Strs("implements", implemented(s.f)).
+		Msg("function runtime configured")
+}
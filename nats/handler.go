@@ -0,0 +1,108 @@
+package nats
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	natsio "github.com/nats-io/nats.go"
+)
+
+// errMalformedEvent is returned when a message can not be decoded as a
+// CloudEvent, such that it is terminated rather than redelivered.
+var errMalformedEvent = errors.New("malformed CloudEvent")
+
+// handleFn is the single handler signature to which each of the supported
+// function types is adapted.
+type handleFn func(context.Context, *natsio.Msg) error
+
+// newHandleFn adapts f, which must implement either Handler or
+// CloudEventHandler, to a handleFn.
+func newHandleFn(f any) (handleFn, error) {
+	switch h := f.(type) {
+	case Handler:
+		return h.Handle, nil
+	case CloudEventHandler:
+		return func(ctx context.Context, msg *natsio.Msg) error {
+			e, err := toEvent(msg)
+			if err != nil {
+				return err
+			}
+			return h.Handle(ctx, e)
+		}, nil
+	default:
+		return nil, ErrNoHandler
+	}
+}
+
+// toEvent decodes a CloudEvent from a message in either structured content
+// mode (a Content-Type of application/cloudevents+json), or binary content
+// mode, in which attributes are headers prefixed "ce-" and the message data
+// is the event's data.
+func toEvent(msg *natsio.Msg) (e cloudevents.Event, err error) {
+	ct := header(msg.Header, "Content-Type")
+	if strings.HasPrefix(ct, cloudevents.ApplicationCloudEventsJSON) {
+		err = json.Unmarshal(msg.Data, &e)
+	} else {
+		e, err = toBinaryEvent(msg, ct)
+	}
+	if err == nil {
+		err = e.Validate()
+	}
+	if err != nil {
+		return e, fmt.Errorf("%w: %v", errMalformedEvent, err)
+	}
+	return
+}
+
+func toBinaryEvent(msg *natsio.Msg, ct string) (e cloudevents.Event, err error) {
+	e = cloudevents.NewEvent()
+	if v := header(msg.Header, "ce-specversion"); v != "" {
+		e.SetSpecVersion(v) // before any other attributes
+	}
+	for k, vv := range msg.Header {
+		name := strings.ToLower(k)
+		if !strings.HasPrefix(name, "ce-") || len(vv) == 0 {
+			continue
+		}
+		v := vv[0]
+		switch attr := strings.TrimPrefix(name, "ce-"); attr {
+		case "specversion":
+		case "id":
+			e.SetID(v)
+		case "source":
+			e.SetSource(v)
+		case "type":
+			e.SetType(v)
+		case "subject":
+			e.SetSubject(v)
+		case "dataschema":
+			e.SetDataSchema(v)
+		case "time":
+			t, err := time.Parse(time.RFC3339Nano, v)
+			if err != nil {
+				return e, fmt.Errorf("invalid time %q: %w", v, err)
+			}
+			e.SetTime(t)
+		default:
+			e.SetExtension(attr, v)
+		}
+	}
+	err = e.SetData(ct, msg.Data)
+	return
+}
+
+// header returns the first value of the header with the given key, which
+// is matched case-insensitively.
+func header(h natsio.Header, key string) string {
+	for k, vv := range h {
+		if strings.EqualFold(k, key) && len(vv) > 0 {
+			return vv[0]
+		}
+	}
+	return ""
+}
@@ -0,0 +1,4 @@
+package nats
+
+// Option configures a Service.
+type Option func(*Service)
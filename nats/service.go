@@ -0,0 +1,419 @@
+// Package nats implements a Functions NATS JetStream middleware for use by
+// scaffolding which exposes a function as a consumer of messages published
+// to one or more subjects.
+package nats
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	natsio "github.com/nats-io/nats.go"
+	"github.com/rs/zerolog/log"
+
+	"knative.dev/func-go/internal/config"
+	"knative.dev/func-go/internal/health"
+	"knative.dev/func-go/internal/shutdown"
+	"knative.dev/func-go/internal/signals"
+)
+
+const (
+	DefaultLogLevel      = LogDebug
+	DefaultLogFormat     = LogJSON
+	DefaultListenAddress = "127.0.0.1:8080"
+	DefaultURL           = natsio.DefaultURL
+)
+
+const (
+	ServerShutdownTimeout = 30 * time.Second
+	InstanceStopTimeout   = 30 * time.Second
+)
+
+// ErrNoHandler is returned when starting a function which implements
+// neither Handler nor CloudEventHandler.
+var ErrNoHandler = errors.New("function must implement either Handler or CloudEventHandler")
+
+// ErrNoSubjects is returned when starting a function without any subjects
+// to which to subscribe (see NATS_SUBJECTS).
+var ErrNoSubjects = errors.New("NATS_SUBJECTS must list at least one subject")
+
+// Start an intance using a new Service
+// Note that this accepts ANY because a function may implement either
+// Handler or CloudEventHandler.
+func Start(f any) error {
+	log.Debug().Msg("func runtime creating function instance")
+	return New(f).Start(context.Background())
+}
+
+// Service exposes a Function Instance as a consumer of NATS JetStream
+// messages.  Health endpoints are served over HTTP for use by probes.
+type Service struct {
+	http.Server
+	listener  net.Listener
+	stop      chan error
+	f         any
+	cfg       map[string]string // with which the instance was started
+	subscribe subscriber
+
+	signalHandlers map[os.Signal]func()          // by WithSignalHandler
+	shutdownHooks  []func(context.Context) error // by WithShutdownHook
+
+	// subscription and in-flight message tracking, such that shutdown can
+	// unsubscribe and await their completion.
+	mu          sync.Mutex
+	unsubscribe func()
+	inflight    sync.WaitGroup
+	stopped     bool
+
+	started     chan struct{}
+	startCancel context.CancelFunc // of the routine starting the instance
+	startDone   chan struct{}      // closed when it returns
+	stopping    chan struct{}      // closed by shutdown
+}
+
+// New Service which serves the given instance.
+func New(f any, options ...Option) *Service {
+	svc := &Service{
+		f:         f,
+		stop:      make(chan error),
+		started:   make(chan struct{}),
+		stopping:  make(chan struct{}),
+		subscribe: jetStreamSubscriber,
+		Server: http.Server{
+			ReadTimeout:       30 * time.Second,
+			WriteTimeout:      30 * time.Second,
+			IdleTimeout:       30 * time.Second,
+			MaxHeaderBytes:    1 << 20,
+			ReadHeaderTimeout: 2 * time.Second,
+		},
+	}
+	for _, o := range options {
+		o(svc)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/health/readiness", svc.Ready)
+	mux.HandleFunc("/health/liveness", svc.Alive)
+	svc.Handler = mux
+
+	// Print some helpful information about which interfaces the function
+	// is correctly implementing
+	logImplements(f)
+
+	return svc
+}
+
+// log which interfaces the function implements.
+// This could be more verbose for new users:
+func logImplements(f any) {
+	for _, i := range implemented(f) {
+		log.Info().Msg("Function implements " + i)
+	}
+}
+
+// implemented returns the names of the interfaces which the function
+// implements.
+func implemented(f any) (ii []string) {
+	if _, ok := f.(Handler); ok {
+		ii = append(ii, "Handle")
+	}
+	if _, ok := f.(CloudEventHandler); ok {
+		ii = append(ii, "Handle for CloudEvents")
+	}
+	if _, ok := f.(Starter); ok {
+		ii = append(ii, "Start")
+	}
+	if _, ok := f.(Stopper); ok {
+		ii = append(ii, "Stop")
+	}
+	if _, ok := f.(ReadinessReporter); ok {
+		ii = append(ii, "Ready")
+	}
+	if _, ok := f.(LivenessReporter); ok {
+		ii = append(ii, "Alive")
+	}
+	return
+}
+
+// Start
+// Will stop when the context is canceled, a runtime error is encountered,
+// or an os interrupt or kill signal is received.
+// Health endpoints are served on the default address DefaultListenAddress.
+// This can be modified using the environment variable LISTEN_ADDRESS.
+// Messages are consumed once the function has started, as configured by
+// the environment variables:
+//
+//	NATS_URL       the server(s) to which to connect (default DefaultURL)
+//	NATS_SUBJECTS  a comma-separated list of subjects (required)
+//	NATS_STREAM    the stream containing the subjects (default: looked up
+//	               by the first subject)
+//	NATS_QUEUE     a durable consumer name shared by all instances, such that
+//	               messages are distributed among them (default: each
+//	               instance receives all messages)
+//	NATS_DELIVER   the messages of the stream delivered once the consumer is
+//	               created: "all", "new" (those published since) or "last"
+//	               (the last message and those since).  A durable consumer
+//	               continues from where it left off once created.  (default:
+//	               "all" if NATS_QUEUE is set, or "new" otherwise, such that
+//	               an instance does not replay the stream on every start)
+func (s *Service) Start(ctx context.Context) (err error) {
+	handle, err := newHandleFn(s.f)
+	if err != nil {
+		return
+	}
+	cfg, err := newNatsConfig()
+	if err != nil {
+		return
+	}
+
+	addr := config.ListenAddress(DefaultListenAddress)
+	log.Debug().Str("address", addr).Msg("function starting")
+
+	// Listen
+	// Serves the health endpoints.
+	if s.listener, err = net.Listen("tcp", addr); err != nil {
+		return
+	}
+	s.logSummary(cfg)
+	go func() {
+		if err := s.Serve(s.listener); err != http.ErrServerClosed {
+			log.Error().Err(err).Msg("http server exited with unexpected error")
+			s.fail(err)
+		}
+	}()
+
+	// Wait for signals
+	// Interrupts and Kill signals
+	// sending a message on the s.stop channel if either are received.
+	unregister := signals.Handle(s.stop, s.signalHandlers)
+	defer unregister()
+
+	// Start
+	// Starts the function instance and then subscribes in a separate
+	// routine, sending any runtime errors on s.stop.  The routine is
+	// canceled should the service stop first, and awaited before the
+	// instance is stopped.  Messages are handled with a context derived from
+	// the one passed to Start, without its cancellation, such that those
+	// in-flight may complete during shutdown.
+	startCtx, cancel := context.WithCancel(ctx)
+	s.startCancel, s.startDone = cancel, make(chan struct{})
+	go func() {
+		defer close(s.startDone)
+		if err := s.startInstance(startCtx); err != nil {
+			s.fail(err)
+			return
+		}
+		baseCtx := context.WithoutCancel(ctx)
+		stop, err := s.subscribe(startCtx, cfg, func(m message) { s.handle(baseCtx, handle, m) })
+		if err != nil {
+			s.fail(fmt.Errorf("error subscribing to %v: %w", cfg.subjects, err))
+			return
+		}
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		if s.stopped { // shutdown while subscribing
+			stop()
+			return
+		}
+		s.unsubscribe = stop
+		log.Debug().Strs("subjects", cfg.subjects).Msg("function subscribed")
+		close(s.started)
+	}()
+
+	log.Debug().Msg("waiting for stop signals or errors")
+	// Wait for either a context cancellation or a signal on the stop channel.
+	select {
+	case err = <-s.stop:
+		if err != nil {
+			log.Error().Err(err).Msg("function error")
+		}
+	case <-ctx.Done():
+		log.Debug().Msg("function canceled")
+	}
+	return s.shutdown(err)
+}
+
+// Addr returns the address upon which the health endpoints are served if
+// started; nil otherwise.
+func (s *Service) Addr() net.Addr {
+	if s.listener == nil {
+		return nil
+	}
+	return s.listener.Addr()
+}
+
+// handle a message using the handleFn, acknowledging it on success and
+// negatively acknowledging it (for redelivery) on error.  Messages which are
+// not valid CloudEvents (when handling CloudEvents) are terminated, as they
+// would never succeed if redelivered.
+func (s *Service) handle(ctx context.Context, handle handleFn, m message) {
+	s.mu.Lock()
+	if s.stopped {
+		s.mu.Unlock()
+		_ = m.Nak()
+		return
+	}
+	s.inflight.Add(1)
+	s.mu.Unlock()
+	defer s.inflight.Done()
+
+	ctx = context.WithValue(ctx, configKey{}, s.cfg)
+	ctx = log.With().Str("subject", m.Subject()).Logger().WithContext(ctx)
+	msg := &natsio.Msg{Subject: m.Subject(), Header: m.Headers(), Data: m.Data()}
+	if err := handle(ctx, msg); errors.Is(err, errMalformedEvent) {
+		log.Error().Err(err).Str("subject", msg.Subject).Msg("discarding message")
+		if err := m.Term(); err != nil {
+			log.Error().Err(err).Msg("error terminating message")
+		}
+		return
+	} else if err != nil {
+		log.Error().Err(err).Str("subject", msg.Subject).Msg("error handling message")
+		if err := m.Nak(); err != nil {
+			log.Error().Err(err).Msg("error negatively acknowledging message")
+		}
+		return
+	}
+	if err := m.Ack(); err != nil {
+		log.Error().Err(err).Msg("error acknowledging message")
+	}
+}
+
+// Ready handles readiness checks.
+func (s *Service) Ready(w http.ResponseWriter, r *http.Request) {
+	if !s.isStarted() {
+		health.NotReady(w, "function not yet subscribed")
+		return
+	}
+	health.Ready(w, r, s.f)
+}
+
+// Alive handles liveness checks.
+func (s *Service) Alive(w http.ResponseWriter, r *http.Request) {
+	health.Alive(w, r, s.f)
+}
+
+func (s *Service) startInstance(ctx context.Context) (err error) {
+	if s.cfg, err = config.New(); err != nil {
+		return
+	}
+	if i, ok := s.f.(Starter); ok {
+		return i.Start(ctx, s.cfg)
+	}
+	log.Debug().Msg("function does not implement Start. Skipping")
+	return nil
+}
+
+// fail sends err on s.stop, such that Start stops the service, unless the
+// service is already stopping.
+func (s *Service) fail(err error) {
+	select {
+	case s.stop <- err:
+	case <-s.stopping:
+	}
+}
+
+// cancelStart cancels the context of the routine which starts the function
+// instance and then subscribes, if still in progress, such as when a signal
+// is received during a slow initialization.
+func (s *Service) cancelStart() {
+	if s.startDone == nil {
+		return
+	}
+	select {
+	case <-s.startDone:
+	default:
+		log.Debug().Msg("canceling function start")
+		s.startCancel()
+	}
+}
+
+// waitStart waits up to InstanceStopTimeout for the routine which starts
+// the function instance to return, such that Stop is never invoked while
+// Start is in progress.
+func (s *Service) waitStart() {
+	if s.startDone == nil {
+		return
+	}
+	select {
+	case <-s.startDone:
+	case <-time.After(InstanceStopTimeout):
+		log.Warn().Msg("timed out waiting for function start to return")
+	}
+}
+
+// isStarted returns true if the function instance has successfully started
+// and subscribed.
+func (s *Service) isStarted() bool {
+	select {
+	case <-s.started:
+		return true
+	default:
+		return false
+	}
+}
+
+// shutdown is invoked when the stop channel receives a message and attempts to
+// gracefully cease execution.
+// Passed in is the message received on the stop channel, wich is either an
+// error in the case of a runtime error, or nil in the case of a context
+// cancellation or sigint/sigkill.
+func (s *Service) shutdown(sourceErr error) (err error) {
+	log.Debug().Msg("function stopping")
+	var runtimeErr, instanceErr error
+	close(s.stopping)
+
+	// Cancel the start routine if still in progress, which is waited upon
+	// before the instance is stopped.
+	s.cancelStart()
+
+	// Stop receiving messages, and wait for those in-flight to complete.
+	ctx, cancel := context.WithTimeout(context.Background(), ServerShutdownTimeout)
+	defer cancel()
+	s.mu.Lock()
+	s.stopped = true
+	unsubscribe := s.unsubscribe
+	s.mu.Unlock()
+	if unsubscribe != nil {
+		unsubscribe()
+	}
+	done := make(chan struct{})
+	go func() {
+		s.inflight.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-ctx.Done():
+		runtimeErr = fmt.Errorf("timed out waiting for messages in-flight: %w", ctx.Err())
+	}
+
+	// Stop serving the health endpoints
+	if err := s.Shutdown(ctx); err != nil {
+		runtimeErr = shutdown.Errors(runtimeErr, err)
+	}
+
+	s.waitStart()
+
+	//  Start a graceful shutdown of the Function instance
+	if i, ok := s.f.(Stopper); ok {
+		ctx, cancel = context.WithTimeout(context.Background(), InstanceStopTimeout)
+		defer cancel()
+		instanceErr = i.Stop(ctx)
+	}
+
+	hookErr := shutdown.RunHooks(s.shutdownHooks, InstanceStopTimeout)
+
+	return shutdown.Errors(instanceErr, sourceErr, runtimeErr, hookErr)
+}
+
+// ShutdownError is returned by Start when more than one error occurs in
+// stopping the service.  Errors are ordered by precedence: that of the
+// function's Stop hook, followed by that which caused the service to stop,
+// followed by any of the runtime itself.  errors.Is and errors.As consider
+// each of them.
+type ShutdownError = shutdown.Error
@@ -0,0 +1,123 @@
+package nats
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+
+	natsio "github.com/nats-io/nats.go"
+	"github.com/nats-io/nats.go/jetstream"
+)
+
+// natsConfig is the configuration of the subscription, read from the
+// environment (see Service.Start).
+type natsConfig struct {
+	url      string
+	subjects []string
+	stream   string
+	queue    string
+	deliver  string // "all", "new" or "last"
+}
+
+// deliverPolicies are the policies of NATS_DELIVER, by name.
+var deliverPolicies = map[string]jetstream.DeliverPolicy{
+	"all":  jetstream.DeliverAllPolicy,
+	"new":  jetstream.DeliverNewPolicy,
+	"last": jetstream.DeliverLastPolicy,
+}
+
+// newNatsConfig reads the configuration of the subscription from the
+// environment.
+func newNatsConfig() (cfg natsConfig, err error) {
+	cfg = natsConfig{
+		url:     os.Getenv("NATS_URL"),
+		stream:  os.Getenv("NATS_STREAM"),
+		queue:   os.Getenv("NATS_QUEUE"),
+		deliver: strings.ToLower(os.Getenv("NATS_DELIVER")),
+	}
+	if cfg.url == "" {
+		cfg.url = DefaultURL
+	}
+	if cfg.deliver == "" {
+		// An ephemeral consumer is created on every start, such that
+		// delivering all messages would replay the stream each time.
+		cfg.deliver = "all"
+		if cfg.queue == "" {
+			cfg.deliver = "new"
+		}
+	}
+	if _, ok := deliverPolicies[cfg.deliver]; !ok {
+		return cfg, fmt.Errorf("invalid NATS_DELIVER %q: must be all, new or last", cfg.deliver)
+	}
+	for _, subject := range strings.Split(os.Getenv("NATS_SUBJECTS"), ",") {
+		if subject = strings.TrimSpace(subject); subject != "" {
+			cfg.subjects = append(cfg.subjects, subject)
+		}
+	}
+	if len(cfg.subjects) == 0 {
+		err = ErrNoSubjects
+	}
+	return
+}
+
+// message is a message received from a subscription, which must be
+// acknowledged.  It is satisfied by jetstream.Msg.
+type message interface {
+	Subject() string
+	Headers() natsio.Header
+	Data() []byte
+	Ack() error
+	Nak() error
+	Term() error
+}
+
+// subscriber subscribes to the configured subjects, invoking handle for each
+// message received until the returned stop function is invoked.
+type subscriber func(ctx context.Context, cfg natsConfig, handle func(message)) (stop func(), err error)
+
+// jetStreamSubscriber subscribes using a JetStream pull consumer, which is
+// durable (and thus shared) if a queue is configured.
+func jetStreamSubscriber(ctx context.Context, cfg natsConfig, handle func(message)) (stop func(), err error) {
+	nc, err := natsio.Connect(cfg.url)
+	if err != nil {
+		return
+	}
+	defer func() {
+		if err != nil {
+			nc.Close()
+		}
+	}()
+	js, err := jetstream.New(nc)
+	if err != nil {
+		return
+	}
+	stream := cfg.stream
+	if stream == "" {
+		if stream, err = js.StreamNameBySubject(ctx, cfg.subjects[0]); err != nil {
+			return
+		}
+	}
+	consumerCfg := jetstream.ConsumerConfig{
+		Durable:       cfg.queue,
+		AckPolicy:     jetstream.AckExplicitPolicy,
+		DeliverPolicy: deliverPolicies[cfg.deliver],
+	}
+	if len(cfg.subjects) == 1 {
+		consumerCfg.FilterSubject = cfg.subjects[0]
+	} else {
+		consumerCfg.FilterSubjects = cfg.subjects
+	}
+	consumer, err := js.CreateOrUpdateConsumer(ctx, stream, consumerCfg)
+	if err != nil {
+		return
+	}
+	cc, err := consumer.Consume(func(m jetstream.Msg) { handle(m) })
+	if err != nil {
+		return
+	}
+	return func() {
+		cc.Stop()
+		_ = nc.Drain()
+	}, nil
+}
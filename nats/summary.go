@@ -0,0 +1,41 @@
+package nats
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+
+	"knative.dev/func-go/internal/logs"
+)
+
+// logSummary logs the effective configuration of the service once
+// listening, such that misconfiguration (for example of the environment)
+// is apparent from a single log line.  Credentials in the server URLs are
+// redacted.
+func (s *Service) logSummary(cfg natsConfig) {
+	e := log.Info().
+		Str("address", s.listener.Addr().String()).
+		Str("url", redactURLs(cfg.url)).
+		Strs("subjects", cfg.subjects).
+		Str("deliver", cfg.deliver).
+		Str("handler", fmt.Sprintf("%T", s.f)).
+		Strs("implements", implemented(s.f))
+	if cfg.stream != "" {
+		e = e.Str("stream", cfg.stream)
+	}
+	if cfg.queue != "" {
+		e = e.Str("queue", cfg.queue)
+	}
+	e.Msg("function runtime configured")
+}
+
+// redactURLs returns the comma-separated server URLs with any credentials
+// they contain replaced.
+func redactURLs(urls string) string {
+	uu := strings.Split(urls, ",")
+	for i, u := range uu {
+		uu[i] = logs.RedactURL(strings.TrimSpace(u))
+	}
+	return strings.Join(uu, ",")
+}
@@ -0,0 +1,231 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	goredis "github.com/redis/go-redis/v9"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// readCount is the maximum number of entries read or claimed at once.
+	readCount = 10
+
+	// readBlock is the longest a read blocks awaiting new entries, such
+	// that pending entries are reclaimed at least this often when due.
+	readBlock = 5 * time.Second
+
+	// readRetryDelay is the delay before reading again after a failed read,
+	// such as while the server is unavailable.
+	readRetryDelay = time.Second
+)
+
+// redisConfig is the configuration of the consumer, read from the
+// environment (see Service.Start).
+type redisConfig struct {
+	addr          string
+	stream        string
+	group         string
+	consumer      string
+	claimIdle     time.Duration
+	maxDeliveries int64
+}
+
+// newRedisConfig reads the configuration of the consumer from the
+// environment.
+func newRedisConfig() (cfg redisConfig, err error) {
+	cfg = redisConfig{
+		addr:          os.Getenv("REDIS_ADDR"),
+		stream:        os.Getenv("REDIS_STREAM"),
+		group:         os.Getenv("REDIS_GROUP"),
+		consumer:      os.Getenv("REDIS_CONSUMER"),
+		claimIdle:     DefaultClaimIdle,
+		maxDeliveries: DefaultMaxDeliveries,
+	}
+	if cfg.addr == "" {
+		cfg.addr = DefaultAddress
+	}
+	if cfg.stream == "" {
+		return cfg, ErrNoStream
+	}
+	if cfg.group == "" {
+		cfg.group = DefaultGroup
+	}
+	if cfg.consumer == "" {
+		if cfg.consumer, err = os.Hostname(); err != nil {
+			return cfg, fmt.Errorf("REDIS_CONSUMER not set, and hostname unavailable: %w", err)
+		}
+	}
+	if v := os.Getenv("REDIS_CLAIM_IDLE"); v != "" {
+		if cfg.claimIdle, err = time.ParseDuration(v); err != nil || cfg.claimIdle <= 0 {
+			return cfg, fmt.Errorf("invalid REDIS_CLAIM_IDLE %q", v)
+		}
+	}
+	if v := os.Getenv("REDIS_MAX_DELIVERIES"); v != "" {
+		if cfg.maxDeliveries, err = strconv.ParseInt(v, 10, 64); err != nil || cfg.maxDeliveries <= 0 {
+			return cfg, fmt.Errorf("invalid REDIS_MAX_DELIVERIES %q", v)
+		}
+	}
+	return
+}
+
+// options returns the client options of the server address, which is either
+// a host:port or a redis:// (or rediss://) URL.
+func (cfg redisConfig) options() (*goredis.Options, error) {
+	if strings.Contains(cfg.addr, "://") {
+		return goredis.ParseURL(cfg.addr)
+	}
+	return &goredis.Options{Addr: cfg.addr}, nil
+}
+
+// consumer reads the entries of a stream as a member of a consumer group.
+type consumer struct {
+	client *goredis.Client
+	cfg    redisConfig
+	closed chan struct{}
+}
+
+// newConsumer connects to the server, creating the stream and the consumer
+// group if they do not exist.  A new group receives only entries added after
+// its creation.
+func newConsumer(ctx context.Context, cfg redisConfig) (*consumer, error) {
+	opts, err := cfg.options()
+	if err != nil {
+		return nil, fmt.Errorf("invalid REDIS_ADDR: %w", err)
+	}
+	client := goredis.NewClient(opts)
+	err = client.XGroupCreateMkStream(ctx, cfg.stream, cfg.group, "$").Err()
+	if err != nil && !strings.HasPrefix(err.Error(), "BUSYGROUP") { // already exists
+		_ = client.Close()
+		return nil, err
+	}
+	return &consumer{client: client, cfg: cfg, closed: make(chan struct{})}, nil
+}
+
+// consume invokes handle for each entry delivered to the consumer, until
+// closed.  Entries pending for longer than the configured idle time, such as
+// those of a crashed consumer or whose handling failed, are first claimed
+// and handled again.
+func (c *consumer) consume(handle func(goredis.XMessage)) {
+	var claimed time.Time
+	for !c.isClosed() {
+		if time.Since(claimed) >= c.cfg.claimIdle {
+			c.claim(handle)
+			claimed = time.Now()
+		}
+		streams, err := c.client.XReadGroup(context.Background(), &goredis.XReadGroupArgs{
+			Group:    c.cfg.group,
+			Consumer: c.cfg.consumer,
+			Streams:  []string{c.cfg.stream, ">"},
+			Count:    readCount,
+			Block:    min(readBlock, c.cfg.claimIdle),
+		}).Result()
+		if errors.Is(err, goredis.Nil) { // no new entries
+			continue
+		} else if err != nil {
+			if c.isClosed() {
+				return
+			}
+			log.Error().Err(err).Str("stream", c.cfg.stream).Msg("error reading from stream")
+			select {
+			case <-time.After(readRetryDelay):
+			case <-c.closed:
+			}
+			continue
+		}
+		for _, s := range streams {
+			for _, m := range s.Messages {
+				handle(m)
+			}
+		}
+	}
+}
+
+// claim the entries of the group which have been pending for longer than
+// the configured idle time, invoking handle for each.  Those delivered more
+// than the configured maximum number of times are instead acknowledged and
+// discarded.
+func (c *consumer) claim(handle func(goredis.XMessage)) {
+	start := "0-0"
+	for !c.isClosed() {
+		mm, next, err := c.client.XAutoClaim(context.Background(), &goredis.XAutoClaimArgs{
+			Stream:   c.cfg.stream,
+			Group:    c.cfg.group,
+			Consumer: c.cfg.consumer,
+			MinIdle:  c.cfg.claimIdle,
+			Start:    start,
+			Count:    readCount,
+		}).Result()
+		if err != nil {
+			if !c.isClosed() {
+				log.Error().Err(err).Str("stream", c.cfg.stream).Msg("error claiming pending entries")
+			}
+			return
+		}
+		if len(mm) > 0 {
+			log.Debug().Int("count", len(mm)).Msg("claimed pending entries")
+		}
+		for _, m := range mm {
+			if c.exhausted(m.ID) {
+				continue
+			}
+			handle(m)
+		}
+		if next == "0-0" { // the whole pending entries list has been scanned
+			return
+		}
+		start = next
+	}
+}
+
+// exhausted returns whether the claimed entry with the given ID has been
+// delivered more than the configured maximum number of times, in which case
+// it is acknowledged such that it is no longer claimed.
+func (c *consumer) exhausted(id string) bool {
+	pp, err := c.client.XPendingExt(context.Background(), &goredis.XPendingExtArgs{
+		Stream: c.cfg.stream,
+		Group:  c.cfg.group,
+		Start:  id,
+		End:    id,
+		Count:  1,
+	}).Result()
+	if err != nil {
+		log.Error().Err(err).Str("id", id).Msg("error reading delivery count of entry")
+		return false
+	}
+	if len(pp) == 0 || pp[0].RetryCount <= c.cfg.maxDeliveries {
+		return false
+	}
+	log.Error().Str("id", id).Int64("deliveries", pp[0].RetryCount).Msg("discarding entry delivered too many times")
+	if err := c.ack(id); err != nil {
+		log.Error().Err(err).Str("id", id).Msg("error acknowledging entry")
+	}
+	return true
+}
+
+// ack acknowledges the entry with the given ID, removing it from the group's
+// pending entries.
+func (c *consumer) ack(id string) error {
+	return c.client.XAck(context.Background(), c.cfg.stream, c.cfg.group, id).Err()
+}
+
+// close the connection, interrupting any blocked read.
+func (c *consumer) close() {
+	close(c.closed)
+	_ = c.client.Close()
+}
+
+func (c *consumer) isClosed() bool {
+	select {
+	case <-c.closed:
+		return true
+	default:
+		return false
+	}
+}
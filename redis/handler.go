@@ -0,0 +1,112 @@
+package redis
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	goredis "github.com/redis/go-redis/v9"
+)
+
+// errMalformedEvent is returned when an entry can not be decoded as a
+// CloudEvent, such that it is discarded rather than redelivered.
+var errMalformedEvent = errors.New("malformed CloudEvent")
+
+// handleFn is the single handler signature to which each of the supported
+// function types is adapted.
+type handleFn func(context.Context, goredis.XMessage) error
+
+// newHandleFn adapts f, which must implement either Handler or
+// CloudEventHandler, to a handleFn.
+func newHandleFn(f any) (handleFn, error) {
+	switch h := f.(type) {
+	case Handler:
+		return h.Handle, nil
+	case CloudEventHandler:
+		return func(ctx context.Context, m goredis.XMessage) error {
+			e, err := toEvent(m)
+			if err != nil {
+				return err
+			}
+			return h.Handle(ctx, e)
+		}, nil
+	default:
+		return nil, ErrNoHandler
+	}
+}
+
+// toEvent decodes a CloudEvent from a stream entry in either structured
+// content mode (a content-type of application/cloudevents+json), or binary
+// content mode, in which attributes are fields prefixed "ce_" and the field
+// "data" is the event's data.
+func toEvent(m goredis.XMessage) (e cloudevents.Event, err error) {
+	ct := field(m.Values, "content-type")
+	if strings.HasPrefix(ct, cloudevents.ApplicationCloudEventsJSON) {
+		err = json.Unmarshal([]byte(field(m.Values, "data")), &e)
+	} else {
+		e, err = toBinaryEvent(m, ct)
+	}
+	if err == nil {
+		err = e.Validate()
+	}
+	if err != nil {
+		return e, fmt.Errorf("%w: %v", errMalformedEvent, err)
+	}
+	return
+}
+
+func toBinaryEvent(m goredis.XMessage, ct string) (e cloudevents.Event, err error) {
+	e = cloudevents.NewEvent()
+	if v := field(m.Values, "ce_specversion"); v != "" {
+		e.SetSpecVersion(v) // before any other attributes
+	}
+	for k := range m.Values {
+		name := strings.ToLower(k)
+		if !strings.HasPrefix(name, "ce_") {
+			continue
+		}
+		v := field(m.Values, k)
+		switch attr := strings.TrimPrefix(name, "ce_"); attr {
+		case "specversion":
+		case "id":
+			e.SetID(v)
+		case "source":
+			e.SetSource(v)
+		case "type":
+			e.SetType(v)
+		case "subject":
+			e.SetSubject(v)
+		case "dataschema":
+			e.SetDataSchema(v)
+		case "time":
+			t, err := time.Parse(time.RFC3339Nano, v)
+			if err != nil {
+				return e, fmt.Errorf("invalid time %q: %w", v, err)
+			}
+			e.SetTime(t)
+		default:
+			e.SetExtension(attr, v)
+		}
+	}
+	if data, ok := m.Values["data"]; ok {
+		err = e.SetData(ct, []byte(fmt.Sprint(data)))
+	}
+	return
+}
+
+// field returns the value of the entry's field with the given key, or ""
+// if it has no such field.
+func field(values map[string]any, key string) string {
+	v, ok := values[key]
+	if !ok || v == nil {
+		return ""
+	}
+	if s, ok := v.(string); ok {
+		return s
+	}
+	return fmt.Sprint(v)
+}
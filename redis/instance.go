@@ -0,0 +1,71 @@
+package redis
+
+import (
+	"context"
+
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	goredis "github.com/redis/go-redis/v9"
+)
+
+// Handler is a function instance which can handle an entry of a Redis
+// stream.
+//
+// Entries are acknowledged (XACK) when Handle returns without error.
+// Otherwise they remain pending, and are redelivered once they have been
+// idle for REDIS_CLAIM_IDLE (see Service.Start).
+type Handler interface {
+	// Handle a stream entry.
+	Handle(context.Context, goredis.XMessage) error
+}
+
+type HandleFunc func(context.Context, goredis.XMessage) error
+
+// CloudEventHandler is a function instance which can handle a CloudEvent
+// received as an entry of a Redis stream in either binary or structured
+// content mode.
+//
+// In binary content mode the event's attributes are fields prefixed "ce_"
+// (such as "ce_id"), its content type is the field "content-type" and its
+// data is the field "data".  In structured content mode the field
+// "content-type" is application/cloudevents+json, and the field "data" is
+// the JSON encoded event.
+//
+// A function must implement either Handler or CloudEventHandler.
+type CloudEventHandler interface {
+	// Handle an event.
+	Handle(context.Context, cloudevents.Event) error
+}
+
+// Starter is an instance which has defined the Start hook
+type Starter interface {
+	// Start instance event hook.
+	Start(context.Context, map[string]string) error
+}
+
+// Stopper is an instance which has defined the  Stop hook
+type Stopper interface {
+	// Stop instance event hook.
+	Stop(context.Context) error
+}
+
+// ReadinessReporter is an instance which reports its readiness.
+type ReadinessReporter interface {
+	// Ready to be invoked or not.
+	Ready(context.Context) (bool, error)
+}
+
+// LivenessReporter is an instance which reports it is alive.
+type LivenessReporter interface {
+	// Alive allows the instance to report it's liveness status.
+	Alive(context.Context) (bool, error)
+}
+
+// DefaultHandler is used for simple static function implementations which
+// need only define a single exported function named Handle of type HandleFunc.
+type DefaultHandler struct {
+	Handler HandleFunc
+}
+
+func (f DefaultHandler) Handle(ctx context.Context, m goredis.XMessage) error {
+	return f.Handler(ctx, m)
+}
@@ -0,0 +1,34 @@
+package mock
+
+import (
+	"context"
+
+	goredis "github.com/redis/go-redis/v9"
+)
+
+type Function struct {
+	OnStart  func(context.Context, map[string]string) error
+	OnStop   func(context.Context) error
+	OnHandle func(context.Context, goredis.XMessage) error
+}
+
+func (f *Function) Start(ctx context.Context, cfg map[string]string) error {
+	if f.OnStart != nil {
+		return f.OnStart(ctx, cfg)
+	}
+	return nil
+}
+
+func (f *Function) Stop(ctx context.Context) error {
+	if f.OnStop != nil {
+		return f.OnStop(ctx)
+	}
+	return nil
+}
+
+func (f *Function) Handle(ctx context.Context, m goredis.XMessage) error {
+	if f.OnHandle != nil {
+		return f.OnHandle(ctx, m)
+	}
+	return nil
+}
@@ -0,0 +1,4 @@
+package redis
+
+// Option configures a Service.
+type Option func(*Service)
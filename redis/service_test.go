@@ -0,0 +1,312 @@
+package redis
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	cloudevents "github.com/cloudevents/sdk-go/v2"
+	goredis "github.com/redis/go-redis/v9"
+	"knative.dev/func-go/redis/mock"
+)
+
+const (
+	testStream = "example-stream"
+	testGroup  = "example-group"
+)
+
+// startServer starts an in-memory Redis server, returning a client of it.
+// The environment is configured such that a service consumes from it.
+func startServer(t *testing.T) *goredis.Client {
+	t.Helper()
+	server := miniredis.RunT(t)
+	t.Setenv("REDIS_ADDR", server.Addr())
+	t.Setenv("REDIS_STREAM", testStream)
+	t.Setenv("REDIS_GROUP", testGroup)
+	t.Setenv("REDIS_CONSUMER", "example-consumer")
+	t.Setenv("REDIS_CLAIM_IDLE", "50ms")
+	client := goredis.NewClient(&goredis.Options{Addr: server.Addr()})
+	t.Cleanup(func() { client.Close() })
+	return client
+}
+
+// startService starts a Service for the given function consuming from the
+// server configured by startServer, returning once it is consuming.  The
+// service is stopped when the test completes.
+func startService(t *testing.T, f any, options ...Option) *Service {
+	t.Helper()
+	t.Setenv("LISTEN_ADDRESS", "127.0.0.1:") // use an OS-chosen port
+
+	var (
+		ctx, cancel = context.WithCancel(context.Background())
+		errCh       = make(chan error, 1)
+	)
+	service := New(f, options...)
+	go func() {
+		errCh <- service.Start(ctx)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		select {
+		case <-errCh:
+		case <-time.After(time.Second):
+			t.Error("service failed to stop")
+		}
+	})
+
+	select {
+	case <-time.After(500 * time.Millisecond):
+		t.Fatal("function failed to start consuming")
+	case err := <-errCh:
+		t.Fatal(err)
+	case <-service.started:
+	}
+	return service
+}
+
+// add an entry with the given fields to the stream, returning its ID.
+func add(t *testing.T, client *goredis.Client, values ...string) string {
+	t.Helper()
+	id, err := client.XAdd(context.Background(), &goredis.XAddArgs{Stream: testStream, Values: values}).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return id
+}
+
+// pending returns the number of entries pending acknowledgement.
+func pending(t *testing.T, client *goredis.Client) int64 {
+	t.Helper()
+	p, err := client.XPending(context.Background(), testStream, testGroup).Result()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return p.Count
+}
+
+// TestStart_Invoked ensures that the Start method of a function is invoked
+// before consuming, with the config in the environment.
+func TestStart_Invoked(t *testing.T) {
+	startServer(t)
+	var stream string
+	f := &mock.Function{OnStart: func(_ context.Context, cfg map[string]string) error {
+		stream = cfg["REDIS_STREAM"]
+		return nil
+	}}
+	startService(t, f)
+	if stream != testStream {
+		t.Fatalf("function was not started with its config, got stream %q", stream)
+	}
+}
+
+// TestStart_Errors ensures that a function which implements no handler, or
+// which is started without a stream, fails to start.
+func TestStart_Errors(t *testing.T) {
+	t.Setenv("REDIS_STREAM", testStream)
+	if err := New(struct{}{}).Start(context.Background()); !errors.Is(err, ErrNoHandler) {
+		t.Fatalf("expected ErrNoHandler, got %v", err)
+	}
+	t.Setenv("REDIS_STREAM", "")
+	if err := New(&mock.Function{}).Start(context.Background()); !errors.Is(err, ErrNoStream) {
+		t.Fatalf("expected ErrNoStream, got %v", err)
+	}
+}
+
+// TestHandle_Ack ensures that entries are acknowledged when handled
+// successfully, and otherwise remain pending and are handled again once
+// idle.
+func TestHandle_Ack(t *testing.T) {
+	client := startServer(t)
+	var (
+		handled = make(chan string, 3)
+		failed  atomic.Bool
+	)
+	f := &mock.Function{OnHandle: func(_ context.Context, m goredis.XMessage) error {
+		handled <- m.ID
+		if m.Values["data"] == "fail" && !failed.Swap(true) {
+			return errors.New("handler error") // the first attempt only
+		}
+		return nil
+	}}
+	startService(t, f)
+
+	ok := add(t, client, "data", "ok")
+	if id := <-handled; id != ok {
+		t.Fatalf("unexpected entry handled %v", id)
+	}
+	waitFor(t, func() bool { return pending(t, client) == 0 }, "entry not acknowledged")
+
+	fail := add(t, client, "data", "fail")
+	for i := 0; i < 2; i++ {
+		select {
+		case id := <-handled:
+			if id != fail {
+				t.Fatalf("unexpected entry handled %v", id)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("failed entry was not handled again")
+		}
+	}
+	waitFor(t, func() bool { return pending(t, client) == 0 }, "entry not acknowledged once handled")
+}
+
+// TestClaim ensures that entries left pending by a crashed consumer of the
+// group are claimed and handled.
+func TestClaim(t *testing.T) {
+	client := startServer(t)
+	ctx := context.Background()
+	if err := client.XGroupCreateMkStream(ctx, testStream, testGroup, "$").Err(); err != nil {
+		t.Fatal(err)
+	}
+	id := add(t, client, "data", "orphaned")
+	if err := client.XReadGroup(ctx, &goredis.XReadGroupArgs{
+		Group: testGroup, Consumer: "crashed", Streams: []string{testStream, ">"},
+	}).Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	handled := make(chan string, 1)
+	startService(t, &mock.Function{OnHandle: func(_ context.Context, m goredis.XMessage) error {
+		handled <- m.ID
+		return nil
+	}})
+	select {
+	case got := <-handled:
+		if got != id {
+			t.Fatalf("unexpected entry handled %v", got)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("pending entry was not claimed")
+	}
+	waitFor(t, func() bool { return pending(t, client) == 0 }, "claimed entry not acknowledged")
+}
+
+// TestClaim_MaxDeliveries ensures that an entry whose handling always fails
+// is handled at most the configured number of times, and is then
+// acknowledged rather than claimed forever.
+func TestClaim_MaxDeliveries(t *testing.T) {
+	client := startServer(t)
+	t.Setenv("REDIS_MAX_DELIVERIES", "2")
+	var handled atomic.Int32
+	startService(t, &mock.Function{OnHandle: func(context.Context, goredis.XMessage) error {
+		handled.Add(1)
+		return errors.New("handler error")
+	}})
+
+	add(t, client, "data", "fail")
+	waitFor(t, func() bool { return handled.Load() > 0 && pending(t, client) == 0 },
+		"entry not acknowledged once delivered the maximum number of times")
+	time.Sleep(200 * time.Millisecond) // several claim intervals
+	if n := handled.Load(); n != 2 {
+		t.Fatalf("expected the entry to be handled 2 times, got %v", n)
+	}
+}
+
+// ceFunction is a function which handles CloudEvents.
+type ceFunction struct {
+	events chan cloudevents.Event
+}
+
+func (f *ceFunction) Handle(_ context.Context, e cloudevents.Event) error {
+	f.events <- e
+	return nil
+}
+
+// TestHandle_CloudEvent ensures that CloudEvents are decoded from entries in
+// binary and structured content modes, and that malformed events are
+// discarded.
+func TestHandle_CloudEvent(t *testing.T) {
+	client := startServer(t)
+	f := &ceFunction{events: make(chan cloudevents.Event, 1)}
+	startService(t, f)
+
+	add(t, client,
+		"ce_specversion", "1.0",
+		"ce_id", "1",
+		"ce_source", "example/uri",
+		"ce_type", "example.type",
+		"ce_partitionkey", "key",
+		"content-type", "application/json",
+		"data", `{"message":"hello"}`)
+	e := <-f.events
+	if e.ID() != "1" || e.Source() != "example/uri" || e.Type() != "example.type" {
+		t.Fatalf("unexpected event %v", e)
+	}
+	if e.Extensions()["partitionkey"] != "key" {
+		t.Fatalf("expected extension to be preserved, got %v", e.Extensions())
+	}
+	if string(e.Data()) != `{"message":"hello"}` || e.DataContentType() != "application/json" {
+		t.Fatalf("unexpected event data %q (%v)", e.Data(), e.DataContentType())
+	}
+
+	add(t, client,
+		"content-type", "application/cloudevents+json",
+		"data", `{"specversion":"1.0","id":"2","source":"example/uri","type":"example.type","data":"hello"}`)
+	if e := <-f.events; e.ID() != "2" {
+		t.Fatalf("unexpected event %v", e)
+	}
+
+	id := add(t, client, "ce_id", "3", "data", "hello") // no source or type
+	waitFor(t, func() bool {
+		groups, err := client.XInfoGroups(context.Background(), testStream).Result()
+		if err != nil {
+			t.Fatal(err)
+		}
+		return groups[0].LastDeliveredID == id && groups[0].Pending == 0
+	}, "malformed event not discarded")
+	select {
+	case e := <-f.events:
+		t.Fatalf("malformed event handled %v", e)
+	default:
+	}
+}
+
+// TestReady ensures that the function reports ready once consuming.
+func TestReady(t *testing.T) {
+	startServer(t)
+	service := startService(t, &mock.Function{})
+	resp, err := http.Get("http://" + service.Addr().String() + "/health/readiness")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("unexpected http status code: %v", resp.StatusCode)
+	}
+}
+
+// TestConfigFromContext ensures that the function's config is available to
+// its handler using ConfigFromContext.
+func TestConfigFromContext(t *testing.T) {
+	client := startServer(t)
+	t.Setenv("TEST_CONFIG_VALUE", "example")
+	values := make(chan string, 1)
+	f := &mock.Function{OnHandle: func(ctx context.Context, _ goredis.XMessage) error {
+		values <- ConfigFromContext(ctx)["TEST_CONFIG_VALUE"]
+		return nil
+	}}
+	startService(t, f)
+
+	add(t, client, "data", "hello")
+	if v := <-values; v != "example" {
+		t.Fatalf("unexpected config value: %q", v)
+	}
+}
+
+// waitFor the condition to be true, failing with the given message if it is
+// not within a second.
+func waitFor(t *testing.T, cond func() bool, message string) {
+	t.Helper()
+	for deadline := time.Now().Add(time.Second); !cond(); {
+		if time.Now().After(deadline) {
+			t.Fatal(message)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
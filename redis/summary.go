@@ -0,0 +1,27 @@
+package redis
+
+import (
+	"fmt"
+
+	"github.com/rs/zerolog/log"
+
+	"knative.dev/func-go/internal/logs"
+)
+
+// logSummary logs the effective configuration of the service once
+// listening, such that misconfiguration (for example of the environment)
+// is apparent from a single log line.  Credentials in the server URL are
+// redacted.
+func (s *Service) logSummary(cfg redisConfig) {
+	log.Info().
+		Str("address", s.listener.Addr().String()).
+		Str("redis_address", logs.RedactURL(cfg.addr)).
+		Str("stream", cfg.stream).
+		Str("group", cfg.group).
+		Str("consumer", cfg.consumer).
+		Dur("claim_idle", cfg.claimIdle).
+		Int64("max_deliveries", cfg.maxDeliveries).
+		Str("handler", fmt.Sprintf("%T", s.f)).
+		Strs("implements", implemented(s.f)).
+		Msg("function runtime configured")
+}